@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/inventory"
+	"github.com/yossibmoha/NinjaIT/agent/internal/monitor"
+)
+
+// runCollect implements the collect subcommand, printing SystemMetrics JSON
+// to stdout without contacting the server, and returns the process exit code
+func runCollect(args []string) int {
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path to configuration file")
+	once := flags.Bool("once", false, "Collect a single sample and exit")
+	inv := flags.Bool("inventory", false, "Print the hardware and software inventory and exit")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	_ = flags.Parse(args)
+
+	setupCLILogging(*verbose)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	// Collection does not need a server connection
+	sysMonitor := monitor.NewSystemMonitor(cfg, nil)
+	encoder := json.NewEncoder(os.Stdout)
+
+	if *inv {
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(inventory.NewCollector(cfg, nil).Collect(context.Background())); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write inventory: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if *once {
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(sysMonitor.Collect()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write metrics: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Print one JSON document per line every check interval until interrupted,
+	// with the samples taken in between summarized as when running
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sysMonitor.RunSampling(ctx)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Duration(cfg.Agent.CheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := encoder.Encode(sysMonitor.Collect()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write metrics: %v\n", err)
+			return 1
+		}
+		select {
+		case <-sigChan:
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// setupCLILogging sends human-readable logs to stderr so stdout stays clean
+// for command output
+func setupCLILogging(verbose bool) {
+	log.SetFormatter(&log.TextFormatter{})
+	log.SetOutput(os.Stderr)
+	if verbose {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+}
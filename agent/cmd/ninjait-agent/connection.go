@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+)
+
+// runTestConnection implements the test-connection subcommand, checking each
+// step of the connection to the server, and returns the process exit code
+func runTestConnection(args []string) int {
+	flags := flag.NewFlagSet("test-connection", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path to configuration file")
+	timeout := flags.Duration("timeout", 30*time.Second, "Overall time limit for the checks")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	_ = flags.Parse(args)
+
+	setupCLILogging(*verbose)
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	fmt.Printf("Testing connection to %s\n\n", cfg.Server.URL)
+
+	steps := api.NewClient(cfg).Diagnose(ctx)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	exitCode := 0
+	for _, step := range steps {
+		result := "OK"
+		switch {
+		case step.Skipped:
+			result = "SKIP"
+		case !step.OK:
+			result = "FAIL"
+			exitCode = 1
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result, step.Name, step.Duration.Round(time.Millisecond), step.Detail)
+	}
+	_ = tw.Flush()
+
+	if exitCode == 0 {
+		fmt.Println("\nConnection OK")
+	}
+	return exitCode
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/service"
+)
+
+// runInstall implements the install subcommand and returns the process exit code
+func runInstall(args []string) int {
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path of the configuration file to write and run with")
+	serverURL := flags.String("server-url", "", "Server URL for a newly written configuration (required unless the configuration exists)")
+	apiKey := flags.String("api-key", "", "API key for a newly written configuration")
+	serviceUser := flags.String("user", "", "Run the service as this existing user (default root)")
+	binary := flags.String("binary", "", "Agent binary the service runs (default: this executable)")
+	force := flags.Bool("force", false, "Overwrite an existing configuration file")
+	noStart := flags.Bool("no-start", false, "Install without enabling and starting the service")
+	_ = flags.Parse(args)
+
+	setupCLILogging(false)
+
+	if *binary == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to locate agent binary: %v\n", err)
+			return 1
+		}
+		*binary = executable
+	}
+	binaryPath, err := filepath.Abs(*binary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid binary path: %v\n", err)
+		return 1
+	}
+	configPath, err := filepath.Abs(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config path: %v\n", err)
+		return 1
+	}
+
+	// A new configuration is useless without a server, so refuse before
+	// anything is written to disk
+	_, statErr := os.Stat(configPath)
+	if (errors.Is(statErr, os.ErrNotExist) || *force) && *serverURL == "" {
+		fmt.Fprintf(os.Stderr, "-server-url is required to write a new configuration to %s\n", configPath)
+		flags.Usage()
+		return 2
+	}
+
+	err = service.Install(service.Options{
+		Binary:     binaryPath,
+		ConfigFile: configPath,
+		User:       *serviceUser,
+		ServerURL:  *serverURL,
+		APIKey:     *apiKey,
+		Force:      *force,
+		Start:      !*noStart,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Install failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Installed %s (config %s)\n", service.Name, configPath)
+	return 0
+}
+
+// runUninstall implements the uninstall subcommand and returns the process exit code
+func runUninstall(args []string) int {
+	flags := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path of the configuration file")
+	purge := flags.Bool("purge", false, "Also remove the configuration file")
+	_ = flags.Parse(args)
+
+	setupCLILogging(false)
+
+	if err := service.Uninstall(*configFile, *purge); err != nil {
+		fmt.Fprintf(os.Stderr, "Uninstall failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Uninstalled %s\n", service.Name)
+	return 0
+}
@@ -6,34 +6,113 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
 	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/exporter"
+	"github.com/yossibmoha/NinjaIT/agent/internal/inventory"
+	"github.com/yossibmoha/NinjaIT/agent/internal/logs"
 	"github.com/yossibmoha/NinjaIT/agent/internal/monitor"
-	"github.com/yossibmoha/NinjaIT/agent/internal/api"
-	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/selfmon"
+	"github.com/yossibmoha/NinjaIT/agent/internal/snmp"
+	"github.com/yossibmoha/NinjaIT/agent/internal/status"
+	"github.com/yossibmoha/NinjaIT/agent/internal/update"
+	"github.com/yossibmoha/NinjaIT/agent/internal/version"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
 )
 
-const (
-	Version = "0.1.0"
-	AppName = "NinjaIT Agent"
-)
+// AppName is the product name shown in usage and logs
+const AppName = "NinjaIT Agent"
+
+// defaultConfigFile is used when -config is not given
+const defaultConfigFile = "/etc/ninjait/agent.yaml"
+
+// inventoryRetryDelay is how soon an inventory that failed to send is retried
+const inventoryRetryDelay = 5 * time.Minute
 
 func main() {
-	// Parse command line flags
-	configFile := flag.String("config", "/etc/ninjait/agent.yaml", "Path to configuration file")
-	verbose := flag.Bool("verbose", false, "Enable verbose logging")
-	version := flag.Bool("version", false, "Print version and exit")
-	flag.Parse()
+	flag.Usage = usage
+
+	// Flags without a subcommand run the agent, as before subcommands existed
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		// Roll back an update on trial that keeps failing, before anything
+		// in the new version can exit
+		update.GuardTrial()
+		if restart := runAgent(args); restart != nil {
+			// Deferred cleanup has run; replace the process with the new binary
+			if err := restart(); err != nil {
+				log.WithError(err).Error("Failed to restart agent, exiting for the service manager to restart it")
+				os.Exit(1)
+			}
+		}
+	case "collect":
+		os.Exit(runCollect(args))
+	case "test-connection":
+		os.Exit(runTestConnection(args))
+	case "install":
+		os.Exit(runInstall(args))
+	case "uninstall":
+		os.Exit(runUninstall(args))
+	case "status":
+		os.Exit(runStatus(args))
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// usage prints the available subcommands
+func usage() {
+	fmt.Fprintf(os.Stderr, `%s v%s
+
+Usage:
+  ninjait-agent [run] [flags]       Run the agent (default)
+  ninjait-agent collect [-once]     Collect metrics and print them as JSON
+  ninjait-agent test-connection     Check connectivity to the server step by step
+  ninjait-agent install [flags]     Install as a systemd service (Linux)
+  ninjait-agent uninstall [flags]   Remove the systemd service (Linux)
+  ninjait-agent status [flags]      Show the state of a running agent
+
+Run "ninjait-agent <command> -h" for the flags of each command.
+`, AppName, version.Version)
+}
+
+// runAgent runs the agent until it receives a shutdown signal. It returns a
+// restart function when it stopped because an update was installed or rolled
+// back.
+func runAgent(args []string) (restart func() error) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path to configuration file")
+	verbose := flags.Bool("verbose", false, "Enable verbose logging")
+	showVersion := flags.Bool("version", false, "Print version and exit")
+	checkConfig := flags.Bool("check-config", false, "Validate the configuration and exit")
+	printConfig := flags.Bool("print-config", false, "Print the effective configuration and where each value came from, then exit")
+	_ = flags.Parse(args)
 
 	// Print version
-	if *version {
-		fmt.Printf("%s v%s\n", AppName, Version)
+	if *showVersion {
+		fmt.Printf("%s v%s\n", AppName, version.Version)
 		os.Exit(0)
 	}
 
+	if *checkConfig || *printConfig {
+		os.Exit(checkConfiguration(*configFile, *printConfig))
+	}
+
 	// Initialize logger
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetOutput(os.Stdout)
@@ -43,8 +122,9 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	startedAt := time.Now()
 	log.WithFields(log.Fields{
-		"version": Version,
+		"version": version.Version,
 		"app":     AppName,
 	}).Info("Starting NinjaIT Agent")
 
@@ -69,11 +149,93 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
+	// SIGHUP triggers a configuration reload
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
 	// Initialize components
 	var wg sync.WaitGroup
 
-	// Initialize API client
+	updater, err := update.New(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize updater")
+	}
+
+	// Watch the configuration file for live reloads
+	cfgWatcher := config.NewWatcher(*configFile, cfg)
+	go cfgWatcher.Run(ctx)
+
+	// Subscribe before connecting so no pushed policy is missed
+	componentUpdates := cfgWatcher.Subscribe()
+	heartbeatUpdates := cfgWatcher.Subscribe()
+	monitorUpdates := cfgWatcher.Subscribe()
+	snmpUpdates := cfgWatcher.Subscribe()
+	logUpdates := cfgWatcher.Subscribe()
+	inventoryUpdates := cfgWatcher.Subscribe()
+
+	// Initialize API client; central policies pushed over WebSocket are merged into the configuration
 	apiClient := api.NewClient(cfg)
+	apiClient.OnPolicy(func(policy *models.EffectivePolicy) {
+		applyPolicy(cfgWatcher, updater, policy)
+	})
+
+	// Confirm or roll back a freshly installed version based on whether it
+	// manages to send a heartbeat
+	go updater.CheckTrial(ctx, func() time.Time {
+		return apiClient.Status().LastHeartbeat
+	})
+
+	// Initialize the log forwarder, which also sends threshold events
+	forwarder := logs.NewForwarder(apiClient)
+
+	// Initialize system monitor
+	sysMonitor := monitor.NewSystemMonitor(cfg, apiClient)
+	sysMonitor.SetEventSink(forwarder.Emit)
+
+	// Initialize self-monitoring, which throttles collection while the agent
+	// itself uses more than its limits
+	selfMonitor, err := selfmon.New(cfgWatcher.Current, cfgWatcher.SetThrottled)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize self-monitoring")
+	}
+
+	// Initialize SNMP poller for network devices polled on the server's behalf
+	snmpPoller := snmp.NewPoller(cfg, apiClient)
+
+	// Initialize inventory collector
+	invCollector := inventory.NewCollector(cfg, apiClient)
+
+	// Start the local status endpoint if enabled, before connecting so a
+	// failing connection can be diagnosed
+	if cfg.Status.Enabled {
+		statusServer := status.NewServer(cfg, status.Sources{
+			Version:    version.Version,
+			StartedAt:  startedAt,
+			Config:     cfgWatcher.Current,
+			Connection: apiClient.Status,
+			Collectors: sysMonitor.CollectorStatuses,
+			Resources:  selfMonitor.Latest,
+			LastSample: func() time.Time {
+				if latest := sysMonitor.Latest(); latest != nil {
+					return latest.Timestamp
+				}
+				return time.Time{}
+			},
+		})
+		go func() {
+			if err := statusServer.Start(); err != nil {
+				log.WithError(err).Error("Status endpoint failed")
+			}
+		}()
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			if err := statusServer.Shutdown(shutdownCtx); err != nil {
+				log.WithError(err).Warn("Status endpoint shutdown error")
+			}
+		}()
+	}
+
 	if err := apiClient.Connect(ctx); err != nil {
 		log.WithError(err).Fatal("Failed to connect to server")
 	}
@@ -81,28 +243,107 @@ func main() {
 
 	log.Info("Connected to NinjaIT server")
 
-	// Initialize system monitor
-	sysMonitor := monitor.NewSystemMonitor(cfg, apiClient)
+	// Start Prometheus exporter if enabled
+	if cfg.Exporter.Enabled {
+		promExporter := exporter.NewExporter(cfg, sysMonitor)
+		go func() {
+			if err := promExporter.Start(); err != nil {
+				log.WithError(err).Error("Prometheus exporter failed")
+			}
+		}()
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			if err := promExporter.Shutdown(shutdownCtx); err != nil {
+				log.WithError(err).Warn("Prometheus exporter shutdown error")
+			}
+		}()
+	}
+
+	// Apply reloaded configuration to the API client and monitor
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		applyConfigUpdates(ctx, componentUpdates, apiClient, sysMonitor, snmpPoller, invCollector)
+	}()
+
+	// Fetch the central policy; later changes arrive over WebSocket
+	if policy, err := apiClient.FetchPolicy(ctx); err != nil {
+		log.WithError(err).Warn("Failed to fetch central policy, using local configuration")
+	} else {
+		applyPolicy(cfgWatcher, updater, policy)
+	}
+
+	// Start self-monitoring goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		selfMonitor.Run(ctx)
+	}()
 
 	// Start heartbeat goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runHeartbeat(ctx, apiClient, cfg)
+		runHeartbeat(ctx, apiClient, selfMonitor, cfgWatcher.Current(), heartbeatUpdates)
 	}()
 
 	// Start monitoring goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runMonitoring(ctx, sysMonitor, cfg)
+		runMonitoring(ctx, sysMonitor, cfgWatcher.Current(), monitorUpdates)
+	}()
+
+	// Start sampling goroutine, summarized into each collection
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sysMonitor.RunSampling(ctx)
+	}()
+
+	// Start SNMP polling goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSNMP(ctx, snmpPoller, cfgWatcher.Current(), snmpUpdates)
+	}()
+
+	// Start inventory goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runInventory(ctx, invCollector, cfgWatcher.Current(), inventoryUpdates)
+	}()
+
+	// Start log forwarding goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		forwarder.Run(ctx, cfgWatcher.Current(), logUpdates)
 	}()
 
 	log.Info("Agent is running. Press Ctrl+C to stop.")
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Info("Shutdown signal received, stopping agent...")
+	// Wait for shutdown signal, reloading configuration on SIGHUP
+wait:
+	for {
+		select {
+		case <-hupChan:
+			log.Info("SIGHUP received, reloading configuration")
+			_ = cfgWatcher.Reload()
+		case <-updater.Restarts():
+			restart = updater.Restart
+			break wait
+		case <-sigChan:
+			break wait
+		}
+	}
+	if restart != nil {
+		log.Info("Agent binary changed, stopping agent to restart...")
+	} else {
+		log.Info("Shutdown signal received, stopping agent...")
+	}
 
 	// Cancel context to stop all goroutines
 	cancel()
@@ -120,11 +361,35 @@ func main() {
 	case <-time.After(10 * time.Second):
 		log.Warn("Forced shutdown after timeout")
 	}
+	return restart
+}
+
+// applyPolicy merges a central policy into the configuration and offers any
+// agent update it carries to the updater
+func applyPolicy(watcher *config.Watcher, updater *update.Updater, policy *models.EffectivePolicy) {
+	_ = watcher.SetPolicy(policy)
+	updater.Offer(policy.Update)
+}
+
+// applyConfigUpdates hands reloaded configuration to long-lived components
+func applyConfigUpdates(ctx context.Context, updates <-chan *config.Config, client *api.Client, mon *monitor.SystemMonitor, poller *snmp.Poller, inv *inventory.Collector) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cfg := <-updates:
+			client.UpdateConfig(ctx, cfg)
+			mon.UpdateConfig(cfg)
+			poller.UpdateConfig(cfg)
+			inv.UpdateConfig(cfg)
+		}
+	}
 }
 
 // runHeartbeat sends periodic heartbeat to server
-func runHeartbeat(ctx context.Context, client *api.Client, cfg *config.Config) {
-	ticker := time.NewTicker(time.Duration(cfg.Agent.HeartbeatInterval) * time.Second)
+func runHeartbeat(ctx context.Context, client *api.Client, self *selfmon.Monitor, cfg *config.Config, updates <-chan *config.Config) {
+	interval := time.Duration(cfg.Agent.HeartbeatInterval) * time.Second
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	log.Info("Heartbeat started")
@@ -134,8 +399,14 @@ func runHeartbeat(ctx context.Context, client *api.Client, cfg *config.Config) {
 		case <-ctx.Done():
 			log.Info("Heartbeat stopped")
 			return
+		case cfg := <-updates:
+			if next := time.Duration(cfg.Agent.HeartbeatInterval) * time.Second; next != interval {
+				interval = next
+				ticker.Reset(interval)
+				log.WithField("interval", interval).Info("Heartbeat interval updated")
+			}
 		case <-ticker.C:
-			if err := client.SendHeartbeat(); err != nil {
+			if err := client.SendHeartbeat(self.Latest()); err != nil {
 				log.WithError(err).Error("Failed to send heartbeat")
 			} else {
 				log.Debug("Heartbeat sent successfully")
@@ -145,8 +416,9 @@ func runHeartbeat(ctx context.Context, client *api.Client, cfg *config.Config) {
 }
 
 // runMonitoring collects and sends system metrics
-func runMonitoring(ctx context.Context, mon *monitor.SystemMonitor, cfg *config.Config) {
-	ticker := time.NewTicker(time.Duration(cfg.Agent.CheckInterval) * time.Second)
+func runMonitoring(ctx context.Context, mon *monitor.SystemMonitor, cfg *config.Config, updates <-chan *config.Config) {
+	interval := time.Duration(cfg.Agent.CheckInterval) * time.Second
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	log.Info("System monitoring started")
@@ -161,6 +433,12 @@ func runMonitoring(ctx context.Context, mon *monitor.SystemMonitor, cfg *config.
 		case <-ctx.Done():
 			log.Info("System monitoring stopped")
 			return
+		case cfg := <-updates:
+			if next := time.Duration(cfg.Agent.CheckInterval) * time.Second; next != interval {
+				interval = next
+				ticker.Reset(interval)
+				log.WithField("interval", interval).Info("Check interval updated")
+			}
 		case <-ticker.C:
 			if err := mon.CollectAndSend(); err != nil {
 				log.WithError(err).Error("Failed to collect metrics")
@@ -171,3 +449,83 @@ func runMonitoring(ctx context.Context, mon *monitor.SystemMonitor, cfg *config.
 	}
 }
 
+// runSNMP polls the configured SNMP targets every check interval
+func runSNMP(ctx context.Context, poller *snmp.Poller, cfg *config.Config, updates <-chan *config.Config) {
+	interval := time.Duration(cfg.Agent.CheckInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Info("SNMP polling started")
+
+	if err := poller.PollAndSend(ctx); err != nil {
+		log.WithError(err).Error("Failed to send initial SNMP metrics")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("SNMP polling stopped")
+			return
+		case cfg := <-updates:
+			if next := time.Duration(cfg.Agent.CheckInterval) * time.Second; next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+		case <-ticker.C:
+			if err := poller.PollAndSend(ctx); err != nil {
+				log.WithError(err).Error("Failed to send SNMP metrics")
+			}
+		}
+	}
+}
+
+// runInventory sends the inventory at startup and then every inventory
+// interval, retrying sooner when sending fails
+func runInventory(ctx context.Context, collector *inventory.Collector, cfg *config.Config, updates <-chan *config.Config) {
+	interval := time.Duration(cfg.Inventory.Interval) * time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	log.Info("Inventory collection started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Inventory collection stopped")
+			return
+		case cfg := <-updates:
+			if next := time.Duration(cfg.Inventory.Interval) * time.Second; next != interval {
+				interval = next
+				timer.Reset(interval)
+			}
+		case <-timer.C:
+			next := interval
+			if err := collector.CollectAndSend(ctx); err != nil {
+				log.WithError(err).Error("Failed to send inventory")
+				next = min(interval, inventoryRetryDelay)
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+// checkConfiguration validates the configuration, optionally printing every
+// effective setting with its source, and returns the process exit code
+func checkConfiguration(configFile string, printSettings bool) int {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid: %v\n", err)
+		return 1
+	}
+
+	if printSettings {
+		if err := cfg.PrintSettings(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Configuration is valid (%s)\n", configFile)
+	return 0
+}
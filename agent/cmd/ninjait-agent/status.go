@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/status"
+)
+
+// runStatus implements the status subcommand, printing the state reported by
+// a running agent's status endpoint, and returns the process exit code
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile, "Path to configuration file")
+	address := flags.String("address", "", "Status endpoint address (default: status.listen_address from the configuration)")
+	asJSON := flags.Bool("json", false, "Print the raw JSON report")
+	_ = flags.Parse(args)
+
+	if *address == "" {
+		cfg, err := config.Load(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		*address = cfg.Status.ListenAddress
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, err := status.Fetch(ctx, *address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = status.Print(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print status: %v\n", err)
+		return 1
+	}
+	return 0
+}
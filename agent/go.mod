@@ -3,10 +3,14 @@ module github.com/yossibmoha/NinjaIT/agent
 go 1.21
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/shirou/gopsutil/v3 v3.24.5
 	github.com/gorilla/websocket v1.5.3
+	github.com/gosnmp/gosnmp v1.38.0
 	github.com/joho/godotenv v1.5.1
+	github.com/prometheus/client_golang v1.19.0
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/net v0.20.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -5,21 +5,58 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
 	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/version"
 	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
-	log "github.com/sirupsen/logrus"
+)
+
+// WebSocket reconnects back off exponentially between these delays; a
+// connection that stays up for wsMaxBackoff resets the delay
+const (
+	wsMinBackoff = time.Second
+	wsMaxBackoff = time.Minute
 )
 
 // Client handles communication with the NinjaIT server
 type Client struct {
+	mu         sync.RWMutex
 	config     *config.Config
 	httpClient *http.Client
 	wsConn     *websocket.Conn
+	wsCancel   context.CancelFunc // stops the WebSocket reconnect loop
 	connected  bool
+	onPolicy   func(*models.EffectivePolicy)
+
+	lastHeartbeat time.Time
+	lastMetrics   time.Time
+	lastEvents    time.Time
+	lastInventory time.Time
+	lastError     string
+	lastErrorAt   time.Time
+	sendFailures  uint64
+}
+
+// ConnectionStatus describes the agent's connection to the server
+type ConnectionStatus struct {
+	ServerURL          string    `json:"server_url"`
+	Connected          bool      `json:"connected"`
+	WebSocketConnected bool      `json:"websocket_connected"`
+	LastHeartbeat      time.Time `json:"last_heartbeat"`
+	LastMetrics        time.Time `json:"last_metrics"`
+	LastEvents         time.Time `json:"last_events"`
+	LastInventory      time.Time `json:"last_inventory"`
+	LastError          string    `json:"last_error,omitempty"`
+	LastErrorAt        time.Time `json:"last_error_at"`
+	SendFailures       uint64    `json:"send_failures"`
 }
 
 // NewClient creates a new API client
@@ -36,7 +73,26 @@ func NewClient(cfg *config.Config) *Client {
 // Connect establishes connection to the server
 func (c *Client) Connect(ctx context.Context) error {
 	// Test HTTP connection
-	req, err := http.NewRequestWithContext(ctx, "GET", c.config.Server.URL+"/health", nil)
+	cfg := c.currentConfig()
+	if err := c.checkHealth(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+
+	if cfg.Server.WSEnabled {
+		c.startWebSocket(ctx)
+	}
+
+	return nil
+}
+
+// checkHealth calls the server's health endpoint
+func (c *Client) checkHealth(ctx context.Context) error {
+	cfg := c.currentConfig()
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.Server.URL+"/health", nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -50,47 +106,195 @@ func (c *Client) Connect(ctx context.Context) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("server health check failed: status %d", resp.StatusCode)
 	}
+	return nil
+}
 
-	c.connected = true
+// OnPolicy registers the handler for central policies, both fetched on
+// connect and pushed by the server over WebSocket. Call before Connect.
+func (c *Client) OnPolicy(handler func(*models.EffectivePolicy)) {
+	c.onPolicy = handler
+}
 
-	// TODO: Optionally connect via WebSocket for real-time communication
-	if c.config.Server.WSEnabled {
-		go c.connectWebSocket(ctx)
+// FetchPolicy retrieves the effective central policy for this agent
+func (c *Client) FetchPolicy(ctx context.Context) (*models.EffectivePolicy, error) {
+	cfg := c.currentConfig()
+
+	query := url.Values{}
+	query.Set("tenant_id", cfg.Agent.TenantID)
+	query.Set("group", cfg.Agent.Group)
+	// The server picks the update artifact built for this platform
+	query.Set("os", runtime.GOOS)
+	query.Set("arch", runtime.GOARCH)
+	endpoint := fmt.Sprintf("%s/api/v1/agents/%s/policy?%s", cfg.Server.URL, url.PathEscape(cfg.Agent.DeviceID), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if cfg.Server.APIKey != "" {
+		req.Header.Set("X-API-Key", cfg.Server.APIKey.Value())
 	}
 
-	return nil
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	var policy models.EffectivePolicy
+	if err := json.NewDecoder(resp.Body).Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+	return &policy, nil
 }
 
 // Close closes the connection to the server
 func (c *Client) Close() error {
+	c.mu.Lock()
 	c.connected = false
-	if c.wsConn != nil {
-		return c.wsConn.Close()
+	c.mu.Unlock()
+	return c.closeWebSocket()
+}
+
+// Status reports the connection state and the outcome of recent sends
+func (c *Client) Status() ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return ConnectionStatus{
+		ServerURL:          c.config.Server.URL,
+		Connected:          c.connected,
+		WebSocketConnected: c.wsConn != nil,
+		LastHeartbeat:      c.lastHeartbeat,
+		LastMetrics:        c.lastMetrics,
+		LastEvents:         c.lastEvents,
+		LastInventory:      c.lastInventory,
+		LastError:          c.lastError,
+		LastErrorAt:        c.lastErrorAt,
+		SendFailures:       c.sendFailures,
+	}
+}
+
+// UpdateConfig applies a reloaded configuration, reconnecting the WebSocket
+// when the server settings changed
+func (c *Client) UpdateConfig(ctx context.Context, cfg *config.Config) {
+	c.mu.Lock()
+	previous := c.config
+	c.config = cfg
+	c.mu.Unlock()
+
+	if previous.Server == cfg.Server {
+		return
+	}
+
+	log.WithField("server_url", cfg.Server.URL).Info("Server settings changed, reconnecting")
+	if err := c.closeWebSocket(); err != nil {
+		log.WithError(err).Debug("Failed to close WebSocket")
+	}
+	c.mu.RLock()
+	connected := c.connected
+	c.mu.RUnlock()
+	if connected && cfg.Server.WSEnabled {
+		c.startWebSocket(ctx)
+	}
+}
+
+// currentConfig returns the configuration in effect
+func (c *Client) currentConfig() *config.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// closeWebSocket stops reconnecting and closes the WebSocket connection if
+// one is open
+func (c *Client) closeWebSocket() error {
+	c.mu.Lock()
+	conn := c.wsConn
+	c.wsConn = nil
+	if c.wsCancel != nil {
+		c.wsCancel()
+		c.wsCancel = nil
+	}
+	c.mu.Unlock()
+
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
-// SendHeartbeat sends a heartbeat to the server
-func (c *Client) SendHeartbeat() error {
+// SendHeartbeat sends a heartbeat to the server with the agent's own
+// resource usage, if measured. A throttled agent reports itself degraded.
+func (c *Client) SendHeartbeat(resources *models.AgentResources) error {
+	cfg := c.currentConfig()
+	status := "online"
+	if resources != nil && resources.Throttled {
+		status = "degraded"
+	}
 	heartbeat := models.Heartbeat{
-		DeviceID:  c.config.Agent.DeviceID,
-		Hostname:  c.config.Agent.Hostname,
-		Timestamp: time.Now(),
-		Status:    "online",
-		Version:   "0.1.0",
+		DeviceID:      cfg.Agent.DeviceID,
+		Hostname:      cfg.Agent.Hostname,
+		Timestamp:     time.Now(),
+		Status:        status,
+		Version:       version.Version,
+		TenantID:      cfg.Agent.TenantID,
+		Group:         cfg.Agent.Group,
+		PolicyVersion: cfg.PolicyVersion,
+		Resources:     resources,
 	}
 
-	return c.sendJSON("/api/agent/heartbeat", heartbeat)
+	err := c.sendJSON("/api/v1/heartbeat", heartbeat)
+	c.recordSend(&c.lastHeartbeat, err)
+	return err
 }
 
 // SendMetrics sends system metrics to the server
 func (c *Client) SendMetrics(metrics *models.SystemMetrics) error {
-	return c.sendJSON("/api/agent/metrics", metrics)
+	err := c.sendJSON("/api/v1/metrics", metrics)
+	c.recordSend(&c.lastMetrics, err)
+	return err
+}
+
+// SendEvents sends a batch of log events to the server
+func (c *Client) SendEvents(batch *models.EventBatch) error {
+	err := c.sendJSON("/api/v1/events", batch)
+	c.recordSend(&c.lastEvents, err)
+	return err
+}
+
+// SendInventory sends the device's hardware and software inventory to the server
+func (c *Client) SendInventory(inv *models.Inventory) error {
+	err := c.sendJSON("/api/v1/inventory", inv)
+	c.recordSend(&c.lastInventory, err)
+	return err
+}
+
+// recordSend updates the send statistics reported by Status, setting
+// *lastSuccess when err is nil
+func (c *Client) recordSend(lastSuccess *time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.lastError = err.Error()
+		c.lastErrorAt = time.Now()
+		c.sendFailures++
+		return
+	}
+	*lastSuccess = time.Now()
 }
 
 // sendJSON sends a JSON payload to the server
 func (c *Client) sendJSON(endpoint string, payload interface{}) error {
-	if !c.connected {
+	c.mu.RLock()
+	connected := c.connected
+	c.mu.RUnlock()
+	if !connected {
 		return fmt.Errorf("not connected to server")
 	}
 
@@ -101,7 +305,8 @@ func (c *Client) sendJSON(endpoint string, payload interface{}) error {
 	}
 
 	// Create request
-	url := c.config.Server.URL + endpoint
+	cfg := c.currentConfig()
+	url := cfg.Server.URL + endpoint
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -109,8 +314,8 @@ func (c *Client) sendJSON(endpoint string, payload interface{}) error {
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	if c.config.Server.APIKey != "" {
-		req.Header.Set("X-API-Key", c.config.Server.APIKey)
+	if cfg.Server.APIKey != "" {
+		req.Header.Set("X-API-Key", cfg.Server.APIKey.Value())
 	}
 
 	// Send request
@@ -128,42 +333,120 @@ func (c *Client) sendJSON(endpoint string, payload interface{}) error {
 	return nil
 }
 
-// connectWebSocket establishes WebSocket connection for real-time communication
-func (c *Client) connectWebSocket(ctx context.Context) {
-	wsURL := c.config.Server.URL
+// startWebSocket keeps the agent WebSocket connected in the background until
+// ctx is cancelled or closeWebSocket is called
+func (c *Client) startWebSocket(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	if c.wsCancel != nil {
+		c.wsCancel()
+	}
+	c.wsCancel = cancel
+	c.mu.Unlock()
+
+	go c.runWebSocket(ctx)
+}
+
+// runWebSocket dials the server and listens for messages, redialing with
+// capped exponential backoff whenever the connection fails or drops. The
+// policy is fetched again after every reconnect, since pushes sent while the
+// agent was disconnected are lost.
+func (c *Client) runWebSocket(ctx context.Context) {
+	backoff := wsMinBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := c.dialWebSocket(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithError(err).WithField("retry_in", backoff.String()).Warn("Failed to connect WebSocket")
+		} else {
+			c.mu.Lock()
+			c.wsConn = conn
+			c.mu.Unlock()
+			log.Info("WebSocket connected")
+
+			// The first connection follows the policy fetch done at startup
+			if attempt > 0 {
+				c.refreshPolicy(ctx)
+			}
+
+			connected := time.Now()
+			c.listenWebSocket(ctx, conn)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(connected) >= wsMaxBackoff {
+				backoff = wsMinBackoff
+			}
+			log.WithField("retry_in", backoff.String()).Info("WebSocket disconnected, reconnecting")
+		}
+
+		// Jitter spreads the reconnects of many agents after a server restart
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, wsMaxBackoff)
+	}
+}
+
+// refreshPolicy fetches the central policy and hands it to the policy
+// handler, picking up changes pushed while the WebSocket was down
+func (c *Client) refreshPolicy(ctx context.Context) {
+	if c.onPolicy == nil {
+		return
+	}
+	policy, err := c.FetchPolicy(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch central policy after reconnecting")
+		return
+	}
+	c.onPolicy(policy)
+}
+
+// dialWebSocket opens the agent WebSocket to the server
+func (c *Client) dialWebSocket(ctx context.Context) (*websocket.Conn, error) {
+	cfg := c.currentConfig()
+	wsURL := cfg.Server.URL
 	// Convert http:// to ws:// and https:// to wss://
 	if len(wsURL) > 7 && wsURL[:7] == "http://" {
 		wsURL = "ws://" + wsURL[7:]
 	} else if len(wsURL) > 8 && wsURL[:8] == "https://" {
 		wsURL = "wss://" + wsURL[8:]
 	}
-	wsURL += "/ws/agent"
+	query := url.Values{}
+	query.Set("device_id", cfg.Agent.DeviceID)
+	query.Set("tenant_id", cfg.Agent.TenantID)
+	query.Set("group", cfg.Agent.Group)
+	query.Set("os", runtime.GOOS)
+	query.Set("arch", runtime.GOARCH)
+	wsURL += "/ws/agent?" + query.Encode()
 
 	header := http.Header{}
-	if c.config.Server.APIKey != "" {
-		header.Set("X-API-Key", c.config.Server.APIKey)
-	}
-
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
-	if err != nil {
-		log.WithError(err).Warn("Failed to connect WebSocket")
-		return
+	if cfg.Server.APIKey != "" {
+		header.Set("X-API-Key", cfg.Server.APIKey.Value())
 	}
 
-	c.wsConn = conn
-	log.Info("WebSocket connected")
-
-	// Listen for messages
-	go c.listenWebSocket(ctx)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	return conn, err
 }
 
-// listenWebSocket listens for messages from the server
-func (c *Client) listenWebSocket(ctx context.Context) {
+// listenWebSocket listens for messages from the server until the connection
+// fails or ctx is cancelled
+func (c *Client) listenWebSocket(ctx context.Context, conn *websocket.Conn) {
+	// ReadMessage does not watch ctx; closing the connection unblocks it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 	defer func() {
-		if c.wsConn != nil {
-			c.wsConn.Close()
+		conn.Close()
+		c.mu.Lock()
+		if c.wsConn == conn {
 			c.wsConn = nil
 		}
+		c.mu.Unlock()
 	}()
 
 	for {
@@ -171,15 +454,34 @@ func (c *Client) listenWebSocket(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			_, message, err := c.wsConn.ReadMessage()
+			_, message, err := conn.ReadMessage()
 			if err != nil {
-				log.WithError(err).Warn("WebSocket read error")
+				if ctx.Err() == nil {
+					log.WithError(err).Warn("WebSocket read error")
+				}
 				return
 			}
 
-			// TODO: Handle incoming commands from server
 			log.WithField("message", string(message)).Debug("Received WebSocket message")
+			c.handleMessage(message)
 		}
 	}
 }
 
+// handleMessage dispatches a message pushed by the server
+func (c *Client) handleMessage(message []byte) {
+	var msg models.AgentMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		log.WithError(err).Warn("Failed to decode WebSocket message")
+		return
+	}
+
+	switch msg.Type {
+	case "policy":
+		if msg.Policy != nil && c.onPolicy != nil {
+			c.onPolicy(msg.Policy)
+		}
+	default:
+		log.WithField("type", msg.Type).Debug("Ignoring unknown WebSocket message")
+	}
+}
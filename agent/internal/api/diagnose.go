@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DiagnosticStep is the outcome of one connection check
+type DiagnosticStep struct {
+	Name     string
+	OK       bool
+	Skipped  bool
+	Duration time.Duration
+	Detail   string
+}
+
+// Diagnose checks each layer of the connection to the server in turn: DNS,
+// TCP, TLS, the health check Connect performs, API key authentication and the
+// WebSocket. Steps after a failed step are skipped.
+func (c *Client) Diagnose(ctx context.Context) []DiagnosticStep {
+	cfg := c.currentConfig()
+	var steps []DiagnosticStep
+	failed := false
+
+	run := func(name string, check func() (string, error)) {
+		if failed {
+			steps = append(steps, DiagnosticStep{Name: name, Skipped: true, Detail: "skipped after earlier failure"})
+			return
+		}
+		start := time.Now()
+		detail, err := check()
+		step := DiagnosticStep{Name: name, OK: err == nil, Duration: time.Since(start), Detail: detail}
+		if err != nil {
+			step.Detail = err.Error()
+			failed = true
+		}
+		steps = append(steps, step)
+	}
+
+	u, err := url.Parse(cfg.Server.URL)
+	if err != nil {
+		return []DiagnosticStep{{Name: "parse server URL", Detail: err.Error()}}
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(host, port)
+
+	run("resolve "+host, func() (string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(addrs, ", "), nil
+	})
+
+	run("connect "+address, func() (string, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return "connected to " + conn.RemoteAddr().String(), nil
+	})
+
+	if u.Scheme == "https" {
+		run("TLS handshake", func() (string, error) {
+			dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+
+			state := conn.(*tls.Conn).ConnectionState()
+			cert := state.PeerCertificates[0]
+			return fmt.Sprintf("%s, certificate %q expires %s",
+				tls.VersionName(state.Version), cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)), nil
+		})
+	}
+
+	run("health check", func() (string, error) {
+		if err := c.checkHealth(ctx); err != nil {
+			return "", err
+		}
+		return "GET /health returned 200", nil
+	})
+
+	run("authentication", func() (string, error) {
+		if cfg.Server.APIKey == "" {
+			return "", fmt.Errorf("no API key configured")
+		}
+		if _, err := c.FetchPolicy(ctx); err != nil {
+			return "", err
+		}
+		return "API key accepted", nil
+	})
+
+	if cfg.Server.WSEnabled {
+		run("WebSocket", func() (string, error) {
+			conn, err := c.dialWebSocket(ctx)
+			if err != nil {
+				return "", err
+			}
+			conn.Close()
+			return "upgrade succeeded", nil
+		})
+	}
+
+	return steps
+}
@@ -1,25 +1,61 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v3"
 )
 
 // Config holds the agent configuration
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	Agent  AgentConfig  `yaml:"agent"`
-	Security SecurityConfig `yaml:"security"`
+	Server     ServerConfig     `yaml:"server"`
+	Agent      AgentConfig      `yaml:"agent"`
+	Security   SecurityConfig   `yaml:"security"`
+	Exporter   ExporterConfig   `yaml:"exporter"`
+	Status     StatusConfig     `yaml:"status"`
+	Update     UpdateConfig     `yaml:"update"`
+	Disk       DiskConfig       `yaml:"disk"`
+	Systemd    SystemdConfig    `yaml:"systemd"`
+	Containers ContainersConfig `yaml:"containers"`
+	Checks     []CheckConfig    `yaml:"checks"`
+	Scripts    []ScriptConfig   `yaml:"scripts"`
+	SNMP       []SNMPTarget     `yaml:"snmp"`
+	Logs       LogsConfig       `yaml:"logs"`
+	Inventory  InventoryConfig  `yaml:"inventory"`
+	Limits     LimitsConfig     `yaml:"limits"`
+
+	// Thresholds raise an event when a metric crosses them, keyed by one of
+	// ThresholdNames
+	Thresholds map[string]float64 `yaml:"thresholds"`
+
+	// PolicyVersion is the version of the central policy merged into this
+	// configuration, or 0 when only local settings apply
+	PolicyVersion int64 `yaml:"-"`
+
+	// Throttled is set while the agent's own resource usage is over a limit
+	// and it runs with reduced collection; see WithThrottle
+	Throttled bool `yaml:"-"`
+
+	// Sources records where each setting came from, keyed by YAML path.
+	// Settings not listed keep their built-in default.
+	Sources map[string]string `yaml:"-"`
 }
 
 // ServerConfig holds server connection details
 type ServerConfig struct {
 	URL       string `yaml:"url"`
-	APIKey    string `yaml:"api_key"`
+	APIKey    Secret `yaml:"api_key"`
 	WSEnabled bool   `yaml:"ws_enabled"`
 }
 
@@ -27,64 +63,415 @@ type ServerConfig struct {
 type AgentConfig struct {
 	DeviceID          string `yaml:"device_id"`
 	Hostname          string `yaml:"hostname"`
+	TenantID          string `yaml:"tenant_id"`          // used to resolve central policies
+	Group             string `yaml:"group"`              // used to resolve central policies
 	CheckInterval     int    `yaml:"check_interval"`     // seconds
 	HeartbeatInterval int    `yaml:"heartbeat_interval"` // seconds
+	CollectorTimeout  int    `yaml:"collector_timeout"`  // seconds one collector may take, default 10
+	CollectionBudget  int    `yaml:"collection_budget"`  // seconds a whole collection may take; 0 uses three quarters of check_interval
+	SampleInterval    int    `yaml:"sample_interval"`    // seconds between CPU, memory, disk and network samples summarized in each upload; 0 disables
 	EnableCPU         bool   `yaml:"enable_cpu"`
 	EnableMemory      bool   `yaml:"enable_memory"`
 	EnableDisk        bool   `yaml:"enable_disk"`
 	EnableNetwork     bool   `yaml:"enable_network"`
 	EnableProcesses   bool   `yaml:"enable_processes"`
+	EnableSystemd     bool   `yaml:"enable_systemd"`    // Linux only
+	EnableSensors     bool   `yaml:"enable_sensors"`    // temperatures everywhere; fans and power on Linux
+	EnableContainers  bool   `yaml:"enable_containers"` // Linux only
+	EnableChecks      bool   `yaml:"enable_checks"`     // run the synthetic checks listed under checks
+	EnableScripts     bool   `yaml:"enable_scripts"`    // run the check scripts listed under scripts
+	EnableSNMP        bool   `yaml:"enable_snmp"`       // poll the SNMP targets listed under snmp
+	EnableLogs        bool   `yaml:"enable_logs"`       // forward matching lines of the sources listed under logs
+	EnableInventory   bool   `yaml:"enable_inventory"`  // report hardware and installed packages when they change
+}
+
+// CollectorTimeoutDuration returns how long one collector may take, 10
+// seconds when unset
+func (a AgentConfig) CollectorTimeoutDuration() time.Duration {
+	if a.CollectorTimeout == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(a.CollectorTimeout) * time.Second
+}
+
+// CollectionBudgetDuration returns how long a whole collection may take,
+// three quarters of the check interval when unset
+func (a AgentConfig) CollectionBudgetDuration() time.Duration {
+	if a.CollectionBudget == 0 {
+		return time.Duration(a.CheckInterval) * time.Second * 3 / 4
+	}
+	return time.Duration(a.CollectionBudget) * time.Second
 }
 
 // SecurityConfig holds security settings
 type SecurityConfig struct {
-	EnableTLS       bool   `yaml:"enable_tls"`
-	TLSCert         string `yaml:"tls_cert"`
-	TLSKey          string `yaml:"tls_key"`
-	VerifySSL       bool   `yaml:"verify_ssl"`
-	EncryptMetrics  bool   `yaml:"encrypt_metrics"`
+	EnableTLS      bool   `yaml:"enable_tls"`
+	TLSCert        string `yaml:"tls_cert"`
+	TLSKey         string `yaml:"tls_key"`
+	VerifySSL      bool   `yaml:"verify_ssl"`
+	EncryptMetrics bool   `yaml:"encrypt_metrics"`
+}
+
+// ExporterConfig holds settings for the local Prometheus exporter
+type ExporterConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	ListenAddress string `yaml:"listen_address"`
+	Path          string `yaml:"path"`
+}
+
+// StatusConfig holds settings for the local status and diagnostics endpoint
+type StatusConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	ListenAddress string `yaml:"listen_address"` // loopback host:port, or unix:/path/to/socket
+}
+
+// UpdateConfig holds self-update settings
+type UpdateConfig struct {
+	Enabled     bool   `yaml:"enabled"`      // apply agent versions advertised by the server
+	GracePeriod int    `yaml:"grace_period"` // seconds a new version has to send a heartbeat before rollback
+	StateDir    string `yaml:"state_dir"`
+}
+
+// DiskConfig selects the filesystems the disk collector reports. A
+// filesystem is reported when it matches every include list that is set and
+// no exclude list.
+type DiskConfig struct {
+	IncludeFSTypes     []string `yaml:"include_fs_types"`
+	ExcludeFSTypes     []string `yaml:"exclude_fs_types"`
+	IncludeMountpoints []string `yaml:"include_mountpoints"` // glob patterns, e.g. /data/*
+	ExcludeMountpoints []string `yaml:"exclude_mountpoints"` // glob patterns
+	IncludeDevices     []string `yaml:"include_devices"`     // glob patterns, e.g. /dev/sd*
+	ExcludeDevices     []string `yaml:"exclude_devices"`     // glob patterns
+	Timeout            int      `yaml:"timeout"`             // seconds to read the usage of one mount, default 5
+}
+
+// TimeoutDuration returns the per-mount timeout, 5 seconds when unset
+func (d DiskConfig) TimeoutDuration() time.Duration {
+	if d.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(d.Timeout) * time.Second
+}
+
+// Reports reports whether a filesystem passes the include and exclude rules.
+// Patterns are validated when the configuration is loaded.
+func (d DiskConfig) Reports(device, mountpoint, fsType string) bool {
+	matchesAny := func(patterns []string, value string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, value); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(d.IncludeFSTypes) > 0 && !slices.Contains(d.IncludeFSTypes, fsType) {
+		return false
+	}
+	if len(d.IncludeMountpoints) > 0 && !matchesAny(d.IncludeMountpoints, mountpoint) {
+		return false
+	}
+	if len(d.IncludeDevices) > 0 && !matchesAny(d.IncludeDevices, device) {
+		return false
+	}
+	return !slices.Contains(d.ExcludeFSTypes, fsType) &&
+		!matchesAny(d.ExcludeMountpoints, mountpoint) &&
+		!matchesAny(d.ExcludeDevices, device)
+}
+
+// SystemdConfig selects the systemd units the systemd collector reports
+type SystemdConfig struct {
+	Units         []string `yaml:"units"`          // watch list; names without a suffix are treated as services
+	IncludeFailed bool     `yaml:"include_failed"` // also report every unit currently in the failed state
+}
+
+// ContainersConfig controls where the container collector finds containers
+type ContainersConfig struct {
+	CgroupRoot   string `yaml:"cgroup_root"`   // cgroup v1 or v2 mount
+	DockerSocket string `yaml:"docker_socket"` // adds names, images and restart counts; empty disables
+}
+
+// CheckConfig describes a synthetic check the agent runs against another host
+type CheckConfig struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`            // tcp, http, dns or icmp
+	Target         string `yaml:"target"`          // host:port for tcp, URL for http, host name for dns and icmp
+	Timeout        int    `yaml:"timeout"`         // seconds, default 5
+	ExpectedStatus int    `yaml:"expected_status"` // http: required status code; 0 accepts any 2xx or 3xx
+	ExpectedBody   string `yaml:"expected_body"`   // http: substring the response body must contain
+	MinCertDays    int    `yaml:"min_cert_days"`   // http: fail when the certificate expires sooner
+	SkipVerify     bool   `yaml:"skip_verify"`     // http: accept untrusted certificates
+	Resolver       string `yaml:"resolver"`        // dns: host:port of the server to ask instead of the system resolver
+}
+
+// TimeoutDuration returns the check timeout, 5 seconds when unset
+func (c CheckConfig) TimeoutDuration() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// ScriptConfig describes a Nagios-compatible check script the agent runs
+type ScriptConfig struct {
+	Name     string   `yaml:"name"`
+	Command  string   `yaml:"command"` // absolute path; run directly, not through a shell
+	Args     []string `yaml:"args"`
+	Timeout  int      `yaml:"timeout"`  // seconds, default 30
+	Interval int      `yaml:"interval"` // seconds between runs, default agent.check_interval
+}
+
+// TimeoutDuration returns the script timeout, 30 seconds when unset
+func (s ScriptConfig) TimeoutDuration() time.Duration {
+	if s.Timeout == 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(s.Timeout) * time.Second
+}
+
+// SNMPTarget describes a network device the agent polls over SNMP and
+// reports as a device of its own
+type SNMPTarget struct {
+	Name         string    `yaml:"name"`          // host name the device is reported under
+	DeviceID     string    `yaml:"device_id"`     // default snmp-<name>
+	Address      string    `yaml:"address"`       // host or host:port; the port defaults to 161
+	Version      string    `yaml:"version"`       // 2c (default) or 3
+	Community    Secret    `yaml:"community"`     // v2c
+	Username     string    `yaml:"username"`      // v3
+	AuthProtocol string    `yaml:"auth_protocol"` // v3: MD5, SHA, SHA224, SHA256, SHA384 or SHA512; empty for noAuthNoPriv
+	AuthPassword Secret    `yaml:"auth_password"` // v3
+	PrivProtocol string    `yaml:"priv_protocol"` // v3: DES, AES, AES192 or AES256; empty for no privacy
+	PrivPassword Secret    `yaml:"priv_password"` // v3
+	Timeout      int       `yaml:"timeout"`       // seconds per request, default 5
+	Retries      int       `yaml:"retries"`
+	OIDs         []SNMPOID `yaml:"oids"` // polled in addition to system and interface data
+}
+
+// SNMPOID names an OID to poll
+type SNMPOID struct {
+	Name string `yaml:"name"`
+	OID  string `yaml:"oid"`
+}
+
+// ID returns the device ID the target is reported under
+func (t SNMPTarget) ID() string {
+	if t.DeviceID != "" {
+		return t.DeviceID
+	}
+	return "snmp-" + t.Name
 }
 
+// TimeoutDuration returns the per-request timeout, 5 seconds when unset
+func (t SNMPTarget) TimeoutDuration() time.Duration {
+	if t.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(t.Timeout) * time.Second
+}
+
+// SNMPAuthProtocols and SNMPPrivProtocols are the supported SNMPv3 protocols
+var (
+	SNMPAuthProtocols = []string{"MD5", "SHA", "SHA224", "SHA256", "SHA384", "SHA512"}
+	SNMPPrivProtocols = []string{"DES", "AES", "AES192", "AES256"}
+)
+
+// snmpDeviceIDPattern matches the device IDs the server accepts
+var snmpDeviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// oidPattern matches a numeric OID, optionally with a leading dot
+var oidPattern = regexp.MustCompile(`^\.?[0-9]+(\.[0-9]+)+$`)
+
+// LogsConfig selects the logs whose matching lines are forwarded as events
+type LogsConfig struct {
+	StateDir  string      `yaml:"state_dir"`  // where read positions are kept across restarts
+	RateLimit int         `yaml:"rate_limit"` // events per source per minute; further matches are counted and dropped
+	Sources   []LogSource `yaml:"sources"`
+}
+
+// LogSource is a file or the systemd journal to watch for matching lines
+type LogSource struct {
+	Name    string   `yaml:"name"`
+	Path    string   `yaml:"path"`    // file to tail
+	Journal bool     `yaml:"journal"` // read the systemd journal instead of a file (Linux only)
+	Units   []string `yaml:"units"`   // journal: only these units; empty reads every unit
+	Include []string `yaml:"include"` // regular expressions; a line is forwarded when it matches one
+	Exclude []string `yaml:"exclude"` // regular expressions; a line matching any of them is ignored
+}
+
+// Patterns compiles the source's include and exclude expressions
+func (s LogSource) Patterns() (include, exclude []*regexp.Regexp, err error) {
+	compile := func(exprs []string) ([]*regexp.Regexp, error) {
+		patterns := make([]*regexp.Regexp, 0, len(exprs))
+		for _, expr := range exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", expr, err)
+			}
+			patterns = append(patterns, re)
+		}
+		return patterns, nil
+	}
+
+	if include, err = compile(s.Include); err != nil {
+		return nil, nil, err
+	}
+	if exclude, err = compile(s.Exclude); err != nil {
+		return nil, nil, err
+	}
+	return include, exclude, nil
+}
+
+// InventoryConfig controls the hardware and software inventory collector
+type InventoryConfig struct {
+	Interval int  `yaml:"interval"` // seconds between collections; the inventory is only sent when it changed
+	Packages bool `yaml:"packages"` // include installed dpkg and rpm packages
+}
+
+// LimitsConfig bounds the agent's own resource usage. While any limit is
+// exceeded the agent sheds the listed collectors and slows its collection.
+// A limit of 0 is not enforced.
+type LimitsConfig struct {
+	CPUPercent int      `yaml:"cpu_percent"` // percent of one core
+	MemoryMB   int      `yaml:"memory_mb"`   // resident memory
+	Goroutines int      `yaml:"goroutines"`
+	OpenFDs    int      `yaml:"open_fds"` // not enforced on Windows
+	Shed       []string `yaml:"shed"`     // collectors switched off while over a limit
+	Slowdown   int      `yaml:"slowdown"` // factor the check and sample intervals are multiplied by while over a limit
+}
+
+// ThresholdNames are the metrics thresholds can be set on, all percentages
+var ThresholdNames = []string{"cpu_percent", "memory_percent", "swap_percent", "disk_percent", "inodes_percent"}
+
+// CheckTypes are the supported synthetic check types
+var CheckTypes = []string{"tcp", "http", "dns", "icmp"}
+
 // Load loads configuration from file or environment variables
 func Load(configFile string) (*Config, error) {
 	// Try to load .env file
 	_ = godotenv.Load()
 
+	env := newEnvReader()
 	cfg := &Config{
 		Server: ServerConfig{
-			URL:       getEnv("NINJAIT_SERVER_URL", "http://localhost:3001"),
-			APIKey:    getEnv("NINJAIT_API_KEY", ""),
-			WSEnabled: getEnvBool("NINJAIT_WS_ENABLED", true),
+			URL:       env.String("server.url", "NINJAIT_SERVER_URL", "http://localhost:3001"),
+			APIKey:    env.Secret("server.api_key", "NINJAIT_API_KEY"),
+			WSEnabled: env.Bool("server.ws_enabled", "NINJAIT_WS_ENABLED", true),
 		},
 		Agent: AgentConfig{
-			DeviceID:          getEnv("NINJAIT_DEVICE_ID", generateDeviceID()),
-			Hostname:          getEnv("NINJAIT_HOSTNAME", getHostname()),
-			CheckInterval:     getEnvInt("NINJAIT_CHECK_INTERVAL", 60),
-			HeartbeatInterval: getEnvInt("NINJAIT_HEARTBEAT_INTERVAL", 30),
-			EnableCPU:         getEnvBool("NINJAIT_ENABLE_CPU", true),
-			EnableMemory:      getEnvBool("NINJAIT_ENABLE_MEMORY", true),
-			EnableDisk:        getEnvBool("NINJAIT_ENABLE_DISK", true),
-			EnableNetwork:     getEnvBool("NINJAIT_ENABLE_NETWORK", true),
-			EnableProcesses:   getEnvBool("NINJAIT_ENABLE_PROCESSES", false),
+			DeviceID:          env.String("agent.device_id", "NINJAIT_DEVICE_ID", generateDeviceID()),
+			Hostname:          env.String("agent.hostname", "NINJAIT_HOSTNAME", getHostname()),
+			TenantID:          env.String("agent.tenant_id", "NINJAIT_TENANT_ID", ""),
+			Group:             env.String("agent.group", "NINJAIT_GROUP", ""),
+			CheckInterval:     env.Int("agent.check_interval", "NINJAIT_CHECK_INTERVAL", 60),
+			HeartbeatInterval: env.Int("agent.heartbeat_interval", "NINJAIT_HEARTBEAT_INTERVAL", 30),
+			CollectorTimeout:  env.Int("agent.collector_timeout", "NINJAIT_COLLECTOR_TIMEOUT", 10),
+			CollectionBudget:  env.Int("agent.collection_budget", "NINJAIT_COLLECTION_BUDGET", 0),
+			SampleInterval:    env.Int("agent.sample_interval", "NINJAIT_SAMPLE_INTERVAL", 5),
+			EnableCPU:         env.Bool("agent.enable_cpu", "NINJAIT_ENABLE_CPU", true),
+			EnableMemory:      env.Bool("agent.enable_memory", "NINJAIT_ENABLE_MEMORY", true),
+			EnableDisk:        env.Bool("agent.enable_disk", "NINJAIT_ENABLE_DISK", true),
+			EnableNetwork:     env.Bool("agent.enable_network", "NINJAIT_ENABLE_NETWORK", true),
+			EnableProcesses:   env.Bool("agent.enable_processes", "NINJAIT_ENABLE_PROCESSES", false),
+			EnableSystemd:     env.Bool("agent.enable_systemd", "NINJAIT_ENABLE_SYSTEMD", false),
+			EnableSensors:     env.Bool("agent.enable_sensors", "NINJAIT_ENABLE_SENSORS", false),
+			EnableContainers:  env.Bool("agent.enable_containers", "NINJAIT_ENABLE_CONTAINERS", false),
+			EnableChecks:      env.Bool("agent.enable_checks", "NINJAIT_ENABLE_CHECKS", true),
+			EnableScripts:     env.Bool("agent.enable_scripts", "NINJAIT_ENABLE_SCRIPTS", true),
+			EnableSNMP:        env.Bool("agent.enable_snmp", "NINJAIT_ENABLE_SNMP", true),
+			EnableLogs:        env.Bool("agent.enable_logs", "NINJAIT_ENABLE_LOGS", true),
+			EnableInventory:   env.Bool("agent.enable_inventory", "NINJAIT_ENABLE_INVENTORY", true),
 		},
 		Security: SecurityConfig{
-			EnableTLS:      getEnvBool("NINJAIT_ENABLE_TLS", false),
-			TLSCert:        getEnv("NINJAIT_TLS_CERT", ""),
-			TLSKey:         getEnv("NINJAIT_TLS_KEY", ""),
-			VerifySSL:      getEnvBool("NINJAIT_VERIFY_SSL", true),
-			EncryptMetrics: getEnvBool("NINJAIT_ENCRYPT_METRICS", false),
+			EnableTLS:      env.Bool("security.enable_tls", "NINJAIT_ENABLE_TLS", false),
+			TLSCert:        env.String("security.tls_cert", "NINJAIT_TLS_CERT", ""),
+			TLSKey:         env.String("security.tls_key", "NINJAIT_TLS_KEY", ""),
+			VerifySSL:      env.Bool("security.verify_ssl", "NINJAIT_VERIFY_SSL", true),
+			EncryptMetrics: env.Bool("security.encrypt_metrics", "NINJAIT_ENCRYPT_METRICS", false),
+		},
+		Exporter: ExporterConfig{
+			Enabled:       env.Bool("exporter.enabled", "NINJAIT_EXPORTER_ENABLED", false),
+			ListenAddress: env.String("exporter.listen_address", "NINJAIT_EXPORTER_ADDRESS", "127.0.0.1:9465"),
+			Path:          env.String("exporter.path", "NINJAIT_EXPORTER_PATH", "/metrics"),
 		},
+		Status: StatusConfig{
+			Enabled:       env.Bool("status.enabled", "NINJAIT_STATUS_ENABLED", false),
+			ListenAddress: env.String("status.listen_address", "NINJAIT_STATUS_ADDRESS", "127.0.0.1:9466"),
+		},
+		Disk: DiskConfig{
+			IncludeFSTypes:     env.List("disk.include_fs_types", "NINJAIT_DISK_INCLUDE_FS_TYPES", nil),
+			ExcludeFSTypes:     env.List("disk.exclude_fs_types", "NINJAIT_DISK_EXCLUDE_FS_TYPES", []string{"squashfs", "tmpfs", "devtmpfs", "overlay"}),
+			IncludeMountpoints: env.List("disk.include_mountpoints", "NINJAIT_DISK_INCLUDE_MOUNTPOINTS", nil),
+			ExcludeMountpoints: env.List("disk.exclude_mountpoints", "NINJAIT_DISK_EXCLUDE_MOUNTPOINTS", nil),
+			IncludeDevices:     env.List("disk.include_devices", "NINJAIT_DISK_INCLUDE_DEVICES", nil),
+			ExcludeDevices:     env.List("disk.exclude_devices", "NINJAIT_DISK_EXCLUDE_DEVICES", nil),
+			Timeout:            env.Int("disk.timeout", "NINJAIT_DISK_TIMEOUT", 5),
+		},
+		Systemd: SystemdConfig{
+			Units:         env.List("systemd.units", "NINJAIT_SYSTEMD_UNITS", nil),
+			IncludeFailed: env.Bool("systemd.include_failed", "NINJAIT_SYSTEMD_INCLUDE_FAILED", true),
+		},
+		Containers: ContainersConfig{
+			CgroupRoot:   env.String("containers.cgroup_root", "NINJAIT_CGROUP_ROOT", "/sys/fs/cgroup"),
+			DockerSocket: env.String("containers.docker_socket", "NINJAIT_DOCKER_SOCKET", ""),
+		},
+		Logs: LogsConfig{
+			StateDir:  env.String("logs.state_dir", "NINJAIT_LOGS_STATE_DIR", "/var/lib/ninjait"),
+			RateLimit: env.Int("logs.rate_limit", "NINJAIT_LOGS_RATE_LIMIT", 60),
+		},
+		Inventory: InventoryConfig{
+			Interval: env.Int("inventory.interval", "NINJAIT_INVENTORY_INTERVAL", 3600),
+			Packages: env.Bool("inventory.packages", "NINJAIT_INVENTORY_PACKAGES", true),
+		},
+		Limits: LimitsConfig{
+			CPUPercent: env.Int("limits.cpu_percent", "NINJAIT_LIMIT_CPU_PERCENT", 10),
+			MemoryMB:   env.Int("limits.memory_mb", "NINJAIT_LIMIT_MEMORY_MB", 256),
+			Goroutines: env.Int("limits.goroutines", "NINJAIT_LIMIT_GOROUTINES", 2000),
+			OpenFDs:    env.Int("limits.open_fds", "NINJAIT_LIMIT_OPEN_FDS", 512),
+			Shed:       env.List("limits.shed", "NINJAIT_LIMIT_SHED", []string{"sensors", "containers", "systemd", "snmp", "inventory"}),
+			Slowdown:   env.Int("limits.slowdown", "NINJAIT_LIMIT_SLOWDOWN", 2),
+		},
+		Update: UpdateConfig{
+			Enabled:     env.Bool("update.enabled", "NINJAIT_UPDATE_ENABLED", false),
+			GracePeriod: env.Int("update.grace_period", "NINJAIT_UPDATE_GRACE_PERIOD", 300),
+			StateDir:    env.String("update.state_dir", "NINJAIT_UPDATE_STATE_DIR", "/var/lib/ninjait"),
+		},
+	}
+
+	if len(env.errs) > 0 {
+		return nil, fmt.Errorf("invalid environment: %w", errors.Join(env.errs...))
 	}
+	cfg.Sources = env.sources
 
-	// Try to load from YAML file if it exists
+	// Try to load from YAML file if it exists; it overrides the environment
 	if _, err := os.Stat(configFile); err == nil {
 		data, err := os.ReadFile(configFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		if err := decodeFile(data, cfg, cfg.Sources); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+		}
+	}
+
+	apiKey, err := resolveSecret("server.api_key", cfg.Server.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Server.APIKey = apiKey
+
+	for i := range cfg.SNMP {
+		target := &cfg.SNMP[i]
+		for name, secret := range map[string]*Secret{
+			"community":     &target.Community,
+			"auth_password": &target.AuthPassword,
+			"priv_password": &target.PrivPassword,
+		} {
+			resolved, err := resolveSecret(fmt.Sprintf("snmp %q %s", target.Name, name), *secret)
+			if err != nil {
+				return nil, err
+			}
+			*secret = resolved
 		}
 	}
 
@@ -98,8 +485,8 @@ func Load(configFile string) (*Config, error) {
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.Server.URL == "" {
-		return fmt.Errorf("server URL is required")
+	if err := validateURL("server URL", c.Server.URL); err != nil {
+		return err
 	}
 	if c.Agent.DeviceID == "" {
 		return fmt.Errorf("device ID is required")
@@ -110,32 +497,367 @@ func (c *Config) Validate() error {
 	if c.Agent.HeartbeatInterval < 10 {
 		return fmt.Errorf("heartbeat interval must be at least 10 seconds")
 	}
+	if c.Agent.CollectorTimeout < 0 {
+		return fmt.Errorf("collector timeout must not be negative")
+	}
+	if c.Agent.CollectionBudget < 0 || c.Agent.CollectionBudget >= c.Agent.CheckInterval {
+		return fmt.Errorf("collection budget must be between 0 and the check interval")
+	}
+	if c.Agent.SampleInterval < 0 || c.Agent.SampleInterval >= c.Agent.CheckInterval {
+		return fmt.Errorf("sample interval must be between 0 and the check interval")
+	}
+	if c.Security.EnableTLS {
+		if err := validateFile("TLS certificate", c.Security.TLSCert); err != nil {
+			return err
+		}
+		if err := validateFile("TLS key", c.Security.TLSKey); err != nil {
+			return err
+		}
+	}
+	if c.Exporter.Enabled {
+		if c.Exporter.ListenAddress == "" {
+			return fmt.Errorf("exporter listen address is required when the exporter is enabled")
+		}
+		if !strings.HasPrefix(c.Exporter.Path, "/") {
+			return fmt.Errorf("exporter path must start with '/'")
+		}
+	}
+	if c.Status.Enabled {
+		if err := validateLocalAddress("status listen address", c.Status.ListenAddress); err != nil {
+			return err
+		}
+	}
+	if c.Agent.EnableSystemd {
+		if len(c.Systemd.Units) == 0 && !c.Systemd.IncludeFailed {
+			return fmt.Errorf("systemd monitoring needs systemd.units or systemd.include_failed")
+		}
+		for _, unit := range c.Systemd.Units {
+			if unit == "" || strings.ContainsAny(unit, " \t\n/") || strings.HasPrefix(unit, "-") {
+				return fmt.Errorf("invalid systemd unit name %q", unit)
+			}
+		}
+	}
+	if c.Agent.EnableDisk {
+		if c.Disk.Timeout < 0 || c.Disk.TimeoutDuration() >= c.Agent.CollectorTimeoutDuration() {
+			return fmt.Errorf("disk timeout must be shorter than the collector timeout")
+		}
+		for _, patterns := range [][]string{c.Disk.IncludeMountpoints, c.Disk.ExcludeMountpoints, c.Disk.IncludeDevices, c.Disk.ExcludeDevices} {
+			for _, pattern := range patterns {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid disk pattern %q: %w", pattern, err)
+				}
+			}
+		}
+	}
+	if c.Agent.EnableContainers {
+		if !filepath.IsAbs(c.Containers.CgroupRoot) {
+			return fmt.Errorf("containers cgroup root must be an absolute path")
+		}
+		if c.Containers.DockerSocket != "" && !filepath.IsAbs(c.Containers.DockerSocket) {
+			return fmt.Errorf("containers docker socket must be an absolute path")
+		}
+	}
+	if c.Limits.CPUPercent < 0 || c.Limits.MemoryMB < 0 || c.Limits.Goroutines < 0 || c.Limits.OpenFDs < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if c.Limits.Slowdown < 1 {
+		return fmt.Errorf("limits slowdown must be at least 1")
+	}
+	for _, name := range c.Limits.Shed {
+		var scratch AgentConfig
+		if !scratch.setCollector(name, false) {
+			return fmt.Errorf("unknown collector %q in limits shed", name)
+		}
+	}
+	for name, value := range c.Thresholds {
+		if !slices.Contains(ThresholdNames, name) {
+			return fmt.Errorf("unknown threshold %q, expected one of %s", name, strings.Join(ThresholdNames, ", "))
+		}
+		if math.IsNaN(value) || value <= 0 || value > 100 {
+			return fmt.Errorf("threshold %s must be above 0 and at most 100", name)
+		}
+	}
+	if c.Agent.EnableInventory && c.Inventory.Interval < 60 {
+		return fmt.Errorf("inventory interval must be at least 60 seconds")
+	}
+	if c.Agent.EnableChecks {
+		if err := c.validateChecks(); err != nil {
+			return err
+		}
+	}
+	if c.Agent.EnableScripts {
+		if err := c.validateScripts(); err != nil {
+			return err
+		}
+	}
+	if c.Agent.EnableSNMP {
+		if err := c.validateSNMP(); err != nil {
+			return err
+		}
+	}
+	if c.Agent.EnableLogs {
+		if err := c.validateLogs(); err != nil {
+			return err
+		}
+	}
+	if c.Update.Enabled {
+		if c.Update.GracePeriod < 30 {
+			return fmt.Errorf("update grace period must be at least 30 seconds")
+		}
+		if c.Update.StateDir == "" {
+			return fmt.Errorf("update state directory is required when updates are enabled")
+		}
+	}
+	return nil
+}
+
+// validateChecks checks the settings of each synthetic check
+func (c *Config) validateChecks() error {
+	names := make(map[string]bool, len(c.Checks))
+	for i, check := range c.Checks {
+		if check.Name == "" {
+			return fmt.Errorf("check %d: name is required", i+1)
+		}
+		if names[check.Name] {
+			return fmt.Errorf("check %q is defined more than once", check.Name)
+		}
+		names[check.Name] = true
+
+		if check.Timeout < 0 || check.Timeout >= c.Agent.CheckInterval {
+			return fmt.Errorf("check %q: timeout must be shorter than the check interval", check.Name)
+		}
+		if check.Target == "" {
+			return fmt.Errorf("check %q: target is required", check.Name)
+		}
+
+		switch check.Type {
+		case "tcp":
+			if _, _, err := net.SplitHostPort(check.Target); err != nil {
+				return fmt.Errorf("check %q: tcp target must be host:port: %w", check.Name, err)
+			}
+		case "http":
+			if err := validateURL(fmt.Sprintf("check %q target", check.Name), check.Target); err != nil {
+				return err
+			}
+			if check.ExpectedStatus != 0 && (check.ExpectedStatus < 100 || check.ExpectedStatus > 599) {
+				return fmt.Errorf("check %q: expected status %d is not an HTTP status", check.Name, check.ExpectedStatus)
+			}
+			if check.MinCertDays < 0 {
+				return fmt.Errorf("check %q: min_cert_days must not be negative", check.Name)
+			}
+		case "dns":
+			if check.Resolver != "" {
+				if _, _, err := net.SplitHostPort(check.Resolver); err != nil {
+					return fmt.Errorf("check %q: resolver must be host:port: %w", check.Name, err)
+				}
+			}
+		case "icmp":
+		default:
+			return fmt.Errorf("check %q: unknown type %q, expected one of %s", check.Name, check.Type, strings.Join(CheckTypes, ", "))
+		}
+		if strings.Contains(check.Target, " ") {
+			return fmt.Errorf("check %q: target must not contain spaces", check.Name)
+		}
+	}
+	return nil
+}
+
+// validateScripts checks the settings of each check script
+func (c *Config) validateScripts() error {
+	names := make(map[string]bool, len(c.Scripts))
+	for i, script := range c.Scripts {
+		if script.Name == "" {
+			return fmt.Errorf("script %d: name is required", i+1)
+		}
+		if names[script.Name] {
+			return fmt.Errorf("script %q is defined more than once", script.Name)
+		}
+		names[script.Name] = true
+
+		if !filepath.IsAbs(script.Command) {
+			return fmt.Errorf("script %q: command must be an absolute path", script.Name)
+		}
+		if script.Interval != 0 && script.Interval < c.Agent.CheckInterval {
+			return fmt.Errorf("script %q: interval must be at least the check interval", script.Name)
+		}
+		if script.Timeout < 0 || script.TimeoutDuration() >= time.Duration(c.Agent.CheckInterval)*time.Second {
+			return fmt.Errorf("script %q: timeout (default 30 seconds) must be shorter than the check interval", script.Name)
+		}
+	}
 	return nil
 }
 
-// Helper functions
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// validateSNMP checks the settings of each SNMP target
+func (c *Config) validateSNMP() error {
+	ids := make(map[string]bool, len(c.SNMP))
+	for i, target := range c.SNMP {
+		if target.Name == "" {
+			return fmt.Errorf("snmp target %d: name is required", i+1)
+		}
+		id := target.ID()
+		if !snmpDeviceIDPattern.MatchString(id) {
+			return fmt.Errorf("snmp target %q: device ID %q may only contain letters, digits, '.', '_', ':' and '-'", target.Name, id)
+		}
+		if id == c.Agent.DeviceID || ids[id] {
+			return fmt.Errorf("snmp target %q: device ID %q is already in use", target.Name, id)
+		}
+		ids[id] = true
+
+		if target.Address == "" {
+			return fmt.Errorf("snmp target %q: address is required", target.Name)
+		}
+		if target.Timeout < 0 || target.Retries < 0 {
+			return fmt.Errorf("snmp target %q: timeout and retries must not be negative", target.Name)
+		}
+
+		switch target.Version {
+		case "", "2c":
+			if target.Community == "" {
+				return fmt.Errorf("snmp target %q: community is required for v2c", target.Name)
+			}
+		case "3":
+			if target.Username == "" {
+				return fmt.Errorf("snmp target %q: username is required for v3", target.Name)
+			}
+			if target.AuthProtocol != "" && !slices.Contains(SNMPAuthProtocols, target.AuthProtocol) {
+				return fmt.Errorf("snmp target %q: unknown auth protocol %q, expected one of %s", target.Name, target.AuthProtocol, strings.Join(SNMPAuthProtocols, ", "))
+			}
+			if target.PrivProtocol != "" && !slices.Contains(SNMPPrivProtocols, target.PrivProtocol) {
+				return fmt.Errorf("snmp target %q: unknown privacy protocol %q, expected one of %s", target.Name, target.PrivProtocol, strings.Join(SNMPPrivProtocols, ", "))
+			}
+			if target.PrivProtocol != "" && target.AuthProtocol == "" {
+				return fmt.Errorf("snmp target %q: privacy requires an auth protocol", target.Name)
+			}
+			if target.AuthProtocol != "" && len(target.AuthPassword.Value()) < 8 {
+				return fmt.Errorf("snmp target %q: auth password must be at least 8 characters", target.Name)
+			}
+			if target.PrivProtocol != "" && len(target.PrivPassword.Value()) < 8 {
+				return fmt.Errorf("snmp target %q: privacy password must be at least 8 characters", target.Name)
+			}
+		default:
+			return fmt.Errorf("snmp target %q: version must be 2c or 3, got %q", target.Name, target.Version)
+		}
+
+		names := make(map[string]bool, len(target.OIDs))
+		for _, oid := range target.OIDs {
+			if oid.Name == "" || names[oid.Name] {
+				return fmt.Errorf("snmp target %q: each OID needs a unique name", target.Name)
+			}
+			names[oid.Name] = true
+			if !oidPattern.MatchString(oid.OID) {
+				return fmt.Errorf("snmp target %q: %q is not a numeric OID", target.Name, oid.OID)
+			}
+		}
 	}
-	return defaultValue
+	return nil
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		var result int
-		if _, err := fmt.Sscanf(value, "%d", &result); err == nil {
-			return result
+// validateLogs checks the log sources and their patterns
+func (c *Config) validateLogs() error {
+	if len(c.Logs.Sources) == 0 {
+		return nil
+	}
+	if c.Logs.StateDir == "" {
+		return fmt.Errorf("logs state directory is required")
+	}
+	if c.Logs.RateLimit < 1 {
+		return fmt.Errorf("logs rate limit must be at least 1 event per minute")
+	}
+
+	names := make(map[string]bool, len(c.Logs.Sources))
+	for i, source := range c.Logs.Sources {
+		if source.Name == "" {
+			return fmt.Errorf("log source %d: name is required", i+1)
+		}
+		if names[source.Name] {
+			return fmt.Errorf("log source %q is defined more than once", source.Name)
+		}
+		names[source.Name] = true
+
+		switch {
+		case source.Journal && source.Path != "":
+			return fmt.Errorf("log source %q: set either path or journal, not both", source.Name)
+		case source.Journal:
+			if runtime.GOOS != "linux" {
+				return fmt.Errorf("log source %q: the systemd journal is only available on Linux", source.Name)
+			}
+			for _, unit := range source.Units {
+				if unit == "" || strings.ContainsAny(unit, " \t\n/") || strings.HasPrefix(unit, "-") {
+					return fmt.Errorf("log source %q: invalid systemd unit name %q", source.Name, unit)
+				}
+			}
+		case source.Path == "":
+			return fmt.Errorf("log source %q: path or journal is required", source.Name)
+		case !filepath.IsAbs(source.Path):
+			return fmt.Errorf("log source %q: path must be absolute", source.Name)
+		case len(source.Units) > 0:
+			return fmt.Errorf("log source %q: units only apply to the journal", source.Name)
+		}
+
+		if len(source.Include) == 0 {
+			return fmt.Errorf("log source %q: at least one include pattern is required", source.Name)
+		}
+		if _, _, err := source.Patterns(); err != nil {
+			return fmt.Errorf("log source %q: %w", source.Name, err)
 		}
 	}
-	return defaultValue
+	return nil
+}
+
+// validateLocalAddress checks that address is a Unix socket or a loopback
+// host:port, so the endpoint cannot be reached from other machines
+func validateLocalAddress(name, address string) error {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("%s must include a socket path", name)
+		}
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%s %q is not host:port or unix:/path: %w", name, address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s must be a loopback address or Unix socket, got %q", name, address)
+	}
+	return nil
+}
+
+// validateURL checks that value is an absolute http(s) URL
+func validateURL(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s must use http or https, got %q", name, value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s must include a host, got %q", name, value)
+	}
+	return nil
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return value == "true" || value == "1" || value == "yes"
+// validateFile checks that path names a readable regular file
+func validateFile(name, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s path is required when TLS is enabled", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", name, path, err)
 	}
-	return defaultValue
+	if info.IsDir() {
+		return fmt.Errorf("%s %s is a directory", name, path)
+	}
+	return nil
 }
 
 func getHostname() string {
@@ -151,4 +873,3 @@ func generateDeviceID() string {
 	osType := runtime.GOOS
 	return fmt.Sprintf("%s-%s", hostname, osType)
 }
-
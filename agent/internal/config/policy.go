@@ -0,0 +1,100 @@
+package config
+
+import "github.com/yossibmoha/NinjaIT/agent/pkg/models"
+
+// WithPolicy returns a copy of the configuration with the central policy
+// merged over it. Precedence, lowest first: built-in defaults, environment
+// variables, the YAML file, then any setting the policy defines. Settings the
+// policy leaves unset keep their local values.
+func (c *Config) WithPolicy(p *models.EffectivePolicy) *Config {
+	merged := *c
+	if p == nil {
+		merged.PolicyVersion = 0
+		return &merged
+	}
+
+	merged.Sources = make(map[string]string, len(c.Sources))
+	for path, source := range c.Sources {
+		merged.Sources[path] = source
+	}
+
+	if p.CheckInterval != nil {
+		merged.Agent.CheckInterval = *p.CheckInterval
+		merged.Sources["agent.check_interval"] = SourcePolicy
+	}
+	if p.HeartbeatInterval != nil {
+		merged.Agent.HeartbeatInterval = *p.HeartbeatInterval
+		merged.Sources["agent.heartbeat_interval"] = SourcePolicy
+	}
+
+	for name, enabled := range p.Collectors {
+		if merged.Agent.setCollector(name, enabled) {
+			merged.Sources["agent.enable_"+name] = SourcePolicy
+		}
+	}
+
+	if len(p.Thresholds) > 0 {
+		merged.Thresholds = make(map[string]float64, len(c.Thresholds)+len(p.Thresholds))
+		for name, value := range c.Thresholds {
+			merged.Thresholds[name] = value
+		}
+		for name, value := range p.Thresholds {
+			merged.Thresholds[name] = value
+			merged.Sources["thresholds."+name] = SourcePolicy
+		}
+	}
+
+	merged.PolicyVersion = p.Version
+	return &merged
+}
+
+// WithThrottle returns a copy of the configuration the agent runs with while
+// its own resource usage is over a limit: the collectors listed in
+// limits.shed are switched off and the check and sample intervals are
+// multiplied by limits.slowdown
+func (c *Config) WithThrottle() *Config {
+	throttled := *c
+	throttled.Throttled = true
+	throttled.Agent.CheckInterval *= c.Limits.Slowdown
+	throttled.Agent.SampleInterval *= c.Limits.Slowdown
+	for _, name := range c.Limits.Shed {
+		throttled.Agent.setCollector(name, false)
+	}
+	return &throttled
+}
+
+// setCollector enables or disables a collector by its policy name, reporting
+// false for an unknown name
+func (a *AgentConfig) setCollector(name string, enabled bool) bool {
+	switch name {
+	case "cpu":
+		a.EnableCPU = enabled
+	case "memory":
+		a.EnableMemory = enabled
+	case "disk":
+		a.EnableDisk = enabled
+	case "network":
+		a.EnableNetwork = enabled
+	case "processes":
+		a.EnableProcesses = enabled
+	case "systemd":
+		a.EnableSystemd = enabled
+	case "sensors":
+		a.EnableSensors = enabled
+	case "containers":
+		a.EnableContainers = enabled
+	case "checks":
+		a.EnableChecks = enabled
+	case "scripts":
+		a.EnableScripts = enabled
+	case "snmp":
+		a.EnableSNMP = enabled
+	case "logs":
+		a.EnableLogs = enabled
+	case "inventory":
+		a.EnableInventory = enabled
+	default:
+		return false
+	}
+	return true
+}
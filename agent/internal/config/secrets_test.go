@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NINJAIT_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		name    string
+		value   Secret
+		want    Secret
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: ""},
+		{name: "plain", value: "s3cret", want: "s3cret"},
+		{name: "unregistered scheme", value: "https://example.com", want: "https://example.com"},
+		{name: "file", value: Secret("file:" + file), want: "from-file"},
+		{name: "env", value: "env:NINJAIT_TEST_SECRET", want: "from-env"},
+		{name: "literal", value: "literal:file:/not/a/path", want: "file:/not/a/path"},
+		{name: "literal literal", value: "literal:literal:x", want: "literal:x"},
+		{name: "empty literal", value: "literal:", want: ""},
+		{name: "missing file", value: "file:/nonexistent/ninjait-secret", wantErr: true},
+		{name: "unset env", value: "env:NINJAIT_TEST_SECRET_UNSET", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSecret("test", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSecret() = %q, want %q", got.Value(), tt.want.Value())
+			}
+		})
+	}
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	RegisterSecretProvider("test", SecretProviderFunc(func(reference string) (string, error) {
+		if reference == "fail" {
+			return "", errors.New("provider failed")
+		}
+		return "resolved " + reference, nil
+	}))
+	defer func() {
+		secretProvidersMu.Lock()
+		delete(secretProviders, "test")
+		secretProvidersMu.Unlock()
+	}()
+
+	if got, err := resolveSecret("test", "test:key"); err != nil || got != "resolved key" {
+		t.Errorf("resolveSecret() = %q, %v", got.Value(), err)
+	}
+	if _, err := resolveSecret("test", "test:fail"); err == nil {
+		t.Error("resolveSecret() ignored a provider error")
+	}
+}
+
+func TestSecretMasked(t *testing.T) {
+	s := Secret("s3cret")
+	for _, got := range []string{fmt.Sprint(s), fmt.Sprintf("%v %s %#v", s, s, s)} {
+		if bytes.Contains([]byte(got), []byte("s3cret")) {
+			t.Errorf("formatted secret %q leaks its value", got)
+		}
+	}
+	data, err := json.Marshal(struct{ Key Secret }{s})
+	if err != nil || bytes.Contains(data, []byte("s3cret")) {
+		t.Errorf("json.Marshal() = %s, %v", data, err)
+	}
+	if Secret("").String() != "" {
+		t.Error("empty secret is masked")
+	}
+}
+
+// TestSecretsCopiesMatch checks that the agent and the monitoring service
+// still share the same secrets.go and secrets_test.go. It is skipped when the
+// other module is not checked out next to this one.
+func TestSecretsCopiesMatch(t *testing.T) {
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !isDir(filepath.Join(root, "agent")) || !isDir(filepath.Join(root, "backend")) {
+		parent := filepath.Dir(root)
+		if parent == root {
+			t.Skip("repository root not found")
+		}
+		root = parent
+	}
+
+	agent := filepath.Join(root, "agent", "internal", "config")
+	monitoring := filepath.Join(root, "backend", "services", "monitoring", "internal", "config")
+	for _, name := range []string{"secrets.go", "secrets_test.go"} {
+		a, errA := os.ReadFile(filepath.Join(agent, name))
+		b, errB := os.ReadFile(filepath.Join(monitoring, name))
+		if errA != nil || errB != nil {
+			t.Skipf("cannot read both copies of %s: %v", name, errors.Join(errA, errB))
+		}
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s differs between %s and %s", name, agent, monitoring)
+		}
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// reloadDebounce coalesces the burst of events editors produce when saving a file
+const reloadDebounce = 500 * time.Millisecond
+
+// Watcher reloads the configuration when its file changes or Reload is called,
+// keeping the last valid configuration when a reload fails validation. The
+// active configuration is the local one with the central policy merged over it.
+type Watcher struct {
+	path string
+
+	applyMu     sync.Mutex // serializes applies so subscribers see updates in order
+	mu          sync.RWMutex
+	local       *Config
+	policy      *models.EffectivePolicy
+	throttled   bool
+	current     *Config
+	subscribers []chan *Config
+}
+
+// NewWatcher creates a watcher for configFile starting from an already loaded configuration
+func NewWatcher(configFile string, initial *Config) *Watcher {
+	return &Watcher{
+		path:    configFile,
+		local:   initial,
+		current: initial,
+	}
+}
+
+// Current returns the active configuration
+func (w *Watcher) Current() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Subscribe returns a channel that receives each newly applied configuration.
+// Only the latest configuration is kept if the subscriber falls behind.
+func (w *Watcher) Subscribe() <-chan *Config {
+	ch := make(chan *Config, 1)
+	w.mu.Lock()
+	w.subscribers = append(w.subscribers, ch)
+	w.mu.Unlock()
+	return ch
+}
+
+// Reload re-reads and validates the configuration, notifying subscribers on success
+func (w *Watcher) Reload() error {
+	local, err := Load(w.path)
+	if err != nil {
+		log.WithError(err).Error("Configuration reload rejected, keeping last good configuration")
+		return err
+	}
+
+	w.mu.RLock()
+	policy := w.policy
+	w.mu.RUnlock()
+
+	return w.apply(local, policy)
+}
+
+// SetPolicy merges a central policy over the local configuration, notifying
+// subscribers on success. A policy that would produce an invalid
+// configuration is rejected and the current configuration kept.
+func (w *Watcher) SetPolicy(policy *models.EffectivePolicy) error {
+	w.mu.RLock()
+	local := w.local
+	unchanged := w.policy != nil && policy != nil && w.policy.Version == policy.Version
+	w.mu.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+	return w.apply(local, policy)
+}
+
+// SetThrottled switches the reduced collection the agent runs with while over
+// its resource limits on or off, notifying subscribers when it changes
+func (w *Watcher) SetThrottled(throttled bool) error {
+	w.mu.Lock()
+	if w.throttled == throttled {
+		w.mu.Unlock()
+		return nil
+	}
+	w.throttled = throttled
+	local, policy := w.local, w.policy
+	w.mu.Unlock()
+
+	return w.apply(local, policy)
+}
+
+// apply validates the merged configuration and makes it current
+func (w *Watcher) apply(local *Config, policy *models.EffectivePolicy) error {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	cfg := local.WithPolicy(policy)
+	if err := cfg.Validate(); err != nil {
+		log.WithError(err).WithField("policy_version", cfg.PolicyVersion).Error("Central policy rejected, keeping last good configuration")
+		return err
+	}
+
+	w.mu.Lock()
+	if w.throttled {
+		// Applied after validation: it only sheds collectors and lengthens
+		// intervals of a configuration already known to be valid
+		cfg = cfg.WithThrottle()
+	}
+	previous := w.current
+	w.local = local
+	w.policy = policy
+	w.current = cfg
+	subscribers := w.subscribers
+	w.mu.Unlock()
+
+	logRestartRequired(previous, cfg)
+
+	for _, ch := range subscribers {
+		// Drop a stale pending update so the subscriber always sees the latest one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- cfg
+	}
+
+	log.WithFields(log.Fields{
+		"server_url":         cfg.Server.URL,
+		"check_interval":     cfg.Agent.CheckInterval,
+		"heartbeat_interval": cfg.Agent.HeartbeatInterval,
+		"policy_version":     cfg.PolicyVersion,
+		"throttled":          cfg.Throttled,
+	}).Info("Configuration applied")
+
+	return nil
+}
+
+// Run watches the configuration file until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithError(err).Warn("Failed to create config file watcher, reload via SIGHUP only")
+		return
+	}
+	defer fsWatcher.Close()
+
+	// Watch the directory rather than the file so atomic saves (write to temp
+	// file, then rename) and Kubernetes ConfigMap symlink swaps are seen
+	dir := filepath.Dir(w.path)
+	if err := fsWatcher.Add(dir); err != nil {
+		log.WithError(err).WithField("dir", dir).Warn("Failed to watch config directory, reload via SIGHUP only")
+		return
+	}
+
+	target := filepath.Clean(w.path)
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == target || filepath.Base(event.Name) == "..data" {
+				debounce = time.After(reloadDebounce)
+			}
+		case err, ok := <-fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.WithError(err).Warn("Config file watcher error")
+		case <-debounce:
+			debounce = nil
+			log.WithField("file", w.path).Info("Configuration file changed, reloading")
+			_ = w.Reload()
+		}
+	}
+}
+
+// logRestartRequired warns about changed settings that only take effect after a restart
+func logRestartRequired(previous, next *Config) {
+	if previous.Exporter != next.Exporter {
+		log.Warn("Exporter settings changed; restart the agent to apply them")
+	}
+	if previous.Update != next.Update {
+		log.Warn("Update settings changed; restart the agent to apply them")
+	}
+	if previous.Status != next.Status {
+		log.Warn("Status endpoint settings changed; restart the agent to apply them")
+	}
+	if previous.Security != next.Security {
+		log.Warn("Security settings changed; restart the agent to apply them")
+	}
+}
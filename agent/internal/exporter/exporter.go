@@ -0,0 +1,358 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+const namespace = "ninjait_agent"
+
+// Source provides the most recently collected metrics
+type Source interface {
+	Latest() *models.SystemMetrics
+}
+
+// Exporter serves collected metrics in Prometheus text format
+type Exporter struct {
+	config *config.Config
+	server *http.Server
+}
+
+// NewExporter creates an exporter serving the snapshots produced by source
+func NewExporter(cfg *config.Config, source Source) *Exporter {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newCollector(source))
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Exporter.Path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	return &Exporter{
+		config: cfg,
+		server: &http.Server{
+			Addr:              cfg.Exporter.ListenAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start serves metrics until Shutdown is called
+func (e *Exporter) Start() error {
+	log.WithFields(log.Fields{
+		"address": e.config.Exporter.ListenAddress,
+		"path":    e.config.Exporter.Path,
+	}).Info("Prometheus exporter listening")
+
+	if err := e.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the exporter
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.server.Shutdown(ctx)
+}
+
+// collector converts the latest SystemMetrics snapshot into Prometheus metrics on each scrape
+type collector struct {
+	source Source
+
+	info            *prometheus.Desc
+	collectedAt     *prometheus.Desc
+	cpuUsage        *prometheus.Desc
+	cpuCores        *prometheus.Desc
+	cpuCoreUsage    *prometheus.Desc
+	memTotal        *prometheus.Desc
+	memAvailable    *prometheus.Desc
+	memUsed         *prometheus.Desc
+	memFree         *prometheus.Desc
+	memUsedPercent  *prometheus.Desc
+	swapTotal       *prometheus.Desc
+	swapUsed        *prometheus.Desc
+	swapFree        *prometheus.Desc
+	diskTotal       *prometheus.Desc
+	diskUsed        *prometheus.Desc
+	diskFree        *prometheus.Desc
+	diskUsedPercent *prometheus.Desc
+	inodesTotal     *prometheus.Desc
+	inodesUsed      *prometheus.Desc
+	inodesFree      *prometheus.Desc
+	inodesPercent   *prometheus.Desc
+	netBytesSent    *prometheus.Desc
+	netBytesRecv    *prometheus.Desc
+	netPacketsSent  *prometheus.Desc
+	netPacketsRecv  *prometheus.Desc
+	netErrorsIn     *prometheus.Desc
+	netErrorsOut    *prometheus.Desc
+	netDropsIn      *prometheus.Desc
+	netDropsOut     *prometheus.Desc
+	uptime          *prometheus.Desc
+	bootTime        *prometheus.Desc
+	unitActive      *prometheus.Desc
+	unitFailed      *prometheus.Desc
+	unitRestarts    *prometheus.Desc
+	temperature     *prometheus.Desc
+	temperatureHigh *prometheus.Desc
+	temperatureCrit *prometheus.Desc
+	fanSpeed        *prometheus.Desc
+	power           *prometheus.Desc
+	ctrCPUPercent   *prometheus.Desc
+	ctrCPUSeconds   *prometheus.Desc
+	ctrMemUsage     *prometheus.Desc
+	ctrMemLimit     *prometheus.Desc
+	ctrBlockRead    *prometheus.Desc
+	ctrBlockWrite   *prometheus.Desc
+	ctrNetRecv      *prometheus.Desc
+	ctrNetSent      *prometheus.Desc
+	ctrPids         *prometheus.Desc
+	ctrRestarts     *prometheus.Desc
+	checkSuccess    *prometheus.Desc
+	checkLatency    *prometheus.Desc
+	checkStatus     *prometheus.Desc
+	checkCertExpiry *prometheus.Desc
+	scriptStatus    *prometheus.Desc
+	scriptDuration  *prometheus.Desc
+	scriptPerfdata  *prometheus.Desc
+}
+
+func newCollector(source Source) *collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	diskLabels := []string{"device", "mountpoint", "fs_type"}
+	containerLabels := []string{"id", "name", "image"}
+	checkLabels := []string{"check", "type", "target"}
+
+	return &collector{
+		source: source,
+
+		info:            desc("info", "Agent identity and host platform, always 1.", "device_id", "hostname", "os", "platform", "platform_version", "kernel_version", "kernel_arch"),
+		collectedAt:     desc("last_collection_timestamp_seconds", "Unix time of the collection cycle these values come from."),
+		cpuUsage:        desc("cpu_usage_percent", "Overall CPU usage percentage."),
+		cpuCores:        desc("cpu_cores", "Number of logical CPU cores."),
+		cpuCoreUsage:    desc("cpu_core_usage_percent", "Per-core CPU usage percentage.", "core"),
+		memTotal:        desc("memory_total_bytes", "Total physical memory in bytes."),
+		memAvailable:    desc("memory_available_bytes", "Available memory in bytes."),
+		memUsed:         desc("memory_used_bytes", "Used memory in bytes."),
+		memFree:         desc("memory_free_bytes", "Free memory in bytes."),
+		memUsedPercent:  desc("memory_used_percent", "Memory usage percentage."),
+		swapTotal:       desc("swap_total_bytes", "Total swap in bytes."),
+		swapUsed:        desc("swap_used_bytes", "Used swap in bytes."),
+		swapFree:        desc("swap_free_bytes", "Free swap in bytes."),
+		diskTotal:       desc("disk_total_bytes", "Total filesystem size in bytes.", diskLabels...),
+		diskUsed:        desc("disk_used_bytes", "Used filesystem space in bytes.", diskLabels...),
+		diskFree:        desc("disk_free_bytes", "Free filesystem space in bytes.", diskLabels...),
+		diskUsedPercent: desc("disk_used_percent", "Filesystem usage percentage.", diskLabels...),
+		inodesTotal:     desc("disk_inodes", "Total filesystem inodes.", diskLabels...),
+		inodesUsed:      desc("disk_inodes_used", "Used filesystem inodes.", diskLabels...),
+		inodesFree:      desc("disk_inodes_free", "Free filesystem inodes.", diskLabels...),
+		inodesPercent:   desc("disk_inodes_used_percent", "Filesystem inode usage percentage.", diskLabels...),
+		netBytesSent:    desc("network_sent_bytes_total", "Bytes sent on all interfaces."),
+		netBytesRecv:    desc("network_received_bytes_total", "Bytes received on all interfaces."),
+		netPacketsSent:  desc("network_sent_packets_total", "Packets sent on all interfaces."),
+		netPacketsRecv:  desc("network_received_packets_total", "Packets received on all interfaces."),
+		netErrorsIn:     desc("network_receive_errors_total", "Receive errors on all interfaces."),
+		netErrorsOut:    desc("network_transmit_errors_total", "Transmit errors on all interfaces."),
+		netDropsIn:      desc("network_receive_drops_total", "Inbound packets dropped on all interfaces."),
+		netDropsOut:     desc("network_transmit_drops_total", "Outbound packets dropped on all interfaces."),
+		uptime:          desc("system_uptime_seconds", "System uptime in seconds."),
+		bootTime:        desc("system_boot_time_seconds", "System boot time as Unix time."),
+		unitActive:      desc("systemd_unit_active", "Whether the systemd unit is active (1) or not (0).", "unit"),
+		unitFailed:      desc("systemd_unit_failed", "Whether the systemd unit is in the failed state (1) or not (0).", "unit"),
+		unitRestarts:    desc("systemd_unit_restarts", "Automatic restarts of the systemd unit since it was last started manually.", "unit"),
+		temperature:     desc("sensor_temperature_celsius", "Temperature reported by a hardware sensor.", "sensor"),
+		temperatureHigh: desc("sensor_temperature_high_celsius", "High temperature threshold of a hardware sensor.", "sensor"),
+		temperatureCrit: desc("sensor_temperature_critical_celsius", "Critical temperature threshold of a hardware sensor.", "sensor"),
+		fanSpeed:        desc("sensor_fan_speed_rpm", "Fan speed in revolutions per minute.", "sensor"),
+		power:           desc("sensor_power_watts", "Power draw reported by a hardware sensor.", "sensor"),
+		ctrCPUPercent:   desc("container_cpu_usage_percent", "Container CPU usage since the previous collection, 100 per fully used core.", containerLabels...),
+		ctrCPUSeconds:   desc("container_cpu_seconds_total", "CPU time consumed by the container.", containerLabels...),
+		ctrMemUsage:     desc("container_memory_usage_bytes", "Container memory usage excluding inactive page cache.", containerLabels...),
+		ctrMemLimit:     desc("container_memory_limit_bytes", "Container memory limit, absent when unlimited.", containerLabels...),
+		ctrBlockRead:    desc("container_block_read_bytes_total", "Bytes read from block devices by the container.", containerLabels...),
+		ctrBlockWrite:   desc("container_block_written_bytes_total", "Bytes written to block devices by the container.", containerLabels...),
+		ctrNetRecv:      desc("container_network_received_bytes_total", "Bytes received on the container's interfaces.", containerLabels...),
+		ctrNetSent:      desc("container_network_sent_bytes_total", "Bytes sent on the container's interfaces.", containerLabels...),
+		ctrPids:         desc("container_pids", "Processes and threads in the container.", containerLabels...),
+		ctrRestarts:     desc("container_restarts", "Restarts of the container reported by Docker.", containerLabels...),
+		checkSuccess:    desc("check_success", "Whether the synthetic check succeeded (1) or not (0).", checkLabels...),
+		checkLatency:    desc("check_duration_seconds", "Duration of the synthetic check; the echo round trip for icmp.", checkLabels...),
+		checkStatus:     desc("check_http_status_code", "HTTP status code returned to the synthetic check.", checkLabels...),
+		checkCertExpiry: desc("check_cert_expiry_timestamp_seconds", "Unix time the certificate seen by the synthetic check expires.", checkLabels...),
+		scriptStatus:    desc("script_status", "Nagios state of the check script: 0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN.", "script"),
+		scriptDuration:  desc("script_duration_seconds", "Run time of the check script.", "script"),
+		scriptPerfdata:  desc("script_perfdata", "Performance data value reported by the check script, in its own unit.", "script", "label", "unit"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.source.Latest()
+	if m == nil {
+		// Nothing collected yet
+		return
+	}
+
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	counter := func(desc *prometheus.Desc, value uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+
+	gauge(c.collectedAt, float64(m.Timestamp.UnixNano())/1e9)
+
+	if m.System != nil {
+		gauge(c.info, 1, m.DeviceID, m.Hostname, m.System.OS, m.System.Platform,
+			m.System.PlatformVersion, m.System.KernelVersion, m.System.KernelArch)
+		gauge(c.uptime, float64(m.System.Uptime))
+		gauge(c.bootTime, float64(m.System.BootTime.Unix()))
+	} else {
+		gauge(c.info, 1, m.DeviceID, m.Hostname, "", "", "", "", "")
+	}
+
+	if m.CPU != nil {
+		gauge(c.cpuUsage, m.CPU.UsagePercent)
+		gauge(c.cpuCores, float64(m.CPU.Cores))
+		for i, usage := range m.CPU.PerCore {
+			gauge(c.cpuCoreUsage, usage, strconv.Itoa(i))
+		}
+	}
+
+	if m.Memory != nil {
+		gauge(c.memTotal, float64(m.Memory.Total))
+		gauge(c.memAvailable, float64(m.Memory.Available))
+		gauge(c.memUsed, float64(m.Memory.Used))
+		gauge(c.memFree, float64(m.Memory.Free))
+		gauge(c.memUsedPercent, m.Memory.UsedPercent)
+		gauge(c.swapTotal, float64(m.Memory.SwapTotal))
+		gauge(c.swapUsed, float64(m.Memory.SwapUsed))
+		gauge(c.swapFree, float64(m.Memory.SwapFree))
+	}
+
+	for _, disk := range m.Disks {
+		gauge(c.diskTotal, float64(disk.Total), disk.Device, disk.Mountpoint, disk.FsType)
+		gauge(c.diskUsed, float64(disk.Used), disk.Device, disk.Mountpoint, disk.FsType)
+		gauge(c.diskFree, float64(disk.Free), disk.Device, disk.Mountpoint, disk.FsType)
+		gauge(c.diskUsedPercent, disk.UsedPercent, disk.Device, disk.Mountpoint, disk.FsType)
+		if disk.InodesTotal > 0 {
+			gauge(c.inodesTotal, float64(disk.InodesTotal), disk.Device, disk.Mountpoint, disk.FsType)
+			gauge(c.inodesUsed, float64(disk.InodesUsed), disk.Device, disk.Mountpoint, disk.FsType)
+			gauge(c.inodesFree, float64(disk.InodesFree), disk.Device, disk.Mountpoint, disk.FsType)
+			gauge(c.inodesPercent, disk.InodesUsedPercent, disk.Device, disk.Mountpoint, disk.FsType)
+		}
+	}
+
+	if m.Network != nil {
+		counter(c.netBytesSent, m.Network.BytesSent)
+		counter(c.netBytesRecv, m.Network.BytesRecv)
+		counter(c.netPacketsSent, m.Network.PacketsSent)
+		counter(c.netPacketsRecv, m.Network.PacketsRecv)
+		counter(c.netErrorsIn, m.Network.ErrorsIn)
+		counter(c.netErrorsOut, m.Network.ErrorsOut)
+		counter(c.netDropsIn, m.Network.DropsIn)
+		counter(c.netDropsOut, m.Network.DropsOut)
+	}
+
+	for _, unit := range m.Services {
+		gauge(c.unitActive, boolValue(unit.ActiveState == "active"), unit.Name)
+		gauge(c.unitFailed, boolValue(unit.ActiveState == "failed"), unit.Name)
+		gauge(c.unitRestarts, float64(unit.Restarts), unit.Name)
+	}
+
+	if m.Sensors != nil {
+		for _, t := range m.Sensors.Temperatures {
+			gauge(c.temperature, t.Celsius, t.Key)
+			// Zero means the sensor has no such threshold
+			if t.High > 0 {
+				gauge(c.temperatureHigh, t.High, t.Key)
+			}
+			if t.Critical > 0 {
+				gauge(c.temperatureCrit, t.Critical, t.Key)
+			}
+		}
+		for _, fan := range m.Sensors.Fans {
+			gauge(c.fanSpeed, fan.RPM, fan.Key)
+		}
+		for _, p := range m.Sensors.Power {
+			gauge(c.power, p.Watts, p.Key)
+		}
+	}
+
+	for _, ctr := range m.Containers {
+		labels := []string{ctr.ID, ctr.Name, ctr.Image}
+		gauge(c.ctrCPUPercent, ctr.CPUPercent, labels...)
+		ch <- prometheus.MustNewConstMetric(c.ctrCPUSeconds, prometheus.CounterValue, ctr.CPUSeconds, labels...)
+		gauge(c.ctrMemUsage, float64(ctr.MemoryUsage), labels...)
+		if ctr.MemoryLimit > 0 {
+			gauge(c.ctrMemLimit, float64(ctr.MemoryLimit), labels...)
+		}
+		counter(c.ctrBlockRead, ctr.BlockReadBytes, labels...)
+		counter(c.ctrBlockWrite, ctr.BlockWriteBytes, labels...)
+		counter(c.ctrNetRecv, ctr.NetworkRxBytes, labels...)
+		counter(c.ctrNetSent, ctr.NetworkTxBytes, labels...)
+		gauge(c.ctrPids, float64(ctr.Pids), labels...)
+		gauge(c.ctrRestarts, float64(ctr.RestartCount), labels...)
+	}
+
+	for _, check := range m.Checks {
+		labels := []string{check.Name, check.Type, check.Target}
+		gauge(c.checkSuccess, boolValue(check.Success), labels...)
+		gauge(c.checkLatency, check.LatencyMs/1000, labels...)
+		if check.StatusCode != 0 {
+			gauge(c.checkStatus, float64(check.StatusCode), labels...)
+		}
+		if check.CertExpiresAt != nil {
+			gauge(c.checkCertExpiry, float64(check.CertExpiresAt.Unix()), labels...)
+		}
+	}
+
+	for _, script := range m.Scripts {
+		gauge(c.scriptStatus, scriptStatusValue(script.Status), script.Name)
+		gauge(c.scriptDuration, script.DurationMs/1000, script.Name)
+		seen := make(map[string]bool, len(script.Perfdata))
+		for _, perf := range script.Perfdata {
+			// Duplicate labels would make the scrape fail
+			if seen[perf.Label] {
+				continue
+			}
+			seen[perf.Label] = true
+			gauge(c.scriptPerfdata, perf.Value, script.Name, perf.Label, perf.Unit)
+		}
+	}
+}
+
+// scriptStatusValue maps a Nagios state to its exit code
+func scriptStatusValue(status string) float64 {
+	switch status {
+	case "OK":
+		return 0
+	case "WARNING":
+		return 1
+	case "CRITICAL":
+		return 2
+	}
+	return 3
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
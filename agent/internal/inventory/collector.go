@@ -0,0 +1,193 @@
+// Package inventory collects the hardware and software inventory of the host:
+// processors, memory modules, disks, network interfaces, firmware and
+// installed packages. The inventory changes rarely, so it is collected at a
+// low rate and only sent when it differs from what the server last accepted.
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// Collector collects the inventory and sends it when it changes
+type Collector struct {
+	mu        sync.RWMutex
+	config    *config.Config
+	apiClient *api.Client
+
+	// sent is the hash of the last inventory the server accepted
+	sent string
+}
+
+// NewCollector creates a new inventory collector
+func NewCollector(cfg *config.Config, client *api.Client) *Collector {
+	return &Collector{
+		config:    cfg,
+		apiClient: client,
+	}
+}
+
+// UpdateConfig applies a reloaded configuration to subsequent collections
+func (c *Collector) UpdateConfig(cfg *config.Config) {
+	c.mu.Lock()
+	c.config = cfg
+	c.mu.Unlock()
+}
+
+// Collect gathers the inventory. Parts that cannot be read are left empty
+// rather than failing the whole inventory.
+func (c *Collector) Collect(ctx context.Context) *models.Inventory {
+	c.mu.RLock()
+	cfg := c.config
+	c.mu.RUnlock()
+
+	inv := &models.Inventory{
+		DeviceID:  cfg.Agent.DeviceID,
+		Hostname:  cfg.Agent.Hostname,
+		Timestamp: time.Now(),
+	}
+
+	warn := func(part string, err error) {
+		if err != nil {
+			log.WithError(err).WithField("part", part).Warn("Failed to collect inventory")
+		}
+	}
+
+	var err error
+	inv.Hardware.CPU, err = readCPU(ctx)
+	warn("cpu", err)
+	inv.Hardware.BIOS, inv.Hardware.System = readDMI()
+	inv.Hardware.Memory, err = readMemoryModules()
+	warn("memory", err)
+	inv.Hardware.Disks, err = readDisks()
+	warn("disks", err)
+	inv.Hardware.NICs, err = readNICs()
+	warn("nics", err)
+
+	if cfg.Inventory.Packages {
+		inv.Packages, err = readPackages(ctx)
+		warn("packages", err)
+		sort.Slice(inv.Packages, func(i, j int) bool {
+			a, b := inv.Packages[i], inv.Packages[j]
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Arch < b.Arch
+		})
+	}
+
+	return inv
+}
+
+// CollectAndSend collects the inventory and sends it to the server if it
+// changed since the last inventory the server accepted
+func (c *Collector) CollectAndSend(ctx context.Context) error {
+	c.mu.RLock()
+	enabled := c.config.Agent.EnableInventory
+	c.mu.RUnlock()
+	if !enabled {
+		return nil
+	}
+
+	inv := c.Collect(ctx)
+	sum, err := hash(inv)
+	if err != nil {
+		return err
+	}
+	if sum == c.sent {
+		log.Debug("Inventory unchanged, not sending it")
+		return nil
+	}
+
+	if err := c.apiClient.SendInventory(inv); err != nil {
+		return fmt.Errorf("failed to send inventory: %w", err)
+	}
+	c.sent = sum
+
+	log.WithFields(log.Fields{
+		"disks":    len(inv.Hardware.Disks),
+		"nics":     len(inv.Hardware.NICs),
+		"packages": len(inv.Packages),
+	}).Info("Inventory sent")
+	return nil
+}
+
+// hash identifies the contents of an inventory, ignoring when it was taken
+func hash(inv *models.Inventory) (string, error) {
+	data, err := json.Marshal(struct {
+		Hardware models.HardwareInventory
+		Packages []models.Package
+	}{inv.Hardware, inv.Packages})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode inventory: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readCPU describes the processors. The clock speed is left out, as it
+// follows frequency scaling and would make every inventory look changed.
+func readCPU(ctx context.Context) (models.CPUInventory, error) {
+	var inv models.CPUInventory
+	if cores, err := cpu.CountsWithContext(ctx, false); err == nil {
+		inv.Cores = cores
+	}
+	if threads, err := cpu.CountsWithContext(ctx, true); err == nil {
+		inv.Threads = threads
+	}
+
+	infos, err := cpu.InfoWithContext(ctx)
+	if err != nil {
+		return inv, err
+	}
+	sockets := make(map[string]bool)
+	for _, info := range infos {
+		if info.PhysicalID != "" {
+			sockets[info.PhysicalID] = true
+		}
+	}
+	inv.Sockets = len(sockets)
+	if len(infos) > 0 {
+		inv.Model = strings.Join(strings.Fields(infos[0].ModelName), " ")
+		inv.Vendor = infos[0].VendorID
+	}
+	return inv, nil
+}
+
+// placeholders are values firmware vendors leave in DMI fields they did not fill in
+var placeholders = map[string]bool{
+	"":                       true,
+	"default string":         true,
+	"not specified":          true,
+	"not applicable":         true,
+	"none":                   true,
+	"unknown":                true,
+	"system serial number":   true,
+	"system product name":    true,
+	"system manufacturer":    true,
+	"to be filled by o.e.m.": true,
+	"o.e.m.":                 true,
+	"0123456789":             true,
+}
+
+// clean trims a firmware string and drops placeholder values
+func clean(value string) string {
+	value = strings.TrimSpace(value)
+	if placeholders[strings.ToLower(value)] {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,154 @@
+package inventory
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// sysRoot returns where sysfs is mounted. HOST_SYS overrides /sys as it does
+// for gopsutil, for agents running in a container with the host's /sys
+// mounted elsewhere.
+func sysRoot() string {
+	if sys := os.Getenv("HOST_SYS"); sys != "" {
+		return sys
+	}
+	return "/sys"
+}
+
+// readSysfs returns the trimmed contents of a sysfs attribute, or "" when it
+// cannot be read
+func readSysfs(path ...string) string {
+	data, err := os.ReadFile(filepath.Join(path...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readDMI reads the firmware and product identification the kernel exposes
+// from the DMI tables. The serial number and UUID are only readable by root.
+func readDMI() (models.BIOSInventory, models.ProductInventory) {
+	dir := filepath.Join(sysRoot(), "class/dmi/id")
+	bios := models.BIOSInventory{
+		Vendor:  clean(readSysfs(dir, "bios_vendor")),
+		Version: clean(readSysfs(dir, "bios_version")),
+		Date:    clean(readSysfs(dir, "bios_date")),
+	}
+	product := models.ProductInventory{
+		Manufacturer: clean(readSysfs(dir, "sys_vendor")),
+		Product:      clean(readSysfs(dir, "product_name")),
+		Serial:       clean(readSysfs(dir, "product_serial")),
+		UUID:         strings.ToLower(clean(readSysfs(dir, "product_uuid"))),
+	}
+	return bios, product
+}
+
+// readMemoryModules reads the installed RAM modules from the raw SMBIOS
+// table, which only root can read; other users get no modules
+func readMemoryModules() ([]models.MemoryModule, error) {
+	table, err := os.ReadFile(filepath.Join(sysRoot(), "firmware/dmi/tables/DMI"))
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseMemoryDevices(table), nil
+}
+
+// readDisks lists the block devices backed by hardware. Partitions, loop
+// devices, device-mapper and software RAID volumes have no device link and
+// are left out, as are drives without media.
+func readDisks() ([]models.DiskInventory, error) {
+	dir := filepath.Join(sysRoot(), "block")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []models.DiskInventory
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, err := os.Stat(filepath.Join(dir, name, "device")); err != nil {
+			continue
+		}
+		sectors, err := strconv.ParseUint(readSysfs(dir, name, "size"), 10, 64)
+		if err != nil || sectors == 0 {
+			continue
+		}
+
+		disk := models.DiskInventory{
+			Name:       name,
+			Model:      clean(readSysfs(dir, name, "device/model")),
+			Serial:     clean(readSysfs(dir, name, "device/serial")),
+			Size:       sectors * 512, // sysfs counts 512-byte sectors regardless of the device
+			Rotational: readSysfs(dir, name, "queue/rotational") == "1",
+		}
+		if disk.Serial == "" {
+			disk.Serial = udevSerial(readSysfs(dir, name, "dev"))
+		}
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// udevSerial looks up the serial number udev recorded for a block device,
+// given as major:minor. SATA and SCSI disks do not expose it in sysfs.
+func udevSerial(dev string) string {
+	if dev == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join("/run/udev/data", "b"+dev))
+	if err != nil {
+		return ""
+	}
+	var serial string
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "E:ID_SERIAL_SHORT="); ok {
+			return clean(value)
+		}
+		if value, ok := strings.CutPrefix(line, "E:ID_SERIAL="); ok {
+			serial = clean(value)
+		}
+	}
+	return serial
+}
+
+// readNICs lists the network interfaces backed by hardware, leaving out
+// loopback, bridges, veth pairs and other virtual interfaces that come and go
+// with containers
+func readNICs() ([]models.NICInventory, error) {
+	dir := filepath.Join(sysRoot(), "class/net")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var nics []models.NICInventory
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, err := os.Stat(filepath.Join(dir, name, "device")); err != nil {
+			continue
+		}
+		nic := models.NICInventory{
+			Name: name,
+			MAC:  readSysfs(dir, name, "address"),
+		}
+		nic.MTU, _ = strconv.Atoi(readSysfs(dir, name, "mtu"))
+		// Reading the speed fails for a link that is down, and some drivers report -1
+		if speed, err := strconv.Atoi(readSysfs(dir, name, "speed")); err == nil && speed > 0 {
+			nic.SpeedMbps = speed
+		}
+		if driver, err := os.Readlink(filepath.Join(dir, name, "device/driver")); err == nil {
+			nic.Driver = filepath.Base(driver)
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
@@ -0,0 +1,53 @@
+//go:build !linux
+
+package inventory
+
+import (
+	"context"
+	"slices"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// readDMI reports no firmware details; only Linux exposes the DMI tables
+// without tools
+func readDMI() (models.BIOSInventory, models.ProductInventory) {
+	return models.BIOSInventory{}, models.ProductInventory{}
+}
+
+// readMemoryModules reports no modules; the SMBIOS table is only read on Linux
+func readMemoryModules() ([]models.MemoryModule, error) {
+	return nil, nil
+}
+
+// readDisks reports no disks; physical disks are only listed on Linux
+func readDisks() ([]models.DiskInventory, error) {
+	return nil, nil
+}
+
+// readNICs lists the interfaces with a hardware address, other than loopback
+func readNICs() ([]models.NICInventory, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var nics []models.NICInventory
+	for _, iface := range interfaces {
+		if iface.HardwareAddr == "" || slices.Contains(iface.Flags, "loopback") {
+			continue
+		}
+		nics = append(nics, models.NICInventory{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr,
+			MTU:  iface.MTU,
+		})
+	}
+	return nics, nil
+}
+
+// readPackages reports no packages; only dpkg and rpm databases are read
+func readPackages(ctx context.Context) ([]models.Package, error) {
+	return nil, nil
+}
@@ -0,0 +1,159 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// rpmTimeout bounds how long listing the rpm database may take
+const rpmTimeout = time.Minute
+
+// rpmQueryFormat prints name, epoch:version-release and architecture per package
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\n`
+
+// varRoot returns where /var is found. HOST_VAR overrides it as it does for
+// gopsutil.
+func varRoot() string {
+	if dir := os.Getenv("HOST_VAR"); dir != "" {
+		return dir
+	}
+	return "/var"
+}
+
+// readPackages lists the packages installed through dpkg and rpm. Each
+// package manager whose database is missing is skipped.
+func readPackages(ctx context.Context) ([]models.Package, error) {
+	var packages []models.Package
+	var errs []error
+
+	dpkg, err := readDpkgStatus(filepath.Join(varRoot(), "lib/dpkg/status"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	packages = append(packages, dpkg...)
+
+	rpm, err := readRPM(ctx, filepath.Join(varRoot(), "lib/rpm"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	packages = append(packages, rpm...)
+
+	return packages, errors.Join(errs...)
+}
+
+// readDpkgStatus parses dpkg's status database, a list of paragraphs of
+// "Field: value" lines separated by blank lines
+func readDpkgStatus(path string) ([]models.Package, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dpkg status: %w", err)
+	}
+	defer f.Close()
+
+	var packages []models.Package
+	var pkg models.Package
+	var installed bool
+	flush := func() {
+		if installed && pkg.Name != "" {
+			pkg.Manager = "dpkg"
+			packages = append(packages, pkg)
+		}
+		pkg, installed = models.Package{}, false
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of a multi-line field such as Description
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "Package":
+			pkg.Name = value
+		case "Version":
+			pkg.Version = value
+		case "Architecture":
+			pkg.Arch = value
+		case "Status":
+			// e.g. "install ok installed"; removed packages keep their config files as "config-files"
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dpkg status: %w", err)
+	}
+	return packages, nil
+}
+
+// readRPM lists the packages in the rpm database. The database format
+// differs between distributions and rpm versions, so it is read through the
+// rpm command rather than directly.
+func readRPM(ctx context.Context, dbPath string) ([]models.Package, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, nil
+	}
+	if _, err := exec.LookPath("rpm"); err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rpmTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "rpm", "--dbpath", dbPath, "-qa", "--queryformat", rpmQueryFormat)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("rpm: %s", msg)
+		}
+		return nil, fmt.Errorf("rpm: %w", err)
+	}
+
+	var packages []models.Package
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] == "" {
+			continue
+		}
+		arch := fields[2]
+		if arch == "(none)" {
+			// gpg-pubkey entries have no architecture
+			arch = ""
+		}
+		packages = append(packages, models.Package{
+			Name:    fields[0],
+			Version: fields[1],
+			Arch:    arch,
+			Manager: "rpm",
+		})
+	}
+	return packages, nil
+}
@@ -0,0 +1,110 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// SMBIOS structure types used from the table
+const (
+	smbiosMemoryDevice = 17
+	smbiosEndOfTable   = 127
+)
+
+// memoryTypes names the SMBIOS memory device types still found in servers
+var memoryTypes = map[byte]string{
+	0x0f: "SDRAM",
+	0x12: "DDR",
+	0x13: "DDR2",
+	0x18: "DDR3",
+	0x1a: "DDR4",
+	0x1b: "LPDDR",
+	0x1c: "LPDDR2",
+	0x1d: "LPDDR3",
+	0x1e: "LPDDR4",
+	0x22: "DDR5",
+	0x23: "LPDDR5",
+}
+
+// parseMemoryDevices extracts the populated memory slots from a raw SMBIOS
+// table. Each structure is a formatted area, whose second byte is its length,
+// followed by the strings it refers to by index, ending in two NUL bytes.
+func parseMemoryDevices(table []byte) []models.MemoryModule {
+	var modules []models.MemoryModule
+	for len(table) >= 4 {
+		kind, length := table[0], int(table[1])
+		if length < 4 || length > len(table) {
+			break
+		}
+		end := bytes.Index(table[length:], []byte{0, 0})
+		if end < 0 {
+			break
+		}
+		formatted := table[:length]
+		strs := strings.Split(string(table[length:length+end]), "\x00")
+		table = table[length+end+2:]
+
+		if kind == smbiosEndOfTable {
+			break
+		}
+		if kind != smbiosMemoryDevice || length < 0x15 {
+			continue
+		}
+		if module, ok := memoryDevice(formatted, strs); ok {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// memoryDevice decodes a memory device structure, reporting false for an
+// empty slot
+func memoryDevice(s []byte, strs []string) (models.MemoryModule, bool) {
+	str := func(offset int) string {
+		if offset >= len(s) {
+			return ""
+		}
+		index := int(s[offset])
+		if index == 0 || index > len(strs) {
+			return ""
+		}
+		return clean(strs[index-1])
+	}
+	word := func(offset int) uint16 {
+		return binary.LittleEndian.Uint16(s[offset:])
+	}
+
+	var size uint64
+	switch raw := word(0x0c); {
+	case raw == 0 || raw == 0xffff:
+		// Empty slot, or a module of unknown size
+		return models.MemoryModule{}, false
+	case raw == 0x7fff && len(s) >= 0x20:
+		// 32 GiB or more: the extended size field holds MiB
+		size = uint64(binary.LittleEndian.Uint32(s[0x1c:])&0x7fffffff) << 20
+	case raw&0x8000 != 0:
+		size = uint64(raw&0x7fff) << 10
+	default:
+		size = uint64(raw) << 20
+	}
+
+	module := models.MemoryModule{
+		Locator: str(0x10),
+		Size:    size,
+		Type:    memoryTypes[s[0x12]],
+	}
+	if len(s) >= 0x17 {
+		if speed := word(0x15); speed != 0 && speed != 0xffff {
+			module.Speed = int(speed)
+		}
+	}
+	if len(s) >= 0x1b {
+		module.Manufacturer = str(0x17)
+		module.Serial = str(0x18)
+		module.PartNumber = str(0x1a)
+	}
+	return module, true
+}
@@ -0,0 +1,206 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pollInterval is how often files are checked for new lines
+const pollInterval = time.Second
+
+// fileTailer follows a file through rotation and truncation. A file that
+// is renamed away is read to its end before the new file at the path is
+// opened, and a file truncated in place is read again from the start.
+type fileTailer struct {
+	path    string
+	offsets *offsets
+	handle  func(line string)
+
+	file     *os.File
+	info     os.FileInfo
+	offset   int64
+	partial  []byte
+	skipping bool // discarding the rest of an over-long line
+	buf      []byte
+
+	// fromStart makes the next file opened be read from the beginning,
+	// because it appeared after tailing began
+	fromStart bool
+}
+
+func (t *fileTailer) run(ctx context.Context) {
+	t.buf = make([]byte, 32<<10)
+	defer t.close()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for {
+		// Log an error once rather than on every poll
+		if err := t.poll(); err != nil {
+			if err.Error() != lastErr {
+				log.WithError(err).WithField("path", t.path).Warn("Failed to read log file")
+			}
+			lastErr = err.Error()
+		} else {
+			lastErr = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the lines appended since the last poll and checks whether the
+// file was rotated or truncated
+func (t *fileTailer) poll() error {
+	if t.file == nil {
+		if err := t.open(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				t.fromStart = true
+				return nil
+			}
+			return err
+		}
+	}
+
+	if err := t.read(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Rotated away and not recreated yet; keep reading the old file
+		return nil
+	case err != nil:
+		return err
+	case !os.SameFile(t.info, info):
+		// Rotated: finish the old file, then start the new one from the beginning
+		if err := t.read(); err != nil {
+			return err
+		}
+		if len(t.partial) > 0 && !t.skipping {
+			t.handle(string(t.partial))
+		}
+		t.close()
+		t.fromStart = true
+	case info.Size() < t.offset:
+		// Truncated in place, e.g. by logrotate's copytruncate
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.offset = 0
+		t.partial = t.partial[:0]
+		t.skipping = false
+	}
+	return nil
+}
+
+// open opens the file at the path, resuming from the saved offset when it is
+// still the same file. A file seen for the first time is read from its end,
+// so that starting the agent does not forward a file's whole history.
+func (t *fileTailer) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var offset int64
+	saved, ok := t.offsets.file(t.path)
+	switch {
+	case t.fromStart:
+	case ok && saved.Offset <= info.Size() && fingerprint(f, saved.Offset) == saved.Fingerprint:
+		offset = saved.Offset
+	case ok:
+		// Replaced while the agent was stopped; all of it is new
+	default:
+		offset = info.Size()
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	t.file, t.info, t.offset = f, info, offset
+	t.partial, t.skipping, t.fromStart = t.partial[:0], false, false
+	return nil
+}
+
+// read hands every complete line up to the end of the file to handle and
+// records the position after the last one
+func (t *fileTailer) read() error {
+	for {
+		n, err := t.file.Read(t.buf)
+		if n > 0 {
+			t.offset += int64(n)
+			t.split(t.buf[:n])
+		}
+		if err == io.EOF || n == 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A partial line is read again after a restart
+	committed := t.offset - int64(len(t.partial))
+	t.offsets.setFile(t.path, fileOffset{
+		Offset:      committed,
+		Fingerprint: fingerprint(t.file, committed),
+	})
+	return nil
+}
+
+// split cuts data into lines, keeping an incomplete last line for the next
+// read. Lines longer than maxLineLength are truncated.
+func (t *fileTailer) split(data []byte) {
+	for len(data) > 0 {
+		chunk := data
+		i := bytes.IndexByte(data, '\n')
+		if i >= 0 {
+			chunk, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+
+		if !t.skipping {
+			t.partial = append(t.partial, chunk...)
+			if len(t.partial) > maxLineLength {
+				t.handle(string(t.partial[:maxLineLength]))
+				t.partial = t.partial[:0]
+				t.skipping = true
+			}
+		}
+		if i >= 0 {
+			if !t.skipping {
+				t.handle(string(bytes.TrimSuffix(t.partial, []byte("\r"))))
+			}
+			t.partial = t.partial[:0]
+			t.skipping = false
+		}
+	}
+}
+
+func (t *fileTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
@@ -0,0 +1,291 @@
+// Package logs tails log files and the systemd journal and forwards the lines
+// that match configured patterns to the server as events.
+package logs
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+const (
+	// flushInterval is how often pending events are sent
+	flushInterval = 5 * time.Second
+	// maxBatch bounds the events sent in one request
+	maxBatch = 500
+	// maxPending bounds the events kept while the server is unreachable
+	maxPending = 5000
+	// maxLineLength is where long lines are cut off
+	maxLineLength = 4096
+)
+
+// Forwarder tails the configured logs and forwards matching lines to the
+// server as events
+type Forwarder struct {
+	apiClient *api.Client
+
+	mu      sync.Mutex
+	pending []models.LogEvent
+	dropped map[string]int // matching lines not forwarded, by source
+}
+
+// NewForwarder creates a new log forwarder
+func NewForwarder(client *api.Client) *Forwarder {
+	return &Forwarder{
+		apiClient: client,
+		dropped:   make(map[string]int),
+	}
+}
+
+// Run tails the sources in cfg until ctx is cancelled, restarting them when
+// a configuration update changes them
+func (f *Forwarder) Run(ctx context.Context, cfg *config.Config, updates <-chan *config.Config) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	stop, offs := f.start(ctx, cfg)
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			f.flush(cfg)
+			saveOffsets(cfg, offs)
+			return
+		case next := <-updates:
+			changed := next.Agent.EnableLogs != cfg.Agent.EnableLogs || !reflect.DeepEqual(next.Logs, cfg.Logs)
+			if changed {
+				stop()
+				saveOffsets(cfg, offs)
+				stop, offs = f.start(ctx, next)
+			}
+			cfg = next
+		case <-ticker.C:
+			f.flush(cfg)
+			saveOffsets(cfg, offs)
+		}
+	}
+}
+
+// start begins tailing every configured source and returns a function that
+// stops them, along with the offsets they record their progress in
+func (f *Forwarder) start(ctx context.Context, cfg *config.Config) (func(), *offsets) {
+	if !cfg.Agent.EnableLogs || len(cfg.Logs.Sources) == 0 {
+		return func() {}, nil
+	}
+
+	offs, err := loadOffsets(cfg.Logs.StateDir)
+	if err != nil {
+		log.WithError(err).Warn("Failed to load log offsets, reading from the end of each log")
+	}
+	offs.retain(cfg.Logs.Sources)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, sc := range cfg.Logs.Sources {
+		include, exclude, err := sc.Patterns()
+		if err != nil {
+			// Validation compiles the patterns, so this is not expected
+			log.WithError(err).WithField("source", sc.Name).Error("Skipping log source")
+			continue
+		}
+		src := &source{
+			name:      sc.Name,
+			include:   include,
+			exclude:   exclude,
+			limiter:   newLimiter(cfg.Logs.RateLimit),
+			forwarder: f,
+		}
+
+		wg.Add(1)
+		go func(sc config.LogSource) {
+			defer wg.Done()
+			if sc.Journal {
+				(&journalReader{
+					source:  sc.Name,
+					units:   sc.Units,
+					offsets: offs,
+					handle: func(ts time.Time, unit, message string) {
+						src.line(ts, "", unit, message)
+					},
+				}).run(ctx)
+				return
+			}
+			(&fileTailer{
+				path:    sc.Path,
+				offsets: offs,
+				handle: func(line string) {
+					src.line(time.Now(), sc.Path, "", line)
+				},
+			}).run(ctx)
+		}(sc)
+	}
+
+	log.WithField("sources", len(cfg.Logs.Sources)).Info("Log forwarding started")
+	return func() {
+		cancel()
+		wg.Wait()
+	}, offs
+}
+
+// flush sends pending events in batches, keeping them for the next attempt
+// when the server cannot be reached
+func (f *Forwarder) flush(cfg *config.Config) {
+	for {
+		f.mu.Lock()
+		if len(f.pending) == 0 && len(f.dropped) == 0 {
+			f.mu.Unlock()
+			return
+		}
+		n := min(len(f.pending), maxBatch)
+		batch := &models.EventBatch{
+			DeviceID:  cfg.Agent.DeviceID,
+			Hostname:  cfg.Agent.Hostname,
+			Timestamp: time.Now(),
+			Events:    f.pending[:n:n],
+		}
+		if len(f.dropped) > 0 {
+			batch.Dropped = f.dropped
+			f.dropped = make(map[string]int)
+		}
+		f.mu.Unlock()
+
+		err := f.apiClient.SendEvents(batch)
+
+		f.mu.Lock()
+		if err != nil {
+			for name, count := range batch.Dropped {
+				f.dropped[name] += count
+			}
+			f.mu.Unlock()
+			log.WithError(err).WithField("events", n).Error("Failed to send log events")
+			return
+		}
+		// Only flush removes events, so the first n are still the ones sent
+		f.pending = f.pending[n:]
+		more := len(f.pending) >= maxBatch
+		f.mu.Unlock()
+
+		log.WithField("events", n).Debug("Log events sent successfully")
+		if !more {
+			return
+		}
+	}
+}
+
+// Emit queues an event raised by the agent itself, such as a threshold
+// crossing, to be sent with the log events
+func (f *Forwarder) Emit(event models.LogEvent) {
+	f.add(event)
+}
+
+func (f *Forwarder) add(event models.LogEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.pending) >= maxPending {
+		f.dropped[event.Source]++
+		return
+	}
+	f.pending = append(f.pending, event)
+}
+
+func (f *Forwarder) drop(source string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropped[source]++
+}
+
+func saveOffsets(cfg *config.Config, offs *offsets) {
+	if offs == nil {
+		return
+	}
+	if err := offs.save(cfg.Logs.StateDir); err != nil {
+		log.WithError(err).Warn("Failed to save log offsets")
+	}
+}
+
+// source turns the lines of one log into events
+type source struct {
+	name      string
+	include   []*regexp.Regexp
+	exclude   []*regexp.Regexp
+	limiter   *limiter
+	forwarder *Forwarder
+}
+
+// line forwards a line that matches an include pattern and no exclude
+// pattern, subject to the rate limit
+func (s *source) line(ts time.Time, path, unit, text string) {
+	pattern, ok := s.match(text)
+	if !ok {
+		return
+	}
+	if !s.limiter.allow(time.Now()) {
+		s.forwarder.drop(s.name)
+		return
+	}
+
+	if len(text) > maxLineLength {
+		text = text[:maxLineLength]
+	}
+	s.forwarder.add(models.LogEvent{
+		Timestamp: ts,
+		Source:    s.name,
+		Path:      path,
+		Unit:      unit,
+		Pattern:   pattern,
+		Message:   strings.ToValidUTF8(text, "\uFFFD"),
+	})
+}
+
+// match returns the first include pattern the line matches
+func (s *source) match(text string) (string, bool) {
+	for _, re := range s.include {
+		if !re.MatchString(text) {
+			continue
+		}
+		for _, ex := range s.exclude {
+			if ex.MatchString(text) {
+				return "", false
+			}
+		}
+		return re.String(), true
+	}
+	return "", false
+}
+
+// limiter is a token bucket allowing a number of events per minute, with
+// bursts of up to a minute's worth
+type limiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	return &limiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(perMinute),
+		tokens: float64(perMinute),
+	}
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// journalRestartDelay is how long to wait before restarting journalctl after it exits
+const journalRestartDelay = 10 * time.Second
+
+// journalReader follows the systemd journal through journalctl, resuming
+// after the cursor of the last entry it read
+type journalReader struct {
+	source  string
+	units   []string
+	offsets *offsets
+	handle  func(ts time.Time, unit, message string)
+}
+
+// journalEntry holds the fields used from journalctl's JSON output
+type journalEntry struct {
+	Cursor   string          `json:"__CURSOR"`
+	Realtime string          `json:"__REALTIME_TIMESTAMP"` // microseconds since the epoch
+	Unit     string          `json:"_SYSTEMD_UNIT"`
+	Message  json.RawMessage `json:"MESSAGE"`
+}
+
+func (r *journalReader) run(ctx context.Context) {
+	for {
+		err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).WithField("source", r.source).Warn("Journal reader stopped, restarting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(journalRestartDelay):
+		}
+	}
+}
+
+// follow runs journalctl until it exits or ctx is cancelled
+func (r *journalReader) follow(ctx context.Context) error {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	if cursor := r.offsets.cursor(r.source); cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		// As with files, only entries from now on are of interest
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range r.units {
+		args = append(args, "--unit="+unit)
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start journalctl: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		r.handle(entry.time(), entry.Unit, entry.message())
+		if entry.Cursor != "" {
+			r.offsets.setCursor(r.source, entry.Cursor)
+		}
+	}
+	scanErr := scanner.Err()
+
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("journalctl: %s", msg)
+		}
+		return fmt.Errorf("journalctl: %w", err)
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	return fmt.Errorf("journalctl exited")
+}
+
+func (e *journalEntry) time() time.Time {
+	usec, err := strconv.ParseInt(e.Realtime, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMicro(usec)
+}
+
+// message returns MESSAGE, which journalctl encodes as an array of bytes
+// when it is not valid UTF-8
+func (e *journalEntry) message() string {
+	var text string
+	if err := json.Unmarshal(e.Message, &text); err == nil {
+		return text
+	}
+	var numbers []int
+	if err := json.Unmarshal(e.Message, &numbers); err != nil {
+		return ""
+	}
+	raw := make([]byte, len(numbers))
+	for i, n := range numbers {
+		raw[i] = byte(n)
+	}
+	return string(raw)
+}
@@ -0,0 +1,163 @@
+package logs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+)
+
+// offsetsFile is the name of the file read positions are kept in
+const offsetsFile = "log-offsets.json"
+
+// fingerprintSize is how much of the start of a file identifies it
+const fingerprintSize = 256
+
+// offsets records how far each source has been read, so that after a
+// restart lines are neither missed nor forwarded twice
+type offsets struct {
+	mu    sync.Mutex
+	state offsetState
+	dirty bool
+}
+
+type offsetState struct {
+	Files   map[string]fileOffset `json:"files,omitempty"`   // by path
+	Journal map[string]string     `json:"journal,omitempty"` // cursor by source name
+}
+
+// fileOffset is the read position in a file
+type fileOffset struct {
+	Offset int64 `json:"offset"`
+	// Fingerprint is a hash of the file's first bytes, to tell whether the
+	// file at the path is still the one the offset belongs to
+	Fingerprint string `json:"fingerprint"`
+}
+
+// loadOffsets reads the offsets file, returning empty offsets if none exists
+func loadOffsets(dir string) (*offsets, error) {
+	o := &offsets{}
+	data, err := os.ReadFile(filepath.Join(dir, offsetsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return o, fmt.Errorf("failed to read log offsets: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.state); err != nil {
+		return o, fmt.Errorf("failed to parse log offsets: %w", err)
+	}
+	return o, nil
+}
+
+// retain forgets the positions of sources that are no longer configured
+func (o *offsets) retain(sources []config.LogSource) {
+	paths := make(map[string]bool, len(sources))
+	names := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		if source.Journal {
+			names[source.Name] = true
+		} else {
+			paths[source.Path] = true
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for path := range o.state.Files {
+		if !paths[path] {
+			delete(o.state.Files, path)
+			o.dirty = true
+		}
+	}
+	for name := range o.state.Journal {
+		if !names[name] {
+			delete(o.state.Journal, name)
+			o.dirty = true
+		}
+	}
+}
+
+func (o *offsets) file(path string) (fileOffset, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pos, ok := o.state.Files[path]
+	return pos, ok
+}
+
+func (o *offsets) setFile(path string, pos fileOffset) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.state.Files[path] == pos {
+		return
+	}
+	if o.state.Files == nil {
+		o.state.Files = make(map[string]fileOffset)
+	}
+	o.state.Files[path] = pos
+	o.dirty = true
+}
+
+func (o *offsets) cursor(source string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.state.Journal[source]
+}
+
+func (o *offsets) setCursor(source, cursor string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.state.Journal == nil {
+		o.state.Journal = make(map[string]string)
+	}
+	o.state.Journal[source] = cursor
+	o.dirty = true
+}
+
+// save writes the offsets file atomically if anything changed
+func (o *offsets) save(dir string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(o.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode log offsets: %w", err)
+	}
+
+	path := filepath.Join(dir, offsetsFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write log offsets: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write log offsets: %w", err)
+	}
+	o.dirty = false
+	return nil
+}
+
+// fingerprint hashes the first bytes of f, up to size
+func fingerprint(f *os.File, size int64) string {
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,269 @@
+package monitor
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// containerCgroupPattern matches the cgroup directory of a container under
+// the common runtimes: docker/<id> (cgroupfs driver), docker-<id>.scope
+// (systemd driver), libpod-<id>.scope (Podman), cri-containerd-<id>.scope and
+// crio-<id>.scope (Kubernetes)
+var containerCgroupPattern = regexp.MustCompile(`^(?:(docker|libpod|cri-containerd|crio)-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupUnlimited is the threshold above which a memory limit means none is set
+const cgroupUnlimited = 1 << 62
+
+// containerCgroup is a container found in the cgroup tree
+type containerCgroup struct {
+	ID      string
+	Runtime string
+	Path    string // relative to the cgroup root, or to each controller on v1
+}
+
+// cgroupStats is what the cgroup filesystem reports for one container
+type cgroupStats struct {
+	CPUUsageNanos   uint64
+	MemoryUsage     uint64 // excluding inactive page cache, as docker stats reports it
+	MemoryLimit     uint64 // 0 when unlimited
+	BlockReadBytes  uint64
+	BlockWriteBytes uint64
+	Pids            uint64
+}
+
+// cgroupFS reads container cgroups below root, usually /sys/fs/cgroup
+type cgroupFS struct {
+	root string
+}
+
+// unified reports whether root is a cgroup v2 (unified) hierarchy
+func (c cgroupFS) unified() bool {
+	_, err := os.Stat(filepath.Join(c.root, "cgroup.controllers"))
+	return err == nil
+}
+
+// base is the directory container paths are relative to: the root on v2, the
+// memory controller on v1
+func (c cgroupFS) base() string {
+	if c.unified() {
+		return c.root
+	}
+	return filepath.Join(c.root, "memory")
+}
+
+// Containers lists the container cgroups in the hierarchy
+func (c cgroupFS) Containers() ([]containerCgroup, error) {
+	base := c.base()
+	var containers []containerCgroup
+
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == base {
+				return err
+			}
+			// Cgroups come and go while walking
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		match := containerCgroupPattern.FindStringSubmatch(d.Name())
+		if match == nil {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return nil
+		}
+		containers = append(containers, containerCgroup{
+			ID:      match[2],
+			Runtime: cgroupRuntime(match[1], rel),
+			Path:    rel,
+		})
+		// Nested cgroups belong to the container
+		return filepath.SkipDir
+	})
+	return containers, err
+}
+
+// cgroupRuntime guesses the container runtime from the directory prefix or
+// its ancestors
+func cgroupRuntime(prefix, rel string) string {
+	switch prefix {
+	case "docker":
+		return "docker"
+	case "libpod":
+		return "podman"
+	case "cri-containerd":
+		return "containerd"
+	case "crio":
+		return "cri-o"
+	}
+	switch {
+	case strings.Contains(rel, "kubepods"):
+		return "kubernetes"
+	case strings.Contains(rel, "docker"):
+		return "docker"
+	case strings.Contains(rel, "libpod"), strings.Contains(rel, "machine.slice"):
+		return "podman"
+	}
+	return "unknown"
+}
+
+// Stats reads the resource usage of a container cgroup
+func (c cgroupFS) Stats(ct containerCgroup) (cgroupStats, error) {
+	if c.unified() {
+		return c.statsV2(filepath.Join(c.root, ct.Path))
+	}
+	return c.statsV1(ct.Path)
+}
+
+// Procs returns the processes in a container cgroup
+func (c cgroupFS) Procs(ct containerCgroup) []int {
+	data, err := os.ReadFile(filepath.Join(c.base(), ct.Path, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+func (c cgroupFS) statsV2(dir string) (cgroupStats, error) {
+	var stats cgroupStats
+
+	cpu, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUUsageNanos = cpu["usage_usec"] * 1000
+
+	stats.MemoryUsage, _ = readUint(filepath.Join(dir, "memory.current"))
+	if memory, err := readKeyValues(filepath.Join(dir, "memory.stat")); err == nil {
+		stats.MemoryUsage = subtractFloor(stats.MemoryUsage, memory["inactive_file"])
+	}
+	// memory.max is "max" when unlimited, which fails to parse and stays 0
+	stats.MemoryLimit, _ = readUint(filepath.Join(dir, "memory.max"))
+
+	// io.stat: "8:0 rbytes=1 wbytes=2 rios=3 wios=4 ..." per device
+	if lines, err := readLines(filepath.Join(dir, "io.stat")); err == nil {
+		for _, line := range lines {
+			for _, field := range strings.Fields(line)[1:] {
+				key, value, _ := strings.Cut(field, "=")
+				n, _ := strconv.ParseUint(value, 10, 64)
+				switch key {
+				case "rbytes":
+					stats.BlockReadBytes += n
+				case "wbytes":
+					stats.BlockWriteBytes += n
+				}
+			}
+		}
+	}
+
+	stats.Pids, _ = readUint(filepath.Join(dir, "pids.current"))
+	return stats, nil
+}
+
+func (c cgroupFS) statsV1(rel string) (cgroupStats, error) {
+	var stats cgroupStats
+
+	usage, err := readUint(filepath.Join(c.root, "cpuacct", rel, "cpuacct.usage"))
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUUsageNanos = usage
+
+	memory := filepath.Join(c.root, "memory", rel)
+	stats.MemoryUsage, _ = readUint(filepath.Join(memory, "memory.usage_in_bytes"))
+	if memStat, err := readKeyValues(filepath.Join(memory, "memory.stat")); err == nil {
+		stats.MemoryUsage = subtractFloor(stats.MemoryUsage, memStat["total_inactive_file"])
+	}
+	if limit, err := readUint(filepath.Join(memory, "memory.limit_in_bytes")); err == nil && limit < cgroupUnlimited {
+		stats.MemoryLimit = limit
+	}
+
+	// blkio.throttle.io_service_bytes: "8:0 Read 123" per device and operation
+	if lines, err := readLines(filepath.Join(c.root, "blkio", rel, "blkio.throttle.io_service_bytes")); err == nil {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			n, _ := strconv.ParseUint(fields[2], 10, 64)
+			switch fields[1] {
+			case "Read":
+				stats.BlockReadBytes += n
+			case "Write":
+				stats.BlockWriteBytes += n
+			}
+		}
+	}
+
+	stats.Pids, _ = readUint(filepath.Join(c.root, "pids", rel, "pids.current"))
+	return stats, nil
+}
+
+// readUint reads a file holding a single unsigned integer
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyValues reads a flat-keyed file of "key value" lines
+func readKeyValues(path string) (map[string]uint64, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64, len(lines))
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values, nil
+}
+
+// readLines reads the non-empty lines of a file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// subtractFloor returns a-b, or 0 if b exceeds a
+func subtractFloor(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
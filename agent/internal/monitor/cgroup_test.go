@@ -0,0 +1,253 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	testID1 = strings.Repeat("a1", 32)
+	testID2 = strings.Repeat("b2", 32)
+)
+
+// writeCgroupTree creates files below a temporary root, keyed by their path
+// relative to it, and returns the root
+func writeCgroupTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCgroupContainers(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []containerCgroup
+	}{
+		{
+			name: "v2 systemd driver and kubernetes",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory io pids",
+				"system.slice/docker-" + testID1 + ".scope/cgroup.procs":                           "",
+				"system.slice/docker-" + testID1 + ".scope/nested/cgroup.procs":                    "",
+				"kubepods.slice/kubepods-pod1.slice/cri-containerd-" + testID2 + ".scope/cpu.stat": "",
+				"system.slice/sshd.service/cgroup.procs":                                           "",
+			},
+			want: []containerCgroup{
+				{ID: testID2, Runtime: "containerd", Path: "kubepods.slice/kubepods-pod1.slice/cri-containerd-" + testID2 + ".scope"},
+				{ID: testID1, Runtime: "docker", Path: "system.slice/docker-" + testID1 + ".scope"},
+			},
+		},
+		{
+			name: "v2 podman",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory",
+				"machine.slice/libpod-" + testID1 + ".scope/cgroup.procs": "",
+			},
+			want: []containerCgroup{
+				{ID: testID1, Runtime: "podman", Path: "machine.slice/libpod-" + testID1 + ".scope"},
+			},
+		},
+		{
+			name: "v1 cgroupfs driver",
+			files: map[string]string{
+				"memory/docker/" + testID1 + "/memory.usage_in_bytes":  "0",
+				"cpuacct/docker/" + testID1 + "/cpuacct.usage":         "0",
+				"memory/user.slice/memory.usage_in_bytes":              "0",
+				"memory/kubepods/burstable/pod1/" + testID2 + "/tasks": "",
+			},
+			want: []containerCgroup{
+				{ID: testID1, Runtime: "docker", Path: "docker/" + testID1},
+				{ID: testID2, Runtime: "kubernetes", Path: "kubepods/burstable/pod1/" + testID2},
+			},
+		},
+		{
+			name: "no containers",
+			files: map[string]string{
+				"cgroup.controllers":                     "cpu",
+				"system.slice/sshd.service/cgroup.procs": "",
+				"abc/cgroup.procs":                       "",
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := cgroupFS{root: writeCgroupTree(t, tt.files)}
+			got, err := fs.Containers()
+			if err != nil {
+				t.Fatalf("Containers() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Containers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCgroupContainersMissingRoot(t *testing.T) {
+	fs := cgroupFS{root: filepath.Join(t.TempDir(), "missing")}
+	if _, err := fs.Containers(); err == nil {
+		t.Error("Containers() on a missing root returned no error")
+	}
+}
+
+func TestCgroupStats(t *testing.T) {
+	v2Path := "system.slice/docker-" + testID1 + ".scope"
+	v2Dir := v2Path + "/"
+	v1Path := "docker/" + testID1
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		path    string
+		want    cgroupStats
+		wantErr bool
+	}{
+		{
+			name: "v2 limited",
+			files: map[string]string{
+				"cgroup.controllers":     "cpu memory io pids",
+				v2Dir + "cpu.stat":       "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n",
+				v2Dir + "memory.current": "10000\n",
+				v2Dir + "memory.stat":    "anon 6000\ninactive_file 4000\nactive_file 0\n",
+				v2Dir + "memory.max":     "524288\n",
+				v2Dir + "io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2\n8:16 rbytes=1 wbytes=2 rios=1 wios=1\n",
+				v2Dir + "pids.current":   "7\n",
+			},
+			path: v2Path,
+			want: cgroupStats{
+				CPUUsageNanos:   1500000,
+				MemoryUsage:     6000,
+				MemoryLimit:     524288,
+				BlockReadBytes:  101,
+				BlockWriteBytes: 202,
+				Pids:            7,
+			},
+		},
+		{
+			name: "v2 unlimited, inactive cache above usage",
+			files: map[string]string{
+				"cgroup.controllers":     "cpu memory",
+				v2Dir + "cpu.stat":       "usage_usec 1\n",
+				v2Dir + "memory.current": "100\n",
+				v2Dir + "memory.stat":    "inactive_file 200\n",
+				v2Dir + "memory.max":     "max\n",
+			},
+			path: v2Path,
+			want: cgroupStats{CPUUsageNanos: 1000},
+		},
+		{
+			name: "v2 without cpu.stat",
+			files: map[string]string{
+				"cgroup.controllers":     "memory",
+				v2Dir + "memory.current": "100\n",
+			},
+			path:    v2Path,
+			wantErr: true,
+		},
+		{
+			name: "v1 limited",
+			files: map[string]string{
+				"cpuacct/" + v1Path + "/cpuacct.usage":                 "123456789\n",
+				"memory/" + v1Path + "/memory.usage_in_bytes":          "50000\n",
+				"memory/" + v1Path + "/memory.stat":                    "cache 30000\ntotal_inactive_file 20000\n",
+				"memory/" + v1Path + "/memory.limit_in_bytes":          "1048576\n",
+				"blkio/" + v1Path + "/blkio.throttle.io_service_bytes": "8:0 Read 300\n8:0 Write 400\n8:0 Sync 700\n8:0 Total 700\nTotal 700\n",
+				"pids/" + v1Path + "/pids.current":                     "3\n",
+			},
+			path: v1Path,
+			want: cgroupStats{
+				CPUUsageNanos:   123456789,
+				MemoryUsage:     30000,
+				MemoryLimit:     1048576,
+				BlockReadBytes:  300,
+				BlockWriteBytes: 400,
+				Pids:            3,
+			},
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"cpuacct/" + v1Path + "/cpuacct.usage":        "5\n",
+				"memory/" + v1Path + "/memory.usage_in_bytes": "50000\n",
+				"memory/" + v1Path + "/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			path: v1Path,
+			want: cgroupStats{CPUUsageNanos: 5, MemoryUsage: 50000},
+		},
+		{
+			name: "v1 without cpuacct",
+			files: map[string]string{
+				"memory/" + v1Path + "/memory.usage_in_bytes": "50000\n",
+			},
+			path:    v1Path,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := cgroupFS{root: writeCgroupTree(t, tt.files)}
+			got, err := fs.Stats(containerCgroup{ID: testID1, Path: tt.path})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Stats() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCgroupProcs(t *testing.T) {
+	dir := "system.slice/docker-" + testID1 + ".scope"
+	fs := cgroupFS{root: writeCgroupTree(t, map[string]string{
+		"cgroup.controllers":  "cpu",
+		dir + "/cgroup.procs": "12\n34\nbogus\n56\n",
+	})}
+
+	got := fs.Procs(containerCgroup{ID: testID1, Path: dir})
+	if want := []int{12, 34, 56}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Procs() = %v, want %v", got, want)
+	}
+	if got := fs.Procs(containerCgroup{ID: testID2, Path: "missing"}); got != nil {
+		t.Errorf("Procs() of a missing cgroup = %v, want nil", got)
+	}
+}
+
+func TestCgroupRuntime(t *testing.T) {
+	tests := []struct {
+		prefix string
+		rel    string
+		want   string
+	}{
+		{"docker", "system.slice/docker-x.scope", "docker"},
+		{"libpod", "machine.slice/libpod-x.scope", "podman"},
+		{"cri-containerd", "kubepods.slice/cri-containerd-x.scope", "containerd"},
+		{"crio", "kubepods.slice/crio-x.scope", "cri-o"},
+		{"", "kubepods/burstable/pod1/x", "kubernetes"},
+		{"", "docker/x", "docker"},
+		{"", "machine.slice/x", "podman"},
+		{"", "lxc/x", "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := cgroupRuntime(tt.prefix, tt.rel); got != tt.want {
+			t.Errorf("cgroupRuntime(%q, %q) = %q, want %q", tt.prefix, tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// maxCheckBody bounds how much of an HTTP response is searched for the expected body
+const maxCheckBody = 1 << 20
+
+// collectChecks runs every synthetic check concurrently. A failing check is a
+// result, not an error.
+func (m *SystemMonitor) collectChecks(checks []config.CheckConfig) []models.CheckResult {
+	results := make([]models.CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check config.CheckConfig) {
+			defer wg.Done()
+			results[i] = runCheck(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runCheck runs a single check and times it
+func runCheck(check config.CheckConfig) models.CheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), check.TimeoutDuration())
+	defer cancel()
+
+	result := models.CheckResult{
+		Name:   check.Name,
+		Type:   check.Type,
+		Target: check.Target,
+	}
+
+	start := time.Now()
+	var rtt time.Duration
+	var err error
+	switch check.Type {
+	case "tcp":
+		err = checkTCP(ctx, check)
+	case "http":
+		err = checkHTTP(ctx, check, &result)
+	case "dns":
+		err = checkDNS(ctx, check, &result)
+	case "icmp":
+		// The echo round trip, excluding name resolution and socket setup
+		rtt, err = ping(ctx, check.Target)
+	default:
+		err = fmt.Errorf("unknown check type %q", check.Type)
+	}
+	if rtt == 0 {
+		rtt = time.Since(start)
+	}
+	result.LatencyMs = float64(rtt.Microseconds()) / 1000
+
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+func checkTCP(ctx context.Context, check config.CheckConfig) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", check.Target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func checkHTTP(ctx context.Context, check config.CheckConfig, result *models.CheckResult) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: check.SkipVerify},
+			DisableKeepAlives: true,
+		},
+		// Report redirects as they are rather than following them
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.Target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "NinjaIT-Agent")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		expires := resp.TLS.PeerCertificates[0].NotAfter
+		result.CertExpiresAt = &expires
+	}
+
+	if check.ExpectedStatus != 0 {
+		if resp.StatusCode != check.ExpectedStatus {
+			return fmt.Errorf("status %d, expected %d", resp.StatusCode, check.ExpectedStatus)
+		}
+	} else if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	if check.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		if !strings.Contains(string(body), check.ExpectedBody) {
+			return fmt.Errorf("response body does not contain %q", check.ExpectedBody)
+		}
+	}
+
+	if check.MinCertDays > 0 && result.CertExpiresAt != nil {
+		if left := time.Until(*result.CertExpiresAt); left < time.Duration(check.MinCertDays)*24*time.Hour {
+			return fmt.Errorf("certificate expires in %d days, expected at least %d", int(left.Hours()/24), check.MinCertDays)
+		}
+	}
+	return nil
+}
+
+func checkDNS(ctx context.Context, check config.CheckConfig, result *models.CheckResult) error {
+	resolver := net.DefaultResolver
+	if check.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, check.Resolver)
+			},
+		}
+	}
+
+	addrs, err := resolver.LookupHost(ctx, check.Target)
+	if err != nil {
+		return err
+	}
+	result.Addresses = addrs
+	return nil
+}
@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// cpuSample is a container's cumulative CPU time at a point in time, kept to
+// turn the next reading into a percentage
+type cpuSample struct {
+	usageNanos uint64
+	at         time.Time
+}
+
+// collectContainers reports every container found in the cgroup tree,
+// enriched with names, images and restart counts from Docker when configured
+func (m *SystemMonitor) collectContainers(cfg config.ContainersConfig) ([]models.ContainerMetrics, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("container monitoring is only supported on Linux")
+	}
+
+	cgroups := cgroupFS{root: cfg.CgroupRoot}
+	found, err := cgroups.Containers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list container cgroups: %w", err)
+	}
+
+	var docker map[string]dockerContainer
+	if cfg.DockerSocket != "" {
+		docker, err = newDockerClient(cfg.DockerSocket).Containers()
+		if err != nil {
+			// cgroup stats are still useful without names
+			log.WithError(err).Warn("Failed to query Docker, reporting containers by ID")
+		}
+	}
+
+	now := time.Now()
+	m.mu.RLock()
+	previous := m.containerCPU
+	m.mu.RUnlock()
+	samples := make(map[string]cpuSample, len(found))
+
+	containers := make([]models.ContainerMetrics, 0, len(found))
+	for _, ct := range found {
+		stats, err := cgroups.Stats(ct)
+		if err != nil {
+			// The container exited while collecting
+			log.WithError(err).WithField("container", ct.ID[:12]).Debug("Failed to read container cgroup")
+			continue
+		}
+
+		container := models.ContainerMetrics{
+			ID:              ct.ID,
+			Name:            ct.ID[:12],
+			Runtime:         ct.Runtime,
+			CPUSeconds:      float64(stats.CPUUsageNanos) / 1e9,
+			MemoryUsage:     stats.MemoryUsage,
+			MemoryLimit:     stats.MemoryLimit,
+			BlockReadBytes:  stats.BlockReadBytes,
+			BlockWriteBytes: stats.BlockWriteBytes,
+			Pids:            stats.Pids,
+		}
+
+		samples[ct.ID] = cpuSample{usageNanos: stats.CPUUsageNanos, at: now}
+		if prev, ok := previous[ct.ID]; ok && stats.CPUUsageNanos >= prev.usageNanos {
+			if elapsed := now.Sub(prev.at); elapsed > 0 {
+				container.CPUPercent = float64(stats.CPUUsageNanos-prev.usageNanos) / float64(elapsed.Nanoseconds()) * 100
+			}
+		}
+
+		if pids := cgroups.Procs(ct); len(pids) > 0 {
+			container.NetworkRxBytes, container.NetworkTxBytes = containerNetwork(pids[0])
+		}
+
+		if d, ok := docker[ct.ID]; ok {
+			container.Name = d.Name
+			container.Image = d.Image
+			container.State = d.State
+			container.RestartCount = d.RestartCount
+			if !d.StartedAt.IsZero() {
+				startedAt := d.StartedAt
+				container.StartedAt = &startedAt
+			}
+		}
+
+		containers = append(containers, container)
+	}
+
+	// Replacing the samples drops containers that have gone away
+	m.mu.Lock()
+	m.containerCPU = samples
+	m.mu.Unlock()
+
+	return containers, nil
+}
+
+// containerNetwork sums the traffic of all interfaces except loopback in the
+// network namespace of pid. Containers sharing the host's network namespace
+// report nothing, since their traffic is the host's. HOST_PROC overrides
+// /proc as it does for gopsutil.
+func containerNetwork(pid int) (rx, tx uint64) {
+	proc := os.Getenv("HOST_PROC")
+	if proc == "" {
+		proc = "/proc"
+	}
+
+	ns, err := os.Readlink(filepath.Join(proc, strconv.Itoa(pid), "ns/net"))
+	if err != nil {
+		return 0, 0
+	}
+	if hostNS, err := os.Readlink(filepath.Join(proc, "1/ns/net")); err == nil && hostNS == ns {
+		return 0, 0
+	}
+
+	lines, err := readLines(filepath.Join(proc, strconv.Itoa(pid), "net/dev"))
+	if err != nil {
+		return 0, 0
+	}
+	// "iface: rx_bytes rx_packets ... (8 receive fields) tx_bytes ..."
+	for _, line := range lines {
+		iface, counters, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return rx, tx
+}
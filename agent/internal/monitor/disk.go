@@ -0,0 +1,139 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// virtualFSTypes are filesystems without storage of their own, which are
+// never reported. autofs is included because reading its usage would trigger
+// the mount; the mounted filesystem is listed separately.
+var virtualFSTypes = map[string]bool{
+	"autofs":          true,
+	"binfmt_misc":     true,
+	"bpf":             true,
+	"cgroup":          true,
+	"cgroup2":         true,
+	"configfs":        true,
+	"debugfs":         true,
+	"devfs":           true,
+	"devpts":          true,
+	"efivarfs":        true,
+	"fdescfs":         true,
+	"fuse.gvfsd-fuse": true,
+	"fuse.lxcfs":      true,
+	"fuse.portal":     true,
+	"fusectl":         true,
+	"hugetlbfs":       true,
+	"mqueue":          true,
+	"none":            true,
+	"nfsd":            true,
+	"nsfs":            true,
+	"proc":            true,
+	"pstore":          true,
+	"ramfs":           true,
+	"rpc_pipefs":      true,
+	"securityfs":      true,
+	"selinuxfs":       true,
+	"sysfs":           true,
+	"tracefs":         true,
+}
+
+// diskUsage is the outcome of reading the usage of one mount
+type diskUsage struct {
+	usage *disk.UsageStat
+	err   error
+}
+
+// collectDisk reports the usage of every mounted filesystem that passes the
+// configured rules, network filesystems included. Usage is read for all
+// mounts at once, each with the configured timeout; a mount that does not
+// answer in time, such as a stale NFS or CIFS mount, is skipped until its
+// pending read returns.
+func (m *SystemMonitor) collectDisk(cfg config.DiskConfig) ([]models.DiskMetrics, error) {
+	partitions, err := disk.Partitions(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// A mountpoint mounted over is listed again; only the last mount is visible
+	last := make(map[string]int, len(partitions))
+	for i, partition := range partitions {
+		last[partition.Mountpoint] = i
+	}
+
+	var selected []disk.PartitionStat
+	for i, partition := range partitions {
+		if last[partition.Mountpoint] != i || virtualFSTypes[partition.Fstype] {
+			continue
+		}
+		if !cfg.Reports(partition.Device, partition.Mountpoint, partition.Fstype) {
+			continue
+		}
+		selected = append(selected, partition)
+	}
+
+	deadline := time.Now().Add(cfg.TimeoutDuration())
+	results := make([]chan diskUsage, len(selected))
+	for i, partition := range selected {
+		key := "disk " + partition.Mountpoint
+		if !m.startRun(key) {
+			log.WithField("mountpoint", partition.Mountpoint).Debug("Skipping mount, its previous usage read has not returned")
+			continue
+		}
+		done := make(chan diskUsage, 1)
+		results[i] = done
+		go func(mountpoint string) {
+			defer m.finishRun(key)
+			usage, err := disk.Usage(mountpoint)
+			done <- diskUsage{usage, err}
+		}(partition.Mountpoint)
+	}
+
+	var disks []models.DiskMetrics
+	for i, partition := range selected {
+		if results[i] == nil {
+			continue
+		}
+
+		var result diskUsage
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case result = <-results[i]:
+			timer.Stop()
+		case <-timer.C:
+			log.WithField("mountpoint", partition.Mountpoint).Warn("Disk usage timed out, skipping mount until it responds")
+			continue
+		}
+
+		if result.err != nil {
+			log.WithError(result.err).WithField("mountpoint", partition.Mountpoint).Warn("Failed to get disk usage")
+			continue
+		}
+		usage := result.usage
+		if usage.Total == 0 {
+			// Pseudo filesystems not known to be virtual report no size
+			continue
+		}
+
+		disks = append(disks, models.DiskMetrics{
+			Device:            partition.Device,
+			Mountpoint:        partition.Mountpoint,
+			FsType:            partition.Fstype,
+			Total:             usage.Total,
+			Used:              usage.Used,
+			Free:              usage.Free,
+			UsedPercent:       usage.UsedPercent,
+			InodesTotal:       usage.InodesTotal,
+			InodesUsed:        usage.InodesUsed,
+			InodesFree:        usage.InodesFree,
+			InodesUsedPercent: usage.InodesUsedPercent,
+		})
+	}
+
+	return disks, nil
+}
@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dockerTimeout bounds the Docker API calls of one collection
+const dockerTimeout = 10 * time.Second
+
+// dockerContainer is what the Docker API adds to a container's cgroup stats
+type dockerContainer struct {
+	Name         string
+	Image        string
+	State        string
+	RestartCount int
+	StartedAt    time.Time
+}
+
+// dockerClient queries the Docker Engine API over its Unix socket
+type dockerClient struct {
+	socket string
+	client *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{
+		socket: socket,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Containers returns the running containers keyed by full ID
+func (d *dockerClient) Containers() (map[string]dockerContainer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	var list []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+		State string   `json:"State"`
+	}
+	if err := d.get(ctx, "/containers/json", &list); err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string]dockerContainer, len(list))
+	for _, c := range list {
+		container := dockerContainer{
+			Image: c.Image,
+			State: c.State,
+		}
+		if len(c.Names) > 0 {
+			container.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		// Restart counts are only available by inspecting each container
+		var inspect struct {
+			RestartCount int `json:"RestartCount"`
+			State        struct {
+				StartedAt time.Time `json:"StartedAt"`
+			} `json:"State"`
+		}
+		if err := d.get(ctx, "/containers/"+c.ID+"/json", &inspect); err == nil {
+			container.RestartCount = inspect.RestartCount
+			container.StartedAt = inspect.State.StartedAt
+		}
+
+		containers[c.ID] = container
+	}
+	return containers, nil
+}
+
+func (d *dockerClient) get(ctx context.Context, path string, out interface{}) error {
+	// The host is ignored when dialing a Unix socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker API %s: %w", d.socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker API %s returned status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -1,131 +1,420 @@
 package monitor
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
+	log "github.com/sirupsen/logrus"
 	"github.com/yossibmoha/NinjaIT/agent/internal/api"
 	"github.com/yossibmoha/NinjaIT/agent/internal/config"
 	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
-	log "github.com/sirupsen/logrus"
 )
 
 // SystemMonitor collects system metrics
 type SystemMonitor struct {
 	config    *config.Config
 	apiClient *api.Client
+
+	mu         sync.RWMutex
+	latest     *models.SystemMetrics
+	collectors map[string]CollectorStatus
+
+	// running holds the collectors whose last run has not returned yet
+	running map[string]bool
+	// cpuTimes holds the CPU times read by the previous CPU collection
+	cpuTimes *cpuTimes
+	// samples holds the values sampled since the previous collection, and
+	// sampleCPU and sampleNet the counters read by the previous sample
+	samples   sampleWindow
+	sampleCPU *cpu.TimesStat
+	sampleNet *netSample
+	// containerCPU holds the previous CPU reading of each container
+	containerCPU map[string]cpuSample
+	// scriptRuns holds when each check script last ran
+	scriptRuns map[string]time.Time
+	// breached holds the values over their threshold at the last collection,
+	// and events receives the threshold crossings
+	breached map[string]bool
+	events   func(models.LogEvent)
+}
+
+// CollectorStatus describes the most recent runs of a collector
+type CollectorStatus struct {
+	LastRun     time.Time `json:"last_run"`
+	DurationMs  float64   `json:"duration_ms"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+	Errors      uint64    `json:"errors"`
 }
 
 // NewSystemMonitor creates a new system monitor
 func NewSystemMonitor(cfg *config.Config, client *api.Client) *SystemMonitor {
 	return &SystemMonitor{
-		config:    cfg,
-		apiClient: client,
+		config:     cfg,
+		apiClient:  client,
+		collectors: make(map[string]CollectorStatus),
+		running:    make(map[string]bool),
 	}
 }
 
-// CollectAndSend collects system metrics and sends them to the server
+// SetEventSink sets where events for threshold crossings are sent
+func (m *SystemMonitor) SetEventSink(sink func(models.LogEvent)) {
+	m.mu.Lock()
+	m.events = sink
+	m.mu.Unlock()
+}
+
+// CollectAndSend collects system metrics, raises events for thresholds they
+// crossed and sends them to the server
 func (m *SystemMonitor) CollectAndSend() error {
-	log.Debug("Collecting system metrics")
+	metrics := m.Collect()
+
+	m.mu.RLock()
+	thresholds, sink := m.config.Thresholds, m.events
+	m.mu.RUnlock()
+	if sink != nil {
+		for _, event := range m.checkThresholds(thresholds, metrics) {
+			log.WithField("pattern", event.Pattern).Warn(event.Message)
+			sink(event)
+		}
+	}
 
-	metrics := &models.SystemMetrics{
-		Timestamp: time.Now(),
-		DeviceID:  m.config.Agent.DeviceID,
-		Hostname:  m.config.Agent.Hostname,
+	// Send metrics to server
+	if err := m.apiClient.SendMetrics(metrics); err != nil {
+		return fmt.Errorf("failed to send metrics: %w", err)
 	}
 
-	// Collect CPU metrics
-	if m.config.Agent.EnableCPU {
-		if cpuMetrics, err := m.collectCPU(); err != nil {
-			log.WithError(err).Warn("Failed to collect CPU metrics")
-		} else {
-			metrics.CPU = cpuMetrics
-		}
+	// CPU and memory are missing when their collectors failed or timed out
+	fields := log.Fields{"disk_count": len(metrics.Disks)}
+	if metrics.CPU != nil {
+		fields["cpu_usage"] = fmt.Sprintf("%.2f%%", metrics.CPU.UsagePercent)
+	}
+	if metrics.Memory != nil {
+		fields["memory_usage"] = fmt.Sprintf("%.2f%%", metrics.Memory.UsedPercent)
 	}
+	log.WithFields(fields).Debug("Metrics sent successfully")
 
-	// Collect memory metrics
-	if m.config.Agent.EnableMemory {
-		if memMetrics, err := m.collectMemory(); err != nil {
-			log.WithError(err).Warn("Failed to collect memory metrics")
-		} else {
-			metrics.Memory = memMetrics
-		}
+	return nil
+}
+
+// UpdateConfig applies a reloaded configuration to subsequent collections
+func (m *SystemMonitor) UpdateConfig(cfg *config.Config) {
+	m.mu.Lock()
+	m.config = cfg
+	m.mu.Unlock()
+}
+
+// Latest returns the most recently collected metrics, or nil before the first collection
+func (m *SystemMonitor) Latest() *models.SystemMetrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.latest
+}
+
+// CollectorStatuses returns the timing and last error of each collector that has run
+func (m *SystemMonitor) CollectorStatuses() map[string]CollectorStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make(map[string]CollectorStatus, len(m.collectors))
+	for name, status := range m.collectors {
+		statuses[name] = status
+	}
+	return statuses
+}
+
+// record stores the timing and outcome of a collector run
+func (m *SystemMonitor) record(name string, start time.Time, took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.collectors[name]
+	status.LastRun = start
+	status.DurationMs = float64(took.Microseconds()) / 1000
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = time.Now()
+		status.Errors++
 	}
+	m.collectors[name] = status
+}
 
-	// Collect disk metrics
-	if m.config.Agent.EnableDisk {
-		if diskMetrics, err := m.collectDisk(); err != nil {
-			log.WithError(err).Warn("Failed to collect disk metrics")
-		} else {
-			metrics.Disks = diskMetrics
+// cpuPrimeInterval is how long the first CPU collection waits between its two
+// readings; later collections compare with the previous collection instead
+const cpuPrimeInterval = 250 * time.Millisecond
+
+// errStillRunning is recorded for a collector whose previous run has not
+// returned yet, such as a disk collection stuck on an unresponsive mount
+var errStillRunning = errors.New("previous run has not finished")
+
+// collectorRun is one collector of a collection cycle. collect returns a
+// function that stores its result, so a run that outlives its timeout never
+// touches metrics already sent.
+type collectorRun struct {
+	name    string
+	warning string        // logged when the collector fails
+	timeout time.Duration // 0 leaves only the cycle budget
+	collect func() (func(*models.SystemMetrics), error)
+}
+
+// into adapts a collector function to a collectorRun, storing its result with set
+func into[T any](collect func() (T, error), set func(*models.SystemMetrics, T)) func() (func(*models.SystemMetrics), error) {
+	return func() (func(*models.SystemMetrics), error) {
+		value, err := collect()
+		if err != nil {
+			return nil, err
 		}
+		return func(metrics *models.SystemMetrics) { set(metrics, value) }, nil
 	}
+}
+
+// collectorRuns lists the collectors enabled in cfg
+func (m *SystemMonitor) collectorRuns(cfg *config.Config) []collectorRun {
+	timeout := cfg.Agent.CollectorTimeoutDuration()
+	var runs []collectorRun
 
-	// Collect network metrics
-	if m.config.Agent.EnableNetwork {
-		if netMetrics, err := m.collectNetwork(); err != nil {
-			log.WithError(err).Warn("Failed to collect network metrics")
-		} else {
-			metrics.Network = netMetrics
+	if cfg.Agent.EnableCPU {
+		runs = append(runs, collectorRun{"cpu", "Failed to collect CPU metrics", timeout,
+			into(m.collectCPU, func(s *models.SystemMetrics, v *models.CPUMetrics) { s.CPU = v })})
+	}
+	if cfg.Agent.EnableMemory {
+		runs = append(runs, collectorRun{"memory", "Failed to collect memory metrics", timeout,
+			into(m.collectMemory, func(s *models.SystemMetrics, v *models.MemoryMetrics) { s.Memory = v })})
+	}
+	if cfg.Agent.EnableDisk {
+		runs = append(runs, collectorRun{"disk", "Failed to collect disk metrics", timeout,
+			into(func() ([]models.DiskMetrics, error) { return m.collectDisk(cfg.Disk) }, func(s *models.SystemMetrics, v []models.DiskMetrics) { s.Disks = v })})
+	}
+	if cfg.Agent.EnableNetwork {
+		runs = append(runs, collectorRun{"network", "Failed to collect network metrics", timeout,
+			into(m.collectNetwork, func(s *models.SystemMetrics, v *models.NetworkMetrics) { s.Network = v })})
+	}
+	if cfg.Agent.EnableSystemd {
+		collect := func() ([]models.ServiceStatus, error) { return m.collectSystemd(cfg.Systemd) }
+		runs = append(runs, collectorRun{"systemd", "Failed to collect systemd unit states", timeout,
+			into(collect, func(s *models.SystemMetrics, v []models.ServiceStatus) { s.Services = v })})
+	}
+	if cfg.Agent.EnableSensors {
+		runs = append(runs, collectorRun{"sensors", "Failed to collect sensor readings", timeout,
+			into(m.collectSensors, func(s *models.SystemMetrics, v *models.SensorMetrics) { s.Sensors = v })})
+	}
+	if cfg.Agent.EnableContainers {
+		collect := func() ([]models.ContainerMetrics, error) { return m.collectContainers(cfg.Containers) }
+		runs = append(runs, collectorRun{"containers", "Failed to collect container metrics", timeout,
+			into(collect, func(s *models.SystemMetrics, v []models.ContainerMetrics) { s.Containers = v })})
+	}
+
+	// Checks and scripts enforce their own configured timeouts, so only the
+	// cycle budget applies to them
+	if cfg.Agent.EnableChecks && len(cfg.Checks) > 0 {
+		collect := func() ([]models.CheckResult, error) { return m.collectChecks(cfg.Checks), nil }
+		runs = append(runs, collectorRun{"checks", "Failed to run checks", 0,
+			into(collect, func(s *models.SystemMetrics, v []models.CheckResult) { s.Checks = v })})
+	}
+	if cfg.Agent.EnableScripts && len(cfg.Scripts) > 0 {
+		collect := func() ([]models.ScriptResult, error) {
+			return m.collectScripts(cfg.Scripts, cfg.Agent.CheckInterval), nil
 		}
+		runs = append(runs, collectorRun{"scripts", "Failed to run check scripts", 0,
+			into(collect, func(s *models.SystemMetrics, v []models.ScriptResult) { s.Scripts = v })})
 	}
 
-	// Collect system info
-	if sysInfo, err := m.collectSystemInfo(); err != nil {
-		log.WithError(err).Warn("Failed to collect system info")
-	} else {
-		metrics.System = sysInfo
+	runs = append(runs, collectorRun{"system", "Failed to collect system info", timeout,
+		into(m.collectSystemInfo, func(s *models.SystemMetrics, v *models.SystemInfo) { s.System = v })})
+
+	return runs
+}
+
+// Collect runs all enabled collectors concurrently and records the result as
+// the latest snapshot. Each collector has until its timeout, and all of them
+// until the cycle budget, to return; later results are dropped, and a
+// collector is not started again until its previous run returns.
+func (m *SystemMonitor) Collect() *models.SystemMetrics {
+	log.Debug("Collecting system metrics")
+
+	m.mu.RLock()
+	cfg := m.config
+	m.mu.RUnlock()
+
+	start := time.Now()
+	metrics := &models.SystemMetrics{
+		Timestamp: start,
+		DeviceID:  cfg.Agent.DeviceID,
+		Hostname:  cfg.Agent.Hostname,
 	}
 
-	// Send metrics to server
-	if err := m.apiClient.SendMetrics(metrics); err != nil {
-		return fmt.Errorf("failed to send metrics: %w", err)
+	type outcome struct {
+		apply func(*models.SystemMetrics)
+		err   error
+		took  time.Duration
 	}
 
-	log.WithFields(log.Fields{
-		"cpu_usage":    fmt.Sprintf("%.2f%%", metrics.CPU.UsagePercent),
-		"memory_usage": fmt.Sprintf("%.2f%%", metrics.Memory.UsedPercent),
-		"disk_count":   len(metrics.Disks),
-	}).Debug("Metrics sent successfully")
+	runs := m.collectorRuns(cfg)
+	outcomes := make([]chan outcome, len(runs))
+	for i, run := range runs {
+		if !m.startRun(run.name) {
+			m.record(run.name, start, 0, errStillRunning)
+			log.WithField("collector", run.name).Warn("Skipping collector, its previous run has not finished")
+			continue
+		}
+		done := make(chan outcome, 1)
+		outcomes[i] = done
+		go func(run collectorRun) {
+			defer m.finishRun(run.name)
+			apply, err := run.collect()
+			done <- outcome{apply, err, time.Since(start)}
+		}(run)
+	}
 
-	return nil
+	budget := start.Add(cfg.Agent.CollectionBudgetDuration())
+	for i, run := range runs {
+		if outcomes[i] == nil {
+			continue
+		}
+		deadline := budget
+		if run.timeout > 0 && start.Add(run.timeout).Before(deadline) {
+			deadline = start.Add(run.timeout)
+		}
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case result := <-outcomes[i]:
+			timer.Stop()
+			m.record(run.name, start, result.took, result.err)
+			if result.err != nil {
+				log.WithError(result.err).Warn(run.warning)
+			} else {
+				result.apply(metrics)
+			}
+		case <-timer.C:
+			err := fmt.Errorf("timed out after %s", deadline.Sub(start).Round(time.Millisecond))
+			m.record(run.name, start, deadline.Sub(start), err)
+			log.WithError(err).Warn(run.warning)
+		}
+	}
+
+	m.summarizeSamples(metrics)
+
+	m.mu.Lock()
+	m.latest = metrics
+	m.mu.Unlock()
+
+	return metrics
 }
 
-// collectCPU collects CPU metrics
+// startRun marks a collector as running, reporting false if it already is
+func (m *SystemMonitor) startRun(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running[name] {
+		return false
+	}
+	m.running[name] = true
+	return true
+}
+
+// finishRun marks a collector as no longer running
+func (m *SystemMonitor) finishRun(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, name)
+}
+
+// collectCPU collects CPU usage over the time since the previous collection,
+// from the cumulative CPU times, so it never waits for a sampling interval
 func (m *SystemMonitor) collectCPU() (*models.CPUMetrics, error) {
-	// Get CPU usage percentage
-	percentages, err := cpu.Percent(time.Second, false)
+	current, err := readCPUTimes()
 	if err != nil {
 		return nil, err
 	}
 
+	m.mu.Lock()
+	previous := m.cpuTimes
+	m.cpuTimes = current
+	m.mu.Unlock()
+
+	if previous == nil {
+		// Nothing to compare with yet: take a short sample
+		time.Sleep(cpuPrimeInterval)
+		previous = current
+		if current, err = readCPUTimes(); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.cpuTimes = current
+		m.mu.Unlock()
+	}
+
 	// Get CPU core count
 	cores, err := cpu.Counts(true)
 	if err != nil {
 		cores = runtime.NumCPU()
 	}
 
-	var usage float64
-	if len(percentages) > 0 {
-		usage = percentages[0]
+	var perCoreUsage []float64
+	if len(current.perCore) == len(previous.perCore) {
+		perCoreUsage = make([]float64, len(current.perCore))
+		for i := range current.perCore {
+			perCoreUsage[i] = busyPercent(previous.perCore[i], current.perCore[i])
+		}
 	}
 
-	// Get per-core usage
-	perCoreUsage, _ := cpu.Percent(time.Second, true)
-
 	return &models.CPUMetrics{
-		UsagePercent: usage,
+		UsagePercent: busyPercent(previous.total, current.total),
 		Cores:        cores,
 		PerCore:      perCoreUsage,
 	}, nil
 }
 
+// cpuTimes is a reading of the cumulative CPU times, overall and per core
+type cpuTimes struct {
+	total   cpu.TimesStat
+	perCore []cpu.TimesStat
+}
+
+// readCPUTimes reads the cumulative CPU times
+func readCPUTimes() (*cpuTimes, error) {
+	total, err := cpu.Times(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(total) == 0 {
+		return nil, fmt.Errorf("no CPU times reported")
+	}
+
+	// Per-core times are optional, as they were with sampled percentages
+	perCore, _ := cpu.Times(true)
+
+	return &cpuTimes{total: total[0], perCore: perCore}, nil
+}
+
+// busyPercent returns the share of time the CPU was busy between two readings
+func busyPercent(before, after cpu.TimesStat) float64 {
+	busyBefore, totalBefore := cpuBusy(before)
+	busyAfter, totalAfter := cpuBusy(after)
+	if totalAfter <= totalBefore || busyAfter < busyBefore {
+		// No time passed, or the counters were reset
+		return 0
+	}
+	return math.Min(100, (busyAfter-busyBefore)/(totalAfter-totalBefore)*100)
+}
+
+// cpuBusy returns the busy and total time of a reading. Guest time is already
+// counted in user time, and time waiting for I/O is idle.
+func cpuBusy(t cpu.TimesStat) (busy, total float64) {
+	total = t.User + t.System + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal + t.Idle
+	return total - t.Idle - t.Iowait, total
+}
+
 // collectMemory collects memory metrics
 func (m *SystemMonitor) collectMemory() (*models.MemoryMetrics, error) {
 	vmStat, err := mem.VirtualMemory()
@@ -150,35 +439,6 @@ func (m *SystemMonitor) collectMemory() (*models.MemoryMetrics, error) {
 	}, nil
 }
 
-// collectDisk collects disk metrics
-func (m *SystemMonitor) collectDisk() ([]models.DiskMetrics, error) {
-	partitions, err := disk.Partitions(false)
-	if err != nil {
-		return nil, err
-	}
-
-	var disks []models.DiskMetrics
-	for _, partition := range partitions {
-		usage, err := disk.Usage(partition.Mountpoint)
-		if err != nil {
-			log.WithError(err).WithField("mountpoint", partition.Mountpoint).Warn("Failed to get disk usage")
-			continue
-		}
-
-		disks = append(disks, models.DiskMetrics{
-			Device:      partition.Device,
-			Mountpoint:  partition.Mountpoint,
-			FsType:      partition.Fstype,
-			Total:       usage.Total,
-			Used:        usage.Used,
-			Free:        usage.Free,
-			UsedPercent: usage.UsedPercent,
-		})
-	}
-
-	return disks, nil
-}
-
 // collectNetwork collects network metrics
 func (m *SystemMonitor) collectNetwork() (*models.NetworkMetrics, error) {
 	counters, err := net.IOCounters(false)
@@ -226,4 +486,3 @@ func (m *SystemMonitor) collectSystemInfo() (*models.SystemInfo, error) {
 		NumProcs:        runtime.NumCPU(),
 	}, nil
 }
-
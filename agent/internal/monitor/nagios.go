@@ -0,0 +1,124 @@
+package monitor
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// Nagios plugin states by exit code
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosState maps a plugin exit code to its state; anything out of range is UNKNOWN
+func nagiosState(code int) string {
+	if code < 0 || code >= len(nagiosStates) {
+		return "UNKNOWN"
+	}
+	return nagiosStates[code]
+}
+
+// parseNagiosOutput splits plugin output into the first line of text and the
+// performance data. Per the plugin guidelines perfdata follows a '|' on the
+// first line, and in long output it follows the first '|' and continues on
+// every line after it.
+func parseNagiosOutput(output string) (string, []models.PerfValue) {
+	lines := strings.Split(strings.TrimRight(output, "\r\n"), "\n")
+
+	text, perf, _ := strings.Cut(lines[0], "|")
+	perfdata := parsePerfdata(perf)
+	inPerfdata := false
+	for _, line := range lines[1:] {
+		if !inPerfdata {
+			if _, line, inPerfdata = strings.Cut(line, "|"); !inPerfdata {
+				continue
+			}
+		}
+		perfdata = append(perfdata, parsePerfdata(line)...)
+	}
+	return strings.TrimSpace(text), perfdata
+}
+
+// parsePerfdata parses "'label'=value[UOM];[warn];[crit];[min];[max]" items
+// separated by spaces. Malformed items and undetermined values ("U") are
+// skipped.
+func parsePerfdata(perf string) []models.PerfValue {
+	var values []models.PerfValue
+	for _, item := range splitPerfdata(perf) {
+		label, data, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		label = strings.ReplaceAll(strings.Trim(label, "'"), "''", "'")
+		if label == "" {
+			continue
+		}
+
+		parts := strings.Split(data, ";")
+		number, unit := splitUnit(parts[0])
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			continue
+		}
+
+		pv := models.PerfValue{Label: label, Value: value, Unit: unit}
+		if len(parts) > 1 {
+			pv.Warn = parts[1]
+		}
+		if len(parts) > 2 {
+			pv.Crit = parts[2]
+		}
+		if len(parts) > 3 {
+			pv.Min = parseOptionalFloat(parts[3])
+		}
+		if len(parts) > 4 {
+			pv.Max = parseOptionalFloat(parts[4])
+		}
+		values = append(values, pv)
+	}
+	return values
+}
+
+// splitPerfdata splits on whitespace outside single-quoted labels
+func splitPerfdata(perf string) []string {
+	var items []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range perf {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\r') && !quoted:
+			if current.Len() > 0 {
+				items = append(items, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		items = append(items, current.String())
+	}
+	return items
+}
+
+// splitUnit separates a value such as "12.5ms" into its number and unit
+func splitUnit(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func parseOptionalFloat(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
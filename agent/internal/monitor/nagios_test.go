@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestParseNagiosOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		wantText string
+		wantPerf []models.PerfValue
+	}{
+		{
+			name:     "text only",
+			output:   "OK - all good\n",
+			wantText: "OK - all good",
+		},
+		{
+			name:     "full perfdata",
+			output:   "DISK OK | /=2643MB;5948;5958;0;5968",
+			wantText: "DISK OK",
+			wantPerf: []models.PerfValue{
+				{Label: "/", Value: 2643, Unit: "MB", Warn: "5948", Crit: "5958", Min: float(0), Max: float(5968)},
+			},
+		},
+		{
+			name:     "units and ranges",
+			output:   "PING OK|rta=0.5ms;100:200;@10:20 loss=0% time=1.5e-3s;;;0 packets=12c",
+			wantText: "PING OK",
+			wantPerf: []models.PerfValue{
+				{Label: "rta", Value: 0.5, Unit: "ms", Warn: "100:200", Crit: "@10:20"},
+				{Label: "loss", Value: 0, Unit: "%"},
+				{Label: "time", Value: 0.0015, Unit: "s", Min: float(0)},
+				{Label: "packets", Value: 12, Unit: "c"},
+			},
+		},
+		{
+			name:     "empty thresholds and limits",
+			output:   "OK | load=1.5;;;; temp=-3;;;;",
+			wantText: "OK",
+			wantPerf: []models.PerfValue{
+				{Label: "load", Value: 1.5},
+				{Label: "temp", Value: -3},
+			},
+		},
+		{
+			name:     "quoted labels",
+			output:   "OK | 'free space'=10GB 'it''s'=1 ''=5 'a b c'=2;1;3",
+			wantText: "OK",
+			wantPerf: []models.PerfValue{
+				{Label: "free space", Value: 10, Unit: "GB"},
+				{Label: "it's", Value: 1},
+				{Label: "a b c", Value: 2, Warn: "1", Crit: "3"},
+			},
+		},
+		{
+			name: "long output",
+			output: "WARNING - 2 of 3 jobs late | jobs=3\n" +
+				"job a ran\n" +
+				"job b late | late=2;1;5\n" +
+				"queued=7\n" +
+				"oldest=3600s;;;0\n",
+			wantText: "WARNING - 2 of 3 jobs late",
+			wantPerf: []models.PerfValue{
+				{Label: "jobs", Value: 3},
+				{Label: "late", Value: 2, Warn: "1", Crit: "5"},
+				{Label: "queued", Value: 7},
+				{Label: "oldest", Value: 3600, Unit: "s", Min: float(0)},
+			},
+		},
+		{
+			name:     "long output without perfdata",
+			output:   "OK\nline one\nline two\n",
+			wantText: "OK",
+		},
+		{
+			name:     "windows line endings",
+			output:   "OK | a=1;2;3\r\nmore | b=4\r\n",
+			wantText: "OK",
+			wantPerf: []models.PerfValue{
+				{Label: "a", Value: 1, Warn: "2", Crit: "3"},
+				{Label: "b", Value: 4},
+			},
+		},
+		{
+			name:     "malformed items",
+			output:   "CRITICAL | novalue =5 x=abc y=U z=;1;2 good=1 w=2;;;min;max",
+			wantText: "CRITICAL",
+			wantPerf: []models.PerfValue{
+				{Label: "good", Value: 1},
+				{Label: "w", Value: 2},
+			},
+		},
+		{
+			name:     "empty output",
+			output:   "",
+			wantText: "",
+		},
+		{
+			name:     "perfdata only",
+			output:   "|a=1",
+			wantText: "",
+			wantPerf: []models.PerfValue{{Label: "a", Value: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, perf := parseNagiosOutput(tt.output)
+			if text != tt.wantText {
+				t.Errorf("parseNagiosOutput() text = %q, want %q", text, tt.wantText)
+			}
+			if !reflect.DeepEqual(perf, tt.wantPerf) {
+				t.Errorf("parseNagiosOutput() perfdata = %+v, want %+v", perf, tt.wantPerf)
+			}
+		})
+	}
+}
+
+func TestNagiosState(t *testing.T) {
+	for code, want := range map[int]string{0: "OK", 1: "WARNING", 2: "CRITICAL", 3: "UNKNOWN", 4: "UNKNOWN", -1: "UNKNOWN", 127: "UNKNOWN"} {
+		if got := nagiosState(code); got != want {
+			t.Errorf("nagiosState(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IANA protocol numbers of ICMP and ICMPv6, needed to parse replies
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// ping sends one ICMP echo request to host and returns the round-trip time.
+// It uses an unprivileged datagram socket where the OS allows it (Linux with
+// net.ipv4.ping_group_range covering the agent's group, macOS) and falls back
+// to a raw socket, which needs root or CAP_NET_RAW.
+func ping(ctx context.Context, host string) (time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	if len(addrs) == 0 {
+		return 0, fmt.Errorf("no addresses for %s", host)
+	}
+	ip := addrs[0].IP
+
+	networks := []string{"udp4", "ip4:icmp"}
+	listen, proto := "0.0.0.0", protocolICMP
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	if ip.To4() == nil {
+		networks = []string{"udp6", "ip6:ipv6-icmp"}
+		listen, proto = "::", protocolIPv6ICMP
+		request = ipv6.ICMPTypeEchoRequest
+	}
+
+	var conn *icmp.PacketConn
+	var raw bool
+	for i, network := range networks {
+		conn, err = icmp.ListenPacket(network, listen)
+		if err == nil {
+			raw = i == 1
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return 0, fmt.Errorf("ICMP is not permitted for this user; allow unprivileged ping with net.ipv4.ping_group_range or grant CAP_NET_RAW")
+		}
+		return 0, fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// Datagram sockets have the kernel choose the identifier and only
+	// deliver replies to our own requests, so only raw sockets filter by ID
+	id := os.Getpid() & 0xffff
+	seq := int(time.Now().UnixNano() & 0xffff)
+	msg, err := (&icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("ninjait")},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip, Zone: addrs[0].Zone}
+	if !raw {
+		dst = &net.UDPAddr{IP: ip, Zone: addrs[0].Zone}
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(msg, dst); err != nil {
+		return 0, fmt.Errorf("failed to send echo request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return 0, fmt.Errorf("no echo reply from %s", ip)
+			}
+			return 0, err
+		}
+		rtt := time.Since(start)
+
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || reply.Type == request || echo.Seq != seq || (raw && echo.ID != id) {
+			// Our own request looped back, or another process's traffic
+			continue
+		}
+		switch reply.Type {
+		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+			return rtt, nil
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// maxSamples bounds the samples kept between two collections, an hour at the
+// default sample interval, in case uploads stall
+const maxSamples = 720
+
+// samplingIdleInterval is how often sampling checks whether it was enabled
+// by a reloaded configuration
+const samplingIdleInterval = 10 * time.Second
+
+// sampleWindow holds the values sampled since the previous collection
+type sampleWindow struct {
+	cpu    []float64 // overall CPU usage percentage
+	memory []float64 // memory used percentage
+	swap   []float64 // swap used percentage, when there is swap
+
+	// Used and inode used percentages, keyed by mountpoint
+	disks  map[string][]float64
+	inodes map[string][]float64
+
+	// Network throughput in bytes per second
+	sent []float64
+	recv []float64
+}
+
+// netSample is the network counters read by a sample
+type netSample struct {
+	counters net.IOCountersStat
+	at       time.Time
+}
+
+// RunSampling samples CPU, memory, swap, disk and network usage every
+// agent.sample_interval until ctx is cancelled. Each collection summarizes and
+// clears the samples, so spikes shorter than the check interval show in the
+// statistics uploaded.
+func (m *SystemMonitor) RunSampling(ctx context.Context) {
+	for {
+		m.mu.RLock()
+		agent := m.config.Agent
+		m.mu.RUnlock()
+
+		delay := samplingIdleInterval
+		if agent.SampleInterval > 0 {
+			m.sample(agent)
+			delay = time.Duration(agent.SampleInterval) * time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// sample reads the current usage of the enabled collectors into the window.
+// CPU usage and network throughput are measured since the previous sample,
+// so the first one only sets their baseline.
+func (m *SystemMonitor) sample(agent config.AgentConfig) {
+	var cpuUsage, memoryUsage, swapUsage, sent, recv []float64
+
+	if agent.EnableCPU {
+		times, err := cpu.Times(false)
+		if err != nil || len(times) == 0 {
+			log.WithError(err).Debug("Failed to sample CPU times")
+		} else {
+			m.mu.Lock()
+			previous := m.sampleCPU
+			m.sampleCPU = &times[0]
+			m.mu.Unlock()
+			if previous != nil {
+				cpuUsage = append(cpuUsage, busyPercent(*previous, times[0]))
+			}
+		}
+	}
+
+	if agent.EnableMemory {
+		vm, err := mem.VirtualMemory()
+		if err != nil {
+			log.WithError(err).Debug("Failed to sample memory usage")
+		} else {
+			memoryUsage = append(memoryUsage, vm.UsedPercent)
+		}
+
+		swap, err := mem.SwapMemory()
+		if err != nil {
+			log.WithError(err).Debug("Failed to sample swap usage")
+		} else if swap.Total > 0 {
+			swapUsage = append(swapUsage, swap.UsedPercent)
+		}
+	}
+
+	if agent.EnableNetwork {
+		counters, err := net.IOCounters(false)
+		if err != nil || len(counters) == 0 {
+			log.WithError(err).Debug("Failed to sample network counters")
+		} else {
+			current := &netSample{counters: counters[0], at: time.Now()}
+			m.mu.Lock()
+			previous := m.sampleNet
+			m.sampleNet = current
+			m.mu.Unlock()
+			if previous != nil {
+				if rate, ok := counterRate(previous.counters.BytesSent, current.counters.BytesSent, current.at.Sub(previous.at)); ok {
+					sent = append(sent, rate)
+				}
+				if rate, ok := counterRate(previous.counters.BytesRecv, current.counters.BytesRecv, current.at.Sub(previous.at)); ok {
+					recv = append(recv, rate)
+				}
+			}
+		}
+	}
+
+	if agent.EnableDisk {
+		m.sampleDisks()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples.cpu = appendSample(m.samples.cpu, cpuUsage...)
+	m.samples.memory = appendSample(m.samples.memory, memoryUsage...)
+	m.samples.swap = appendSample(m.samples.swap, swapUsage...)
+	m.samples.sent = appendSample(m.samples.sent, sent...)
+	m.samples.recv = appendSample(m.samples.recv, recv...)
+}
+
+// sampleDisks reads the usage of the filesystems reported by the latest
+// collection. Each read runs in the background and lands in the window
+// current when it returns, so a hung network mount never delays sampling;
+// it is skipped until its pending read returns.
+func (m *SystemMonitor) sampleDisks() {
+	m.mu.RLock()
+	var mountpoints []string
+	if m.latest != nil {
+		for _, d := range m.latest.Disks {
+			mountpoints = append(mountpoints, d.Mountpoint)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, mountpoint := range mountpoints {
+		key := "sample " + mountpoint
+		if !m.startRun(key) {
+			continue
+		}
+		go func(mountpoint string) {
+			defer m.finishRun(key)
+			usage, err := disk.Usage(mountpoint)
+			if err != nil || usage.Total == 0 {
+				log.WithError(err).WithField("mountpoint", mountpoint).Debug("Failed to sample disk usage")
+				return
+			}
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.samples.disks == nil {
+				m.samples.disks = make(map[string][]float64)
+			}
+			m.samples.disks[mountpoint] = appendSample(m.samples.disks[mountpoint], usage.UsedPercent)
+			if usage.InodesTotal > 0 {
+				if m.samples.inodes == nil {
+					m.samples.inodes = make(map[string][]float64)
+				}
+				m.samples.inodes[mountpoint] = appendSample(m.samples.inodes[mountpoint], usage.InodesUsedPercent)
+			}
+		}(mountpoint)
+	}
+}
+
+// counterRate returns the per-second rate of a cumulative counter between two
+// readings, or false when the counter went back, e.g. after an interface
+// was reset
+func counterRate(previous, current uint64, elapsed time.Duration) (float64, bool) {
+	if current < previous || elapsed <= 0 {
+		return 0, false
+	}
+	return float64(current-previous) / elapsed.Seconds(), true
+}
+
+// appendSample appends values, dropping the oldest beyond maxSamples
+func appendSample(window []float64, values ...float64) []float64 {
+	window = append(window, values...)
+	if excess := len(window) - maxSamples; excess > 0 {
+		window = append(window[:0], window[excess:]...)
+	}
+	return window
+}
+
+// summarizeSamples attaches the statistics of the samples taken since the
+// previous collection to metrics, and starts a new window
+func (m *SystemMonitor) summarizeSamples(metrics *models.SystemMetrics) {
+	m.mu.Lock()
+	window := m.samples
+	m.samples = sampleWindow{}
+	m.mu.Unlock()
+
+	if metrics.CPU != nil {
+		metrics.CPU.UsageStats = summarize(window.cpu)
+	}
+	if metrics.Memory != nil {
+		metrics.Memory.UsedPercentStats = summarize(window.memory)
+		metrics.Memory.SwapUsedPercentStats = summarize(window.swap)
+	}
+	for i := range metrics.Disks {
+		d := &metrics.Disks[i]
+		d.UsedPercentStats = summarize(window.disks[d.Mountpoint])
+		d.InodesUsedPercentStats = summarize(window.inodes[d.Mountpoint])
+	}
+	if metrics.Network != nil {
+		metrics.Network.BytesSentRateStats = summarize(window.sent)
+		metrics.Network.BytesRecvRateStats = summarize(window.recv)
+	}
+}
+
+// summarize returns the statistics of values in the order sampled, or nil
+// when there are none. The 95th percentile uses the nearest-rank method.
+func summarize(values []float64) *models.SampleStats {
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, value := range sorted {
+		sum += value
+	}
+	min, max := sorted[0], sorted[len(sorted)-1]
+
+	return &models.SampleStats{
+		Min: min,
+		Max: max,
+		// Rounding can put the mean of equal values just outside them
+		Mean:  math.Max(min, math.Min(max, sum/float64(len(sorted)))),
+		P95:   sorted[int(math.Ceil(0.95*float64(len(sorted))))-1],
+		Last:  values[len(values)-1],
+		Count: len(values),
+	}
+}
@@ -0,0 +1,128 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// maxScriptOutput bounds how much script output is kept
+const maxScriptOutput = 64 << 10
+
+// collectScripts runs the check scripts that are due concurrently. Scripts
+// whose interval has not elapsed since their last run are skipped, so they
+// only appear in the collections where they ran.
+func (m *SystemMonitor) collectScripts(scripts []config.ScriptConfig, checkInterval int) []models.ScriptResult {
+	now := time.Now()
+
+	m.mu.Lock()
+	if m.scriptRuns == nil {
+		m.scriptRuns = make(map[string]time.Time)
+	}
+	var due []config.ScriptConfig
+	configured := make(map[string]bool, len(scripts))
+	for _, script := range scripts {
+		configured[script.Name] = true
+		interval := script.Interval
+		if interval == 0 {
+			interval = checkInterval
+		}
+		// Allow for ticker jitter so a script due every cycle is not skipped
+		if last, ok := m.scriptRuns[script.Name]; ok && now.Sub(last) < time.Duration(interval)*time.Second-time.Second {
+			continue
+		}
+		m.scriptRuns[script.Name] = now
+		due = append(due, script)
+	}
+	for name := range m.scriptRuns {
+		if !configured[name] {
+			delete(m.scriptRuns, name)
+		}
+	}
+	m.mu.Unlock()
+
+	results := make([]models.ScriptResult, len(due))
+	var wg sync.WaitGroup
+	for i, script := range due {
+		wg.Add(1)
+		go func(i int, script config.ScriptConfig) {
+			defer wg.Done()
+			results[i] = runScript(script)
+		}(i, script)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runScript runs a script and interprets its exit code and output as a
+// Nagios plugin. A script that times out is CRITICAL, as in Nagios; one that
+// cannot be started is UNKNOWN.
+func runScript(script config.ScriptConfig) models.ScriptResult {
+	ctx, cancel := context.WithTimeout(context.Background(), script.TimeoutDuration())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script.Command, script.Args...)
+	configureScriptCommand(cmd)
+	// Don't wait forever for children that keep the output pipe open
+	cmd.WaitDelay = 2 * time.Second
+
+	var output limitedBuffer
+	output.limit = maxScriptOutput
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+	result := models.ScriptResult{
+		Name:       script.Name,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Status = "CRITICAL"
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Script timed out after %s", script.TimeoutDuration())
+		return result
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		result.Status = "UNKNOWN"
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Failed to run script: %v", err)
+		return result
+	}
+
+	result.Status = nagiosState(result.ExitCode)
+	result.Output, result.Perfdata = parseNagiosOutput(output.String())
+	return result
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest,
+// so a runaway script cannot exhaust memory
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
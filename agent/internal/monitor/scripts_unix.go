@@ -0,0 +1,17 @@
+//go:build !windows
+
+package monitor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureScriptCommand runs the script in its own process group so a
+// timeout also kills any children it started
+func configureScriptCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package monitor
+
+import "os/exec"
+
+// configureScriptCommand leaves the default behaviour, which kills only the
+// script itself on timeout
+func configureScriptCommand(cmd *exec.Cmd) {}
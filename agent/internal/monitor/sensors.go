@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// collectSensors reads temperatures through gopsutil and, where the platform
+// exposes them, fan speeds and power draw
+func (m *SystemMonitor) collectSensors() (*models.SensorMetrics, error) {
+	temps, err := host.SensorsTemperatures()
+	var warnings *host.Warnings
+	if err != nil && !(errors.As(err, &warnings) && len(temps) > 0) {
+		return nil, fmt.Errorf("failed to read temperature sensors: %w", err)
+	}
+
+	sensors := &models.SensorMetrics{}
+	keys := newSensorKeys()
+	for _, t := range temps {
+		sensors.Temperatures = append(sensors.Temperatures, models.TemperatureSensor{
+			Key:      keys.unique("temperature", t.SensorKey),
+			Celsius:  t.Temperature,
+			High:     t.High,
+			Critical: t.Critical,
+		})
+	}
+
+	fans, power, err := readHwmon()
+	if err != nil {
+		return nil, err
+	}
+	for _, fan := range fans {
+		fan.Key = keys.unique("fan", fan.Key)
+		sensors.Fans = append(sensors.Fans, fan)
+	}
+	for _, p := range power {
+		p.Key = keys.unique("power", p.Key)
+		sensors.Power = append(sensors.Power, p)
+	}
+	return sensors, nil
+}
+
+// sensorKeys keeps sensor keys unique per kind; chips with several
+// unlabelled inputs, such as multiple NVMe drives, otherwise repeat keys
+type sensorKeys map[string]int
+
+func newSensorKeys() sensorKeys {
+	return make(sensorKeys)
+}
+
+// unique returns key, suffixed with a counter if it was already used for kind
+func (k sensorKeys) unique(kind, key string) string {
+	id := kind + "/" + key
+	k[id]++
+	if n := k[id]; n > 1 {
+		return fmt.Sprintf("%s_%d", key, n)
+	}
+	return key
+}
@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// readHwmon reads fan speeds (RPM) and power draw (microwatts) from the
+// kernel's hwmon interface. HOST_SYS overrides /sys as it does for gopsutil,
+// for agents running in a container with the host's /sys mounted elsewhere.
+func readHwmon() ([]models.FanSensor, []models.PowerSensor, error) {
+	sys := os.Getenv("HOST_SYS")
+	if sys == "" {
+		sys = "/sys"
+	}
+
+	var fans []models.FanSensor
+	fanFiles, err := filepath.Glob(filepath.Join(sys, "class/hwmon/hwmon*/fan*_input"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(fanFiles)
+	for _, file := range fanFiles {
+		value, ok := readHwmonValue(file)
+		if !ok {
+			continue
+		}
+		fans = append(fans, models.FanSensor{Key: hwmonKey(file), RPM: value})
+	}
+
+	var power []models.PowerSensor
+	powerFiles, err := filepath.Glob(filepath.Join(sys, "class/hwmon/hwmon*/power*_input"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(powerFiles)
+	for _, file := range powerFiles {
+		value, ok := readHwmonValue(file)
+		if !ok {
+			continue
+		}
+		power = append(power, models.PowerSensor{Key: hwmonKey(file), Watts: value / 1e6})
+	}
+
+	return fans, power, nil
+}
+
+// hwmonKey names an input the way gopsutil names temperatures: the chip name,
+// then the input's label, or the input name (fan1) when it has no label
+func hwmonKey(file string) string {
+	dir := filepath.Dir(file)
+	input := strings.TrimSuffix(filepath.Base(file), "_input")
+
+	chip := "hwmon"
+	if raw, err := os.ReadFile(filepath.Join(dir, "name")); err == nil {
+		chip = strings.TrimSpace(string(raw))
+	}
+
+	label := input
+	if raw, err := os.ReadFile(filepath.Join(dir, input+"_label")); err == nil && len(strings.TrimSpace(string(raw))) > 0 {
+		label = strings.Join(strings.Fields(strings.ToLower(string(raw))), "_")
+	}
+	return chip + "_" + label
+}
+
+// readHwmonValue reads a numeric hwmon attribute; inputs whose device is
+// asleep or absent fail to read and are skipped
+func readHwmonValue(file string) (float64, bool) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
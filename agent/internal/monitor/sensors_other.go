@@ -0,0 +1,10 @@
+//go:build !linux
+
+package monitor
+
+import "github.com/yossibmoha/NinjaIT/agent/pkg/models"
+
+// readHwmon reports no fan or power sensors; only Linux exposes them through hwmon
+func readHwmon() ([]models.FanSensor, []models.PowerSensor, error) {
+	return nil, nil, nil
+}
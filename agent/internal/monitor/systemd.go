@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// systemdTimeout bounds each systemctl invocation
+const systemdTimeout = 10 * time.Second
+
+// systemdProperties are the unit properties read with systemctl show
+var systemdProperties = []string{
+	"Id",
+	"Description",
+	"LoadState",
+	"ActiveState",
+	"SubState",
+	"Result",
+	"NRestarts",
+	"ActiveEnterTimestamp",
+	"InactiveEnterTimestamp",
+}
+
+// systemdTimeLayout is how systemctl prints timestamps with LC_ALL=C and TZ=UTC
+const systemdTimeLayout = "Mon 2006-01-02 15:04:05 MST"
+
+// collectSystemd reports the state of the watched units and, if configured,
+// of every failed unit
+func (m *SystemMonitor) collectSystemd(cfg config.SystemdConfig) ([]models.ServiceStatus, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("systemd monitoring is only supported on Linux")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	defer cancel()
+
+	units := make([]string, 0, len(cfg.Units))
+	seen := make(map[string]bool)
+	for _, unit := range cfg.Units {
+		// systemctl assumes .service for names without a suffix; do the same
+		// so results match the unit Id
+		if !strings.Contains(unit, ".") {
+			unit += ".service"
+		}
+		if !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+
+	if cfg.IncludeFailed {
+		failed, err := listFailedUnits(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, unit := range failed {
+			if !seen[unit] {
+				seen[unit] = true
+				units = append(units, unit)
+			}
+		}
+	}
+
+	if len(units) == 0 {
+		return nil, nil
+	}
+
+	args := []string{"show", "--property=" + strings.Join(systemdProperties, ","), "--"}
+	output, err := systemctl(ctx, append(args, units...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseUnitProperties(output), nil
+}
+
+// listFailedUnits returns the names of all units in the failed state
+func listFailedUnits(ctx context.Context) ([]string, error) {
+	output, err := systemctl(ctx, "list-units", "--state=failed", "--all", "--no-legend", "--plain")
+	if err != nil {
+		return nil, err
+	}
+
+	var units []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units, scanner.Err()
+}
+
+// parseUnitProperties parses systemctl show output, one blank-line separated
+// block of Key=Value lines per unit
+func parseUnitProperties(output []byte) []models.ServiceStatus {
+	var services []models.ServiceStatus
+	props := make(map[string]string)
+
+	flush := func() {
+		if props["Id"] != "" {
+			services = append(services, unitStatus(props))
+		}
+		props = make(map[string]string)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			props[key] = value
+		}
+	}
+	flush()
+
+	return services
+}
+
+// unitStatus converts the properties of one unit
+func unitStatus(props map[string]string) models.ServiceStatus {
+	status := models.ServiceStatus{
+		Name:        props["Id"],
+		Description: props["Description"],
+		LoadState:   props["LoadState"],
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+		Result:      props["Result"],
+	}
+	if restarts, err := strconv.ParseUint(props["NRestarts"], 10, 64); err == nil {
+		status.Restarts = restarts
+	}
+
+	if status.ActiveState == "active" {
+		status.ActiveSince = parseSystemdTime(props["ActiveEnterTimestamp"])
+	}
+	// InactiveEnterTimestamp is set on entering both inactive and failed
+	if status.ActiveState == "failed" || (status.Result != "" && status.Result != "success") {
+		status.FailedAt = parseSystemdTime(props["InactiveEnterTimestamp"])
+	}
+	return status
+}
+
+// parseSystemdTime parses a systemctl timestamp, returning nil when unset
+func parseSystemdTime(value string) *time.Time {
+	t, err := time.Parse(systemdTimeLayout, value)
+	if err != nil || t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// systemctl runs systemctl with output in a fixed locale and time zone so it
+// can be parsed
+func systemctl(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--no-pager"}, args...)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "TZ=UTC", "SYSTEMD_COLORS=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// thresholdSource is the event source of threshold crossings
+const thresholdSource = "threshold"
+
+// thresholdValue is one value a threshold applies to, e.g. the disk usage of
+// one mountpoint
+type thresholdValue struct {
+	key   string // identifies the value across collections
+	label string // describes it in event messages
+	value float64
+}
+
+// thresholdValues returns the values of metrics the named threshold applies to
+func thresholdValues(name string, metrics *models.SystemMetrics) []thresholdValue {
+	switch name {
+	case "cpu_percent":
+		if metrics.CPU != nil {
+			return []thresholdValue{{name, "CPU usage", metrics.CPU.UsagePercent}}
+		}
+	case "memory_percent":
+		if metrics.Memory != nil {
+			return []thresholdValue{{name, "Memory usage", metrics.Memory.UsedPercent}}
+		}
+	case "swap_percent":
+		if metrics.Memory != nil && metrics.Memory.SwapTotal > 0 {
+			used := float64(metrics.Memory.SwapUsed) / float64(metrics.Memory.SwapTotal) * 100
+			return []thresholdValue{{name, "Swap usage", used}}
+		}
+	case "disk_percent", "inodes_percent":
+		var values []thresholdValue
+		for _, disk := range metrics.Disks {
+			if name == "disk_percent" {
+				values = append(values, thresholdValue{name + " " + disk.Mountpoint, "Disk usage of " + disk.Mountpoint, disk.UsedPercent})
+			} else if disk.InodesTotal > 0 {
+				values = append(values, thresholdValue{name + " " + disk.Mountpoint, "Inode usage of " + disk.Mountpoint, disk.InodesUsedPercent})
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// checkThresholds compares the collected metrics with the thresholds and
+// returns an event for each value that crossed one since the previous
+// collection, in either direction. A value that stays over a threshold raises
+// no further events.
+func (m *SystemMonitor) checkThresholds(thresholds map[string]float64, metrics *models.SystemMetrics) []models.LogEvent {
+	names := make([]string, 0, len(thresholds))
+	for name := range thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	over := make(map[string]bool)
+	seen := make(map[string]bool)
+	var events []models.LogEvent
+	for _, name := range names {
+		limit := thresholds[name]
+		pattern := fmt.Sprintf("%s > %g", name, limit)
+		for _, v := range thresholdValues(name, metrics) {
+			seen[v.key] = true
+			if v.value > limit {
+				over[v.key] = true
+			}
+			switch {
+			case v.value > limit && !m.breached[v.key]:
+				events = append(events, thresholdEvent(metrics.Timestamp, pattern,
+					fmt.Sprintf("%s %.1f%% is over the %g%% threshold", v.label, v.value, limit)))
+			case v.value <= limit && m.breached[v.key]:
+				events = append(events, thresholdEvent(metrics.Timestamp, pattern,
+					fmt.Sprintf("%s %.1f%% is back under the %g%% threshold", v.label, v.value, limit)))
+			}
+		}
+	}
+
+	// A value missing from this collection, e.g. because its collector timed
+	// out, keeps its state; one whose threshold was removed is forgotten
+	for key := range m.breached {
+		name, _, _ := strings.Cut(key, " ")
+		if _, ok := thresholds[name]; ok && !seen[key] {
+			over[key] = true
+		}
+	}
+	m.breached = over
+	return events
+}
+
+func thresholdEvent(ts time.Time, pattern, message string) models.LogEvent {
+	return models.LogEvent{
+		Timestamp: ts,
+		Source:    thresholdSource,
+		Pattern:   pattern,
+		Message:   message,
+	}
+}
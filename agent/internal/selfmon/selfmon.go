@@ -0,0 +1,185 @@
+// Package selfmon measures the agent's own resource usage and throttles its
+// collection while the usage is over the configured limits, so the agent
+// never becomes the problem on the host it monitors.
+package selfmon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// checkInterval is how often the agent measures itself
+const checkInterval = 15 * time.Second
+
+// Throttling starts after overChecks consecutive checks over a limit, and
+// ends after underChecks consecutive checks below recoverRatio of every
+// limit, so a usage hovering at a limit does not flap
+const (
+	overChecks   = 2
+	underChecks  = 4
+	recoverRatio = 0.8
+)
+
+// Monitor measures the agent process and switches throttling on and off
+type Monitor struct {
+	config   func() *config.Config
+	throttle func(bool) error
+	proc     *process.Process
+
+	mu        sync.RWMutex
+	latest    *models.AgentResources
+	lastCPU   float64 // CPU seconds used by the previous check
+	lastAt    time.Time
+	over      int
+	under     int
+	throttled bool
+	reason    string
+}
+
+// New creates a monitor of the current process. current returns the active
+// configuration and throttle switches reduced collection on or off.
+func New(current func() *config.Config, throttle func(bool) error) (*Monitor, error) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open own process: %w", err)
+	}
+	return &Monitor{
+		config:   current,
+		throttle: throttle,
+		proc:     proc,
+	}, nil
+}
+
+// Run checks the agent's resource usage every check interval until ctx is
+// cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	m.check()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// Latest returns the most recent measurement, or nil before the first check
+func (m *Monitor) Latest() *models.AgentResources {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.latest == nil {
+		return nil
+	}
+	resources := *m.latest
+	resources.Throttled = m.throttled
+	resources.ThrottleReason = m.reason
+	return &resources
+}
+
+// check measures the process and starts or ends throttling
+func (m *Monitor) check() {
+	limits := m.config().Limits
+	resources := m.measure()
+
+	reason := overLimit(resources, limits, 1)
+	recovered := overLimit(resources, limits, recoverRatio) == ""
+
+	m.mu.Lock()
+	m.latest = resources
+	switch {
+	case reason != "":
+		m.over++
+		m.under = 0
+	case recovered:
+		m.under++
+		m.over = 0
+	default:
+		// Between the recovery threshold and the limit: keep the current state
+		m.over, m.under = 0, 0
+	}
+
+	start := !m.throttled && m.over >= overChecks
+	stop := m.throttled && m.under >= underChecks
+	if start {
+		m.throttled, m.reason = true, reason
+	} else if stop {
+		m.throttled, m.reason = false, ""
+	}
+	m.mu.Unlock()
+
+	switch {
+	case start:
+		log.WithFields(log.Fields{
+			"reason": reason,
+			"shed":   limits.Shed,
+		}).Warn("Agent resource usage over limit, shedding collectors and slowing collection")
+		if err := m.throttle(true); err != nil {
+			log.WithError(err).Error("Failed to throttle collection")
+		}
+	case stop:
+		log.Info("Agent resource usage back within limits, resuming full collection")
+		if err := m.throttle(false); err != nil {
+			log.WithError(err).Error("Failed to resume full collection")
+		}
+	}
+}
+
+// measure reads the process's resource usage. CPU usage is measured since
+// the previous check, so the first check reports none.
+func (m *Monitor) measure() *models.AgentResources {
+	resources := &models.AgentResources{Goroutines: runtime.NumGoroutine()}
+	now := time.Now()
+
+	if times, err := m.proc.Times(); err != nil {
+		log.WithError(err).Debug("Failed to read agent CPU time")
+	} else {
+		used := times.User + times.System
+		if !m.lastAt.IsZero() && used >= m.lastCPU {
+			resources.CPUPercent = (used - m.lastCPU) / now.Sub(m.lastAt).Seconds() * 100
+		}
+		m.lastCPU, m.lastAt = used, now
+	}
+
+	if mem, err := m.proc.MemoryInfo(); err != nil {
+		log.WithError(err).Debug("Failed to read agent memory usage")
+	} else {
+		resources.RSSBytes = mem.RSS
+	}
+
+	// Not supported on Windows, where the limit is not enforced
+	if fds, err := m.proc.NumFDs(); err == nil {
+		resources.OpenFDs = int(fds)
+	}
+
+	return resources
+}
+
+// overLimit describes the first limit, scaled by ratio, that the usage
+// exceeds, or returns "" when it is within all of them
+func overLimit(r *models.AgentResources, limits config.LimitsConfig, ratio float64) string {
+	switch {
+	case limits.CPUPercent > 0 && r.CPUPercent > float64(limits.CPUPercent)*ratio:
+		return fmt.Sprintf("CPU %.1f%% of a core, limit %d%%", r.CPUPercent, limits.CPUPercent)
+	case limits.MemoryMB > 0 && float64(r.RSSBytes) > float64(uint64(limits.MemoryMB)<<20)*ratio:
+		return fmt.Sprintf("resident memory %d MB, limit %d MB", r.RSSBytes>>20, limits.MemoryMB)
+	case limits.Goroutines > 0 && float64(r.Goroutines) > float64(limits.Goroutines)*ratio:
+		return fmt.Sprintf("%d goroutines, limit %d", r.Goroutines, limits.Goroutines)
+	case limits.OpenFDs > 0 && float64(r.OpenFDs) > float64(limits.OpenFDs)*ratio:
+		return fmt.Sprintf("%d open files, limit %d", r.OpenFDs, limits.OpenFDs)
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Name is the systemd service name
+const Name = "ninjait-agent"
+
+// UnitPath is where the systemd unit is installed
+const UnitPath = "/etc/systemd/system/" + Name + ".service"
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=NinjaIT Monitoring Agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+{{- end}}
+ExecStart={{.Binary}} run -config {{.ConfigFile}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// Options controls how the agent is installed as a systemd service
+type Options struct {
+	Binary     string // absolute path of the agent binary the unit runs
+	ConfigFile string
+	User       string // empty runs the service as root
+	ServerURL  string
+	APIKey     string
+	Force      bool // overwrite an existing configuration file
+	Start      bool // enable and start the service after installing
+}
+
+// Install writes the default configuration (unless one exists) and the
+// systemd unit, then optionally enables and starts the service
+func Install(opts Options) error {
+	if err := checkSystemd(); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(opts.Binary) || strings.ContainsAny(opts.Binary+opts.ConfigFile, " \t\n") {
+		return fmt.Errorf("binary and config paths must be absolute and contain no whitespace")
+	}
+	if opts.User != "" {
+		if _, err := user.Lookup(opts.User); err != nil {
+			return fmt.Errorf("service user: %w", err)
+		}
+	}
+
+	if err := writeConfig(opts); err != nil {
+		return err
+	}
+
+	var unit bytes.Buffer
+	if err := unitTemplate.Execute(&unit, opts); err != nil {
+		return fmt.Errorf("failed to render unit: %w", err)
+	}
+	if err := os.WriteFile(UnitPath, unit.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write unit: %w", err)
+	}
+	log.WithField("path", UnitPath).Info("Wrote systemd unit")
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if !opts.Start {
+		return nil
+	}
+	if err := systemctl("enable", "--now", Name); err != nil {
+		return err
+	}
+	log.WithField("service", Name).Info("Service enabled and started")
+	return nil
+}
+
+// Uninstall stops and disables the service and removes its unit. The
+// configuration file is only removed when purge is set.
+func Uninstall(configFile string, purge bool) error {
+	if err := checkSystemd(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(UnitPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", Name)
+	}
+
+	if err := systemctl("disable", "--now", Name); err != nil {
+		log.WithError(err).Warn("Failed to stop service, removing it anyway")
+	}
+	if err := os.Remove(UnitPath); err != nil {
+		return fmt.Errorf("failed to remove unit: %w", err)
+	}
+	log.WithField("path", UnitPath).Info("Removed systemd unit")
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+
+	if purge {
+		if err := os.Remove(configFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove configuration: %w", err)
+		}
+		log.WithField("path", configFile).Info("Removed configuration")
+	}
+	return nil
+}
+
+// defaultConfig is the configuration file written on install
+type defaultConfig struct {
+	Server struct {
+		URL       string `yaml:"url"`
+		APIKey    string `yaml:"api_key,omitempty"`
+		WSEnabled bool   `yaml:"ws_enabled"`
+	} `yaml:"server"`
+	Agent struct {
+		CheckInterval     int `yaml:"check_interval"`
+		HeartbeatInterval int `yaml:"heartbeat_interval"`
+	} `yaml:"agent"`
+}
+
+// writeConfig writes a default configuration, validating it before it
+// replaces anything on disk
+func writeConfig(opts Options) error {
+	if _, err := os.Stat(opts.ConfigFile); err == nil && !opts.Force {
+		if opts.ServerURL != "" || opts.APIKey != "" {
+			log.WithField("path", opts.ConfigFile).Warn("Configuration exists, ignoring server settings (use -force to overwrite)")
+		} else {
+			log.WithField("path", opts.ConfigFile).Info("Keeping existing configuration")
+		}
+		return nil
+	}
+
+	var cfg defaultConfig
+	cfg.Server.URL = opts.ServerURL
+	cfg.Server.APIKey = opts.APIKey
+	cfg.Server.WSEnabled = true
+	cfg.Agent.CheckInterval = 60
+	cfg.Agent.HeartbeatInterval = 30
+
+	var data bytes.Buffer
+	encoder := yaml.NewEncoder(&data)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return fmt.Errorf("failed to render configuration: %w", err)
+	}
+
+	dir := filepath.Dir(opts.ConfigFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create configuration directory: %w", err)
+	}
+
+	// The file holds the API key, so only the owner may read it
+	tmp, err := os.CreateTemp(dir, ".agent-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	if _, err := config.Load(tmp.Name()); err != nil {
+		return err
+	}
+	if opts.User != "" {
+		if err := chown(tmp.Name(), opts.User); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), opts.ConfigFile); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+
+	log.WithField("path", opts.ConfigFile).Info("Wrote configuration")
+	return nil
+}
+
+// checkSystemd verifies the host can run the agent as a systemd service
+func checkSystemd() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("service installation is only supported on Linux with systemd")
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("service installation must run as root")
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("systemctl not found: %w", err)
+	}
+	return nil
+}
+
+// chown gives username ownership of path
+func chown(path, username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("service user: %w", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("service user %s has non-numeric uid %q", username, u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("service user %s has non-numeric gid %q", username, u.Gid)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to change configuration owner: %w", err)
+	}
+	return nil
+}
+
+func systemctl(args ...string) error {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
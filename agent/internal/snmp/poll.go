@@ -0,0 +1,344 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// System group OIDs
+const (
+	oidSysDescr    = ".1.3.6.1.2.1.1.1.0"
+	oidSysObjectID = ".1.3.6.1.2.1.1.2.0"
+	oidSysUpTime   = ".1.3.6.1.2.1.1.3.0"
+	oidSysName     = ".1.3.6.1.2.1.1.5.0"
+)
+
+// Interface tables; rows are <table>.<column>.<ifIndex>
+const (
+	oidIfTable  = ".1.3.6.1.2.1.2.2.1"
+	oidIfXTable = ".1.3.6.1.2.1.31.1.1.1"
+)
+
+// maxRepetitions keeps GETBULK responses small enough for devices with
+// modest buffers
+const maxRepetitions = 25
+
+// poll collects system, interface and configured OID data from a target. An
+// error means the device could not be reached; failures of individual
+// configured OIDs are reported in their values instead.
+func poll(ctx context.Context, target config.SNMPTarget) (*models.SNMPMetrics, error) {
+	client, err := newClient(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Conn.Close()
+
+	metrics := &models.SNMPMetrics{}
+
+	system, err := client.Get([]string{oidSysDescr, oidSysObjectID, oidSysUpTime, oidSysName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system group: %w", err)
+	}
+	for _, pdu := range system.Variables {
+		switch pdu.Name {
+		case oidSysDescr:
+			metrics.SysDescr = text(pdu)
+		case oidSysObjectID:
+			metrics.SysObjectID = text(pdu)
+		case oidSysUpTime:
+			// TimeTicks are hundredths of a second
+			metrics.Uptime = gosnmp.ToBigInt(pdu.Value).Uint64() / 100
+		case oidSysName:
+			metrics.SysName = text(pdu)
+		}
+	}
+
+	interfaces, err := walkInterfaces(client)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Interfaces = interfaces
+
+	if len(target.OIDs) > 0 {
+		values, err := getValues(client, target.OIDs)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Values = values
+	}
+
+	return metrics, nil
+}
+
+// newClient configures a gosnmp client for the target's version and credentials
+func newClient(ctx context.Context, target config.SNMPTarget) (*gosnmp.GoSNMP, error) {
+	host, port := target.Address, uint16(161)
+	if h, p, err := net.SplitHostPort(target.Address); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in address %q", target.Address)
+		}
+		host, port = h, uint16(n)
+	}
+
+	client := &gosnmp.GoSNMP{
+		Context:        ctx,
+		Target:         host,
+		Port:           port,
+		Transport:      "udp",
+		Timeout:        target.TimeoutDuration(),
+		Retries:        target.Retries,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: maxRepetitions,
+	}
+
+	if target.Version != "3" {
+		client.Version = gosnmp.Version2c
+		client.Community = target.Community.Value()
+		return client, nil
+	}
+
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:                 target.Username,
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		AuthenticationPassphrase: target.AuthPassword.Value(),
+		PrivacyProtocol:          gosnmp.NoPriv,
+		PrivacyPassphrase:        target.PrivPassword.Value(),
+	}
+	flags := gosnmp.NoAuthNoPriv
+	if target.AuthProtocol != "" {
+		params.AuthenticationProtocol = authProtocols[target.AuthProtocol]
+		flags = gosnmp.AuthNoPriv
+	}
+	if target.PrivProtocol != "" {
+		params.PrivacyProtocol = privProtocols[target.PrivProtocol]
+		flags = gosnmp.AuthPriv
+	}
+
+	client.Version = gosnmp.Version3
+	client.SecurityModel = gosnmp.UserSecurityModel
+	client.MsgFlags = flags
+	client.SecurityParameters = params
+	return client, nil
+}
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":    gosnmp.DES,
+	"AES":    gosnmp.AES,
+	"AES192": gosnmp.AES192,
+	"AES256": gosnmp.AES256,
+}
+
+// walkInterfaces reads ifTable and, where the device has it, ifXTable for
+// names, aliases and 64-bit counters
+func walkInterfaces(client *gosnmp.GoSNMP) ([]models.SNMPInterface, error) {
+	byIndex := make(map[int]*models.SNMPInterface)
+	var order []int
+	row := func(index int) *models.SNMPInterface {
+		iface, ok := byIndex[index]
+		if !ok {
+			iface = &models.SNMPInterface{Index: index}
+			byIndex[index] = iface
+			order = append(order, index)
+		}
+		return iface
+	}
+
+	ifTable, err := client.BulkWalkAll(oidIfTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk ifTable: %w", err)
+	}
+	for _, pdu := range ifTable {
+		column, index, ok := tableCell(oidIfTable, pdu.Name)
+		if !ok {
+			continue
+		}
+		iface := row(index)
+		value := gosnmp.ToBigInt(pdu.Value).Uint64()
+		switch column {
+		case 2: // ifDescr
+			if iface.Name == "" {
+				iface.Name = text(pdu)
+			}
+		case 5: // ifSpeed, capped at 4.29 Gbit/s; ifHighSpeed below replaces it
+			iface.Speed = value
+		case 7: // ifAdminStatus
+			iface.AdminUp = value == 1
+		case 8: // ifOperStatus
+			iface.OperUp = value == 1
+		case 10: // ifInOctets
+			iface.InOctets = value
+		case 13: // ifInDiscards
+			iface.InDiscards = value
+		case 14: // ifInErrors
+			iface.InErrors = value
+		case 16: // ifOutOctets
+			iface.OutOctets = value
+		case 19: // ifOutDiscards
+			iface.OutDiscards = value
+		case 20: // ifOutErrors
+			iface.OutErrors = value
+		}
+	}
+
+	// ifXTable is optional; SNMPv1-era devices do not implement it
+	ifXTable, err := client.BulkWalkAll(oidIfXTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk ifXTable: %w", err)
+	}
+	for _, pdu := range ifXTable {
+		column, index, ok := tableCell(oidIfXTable, pdu.Name)
+		if !ok {
+			continue
+		}
+		iface := row(index)
+		value := gosnmp.ToBigInt(pdu.Value).Uint64()
+		switch column {
+		case 1: // ifName
+			if name := text(pdu); name != "" {
+				iface.Name = name
+			}
+		case 6: // ifHCInOctets
+			iface.InOctets = value
+		case 10: // ifHCOutOctets
+			iface.OutOctets = value
+		case 15: // ifHighSpeed, in Mbit/s
+			if value > 0 {
+				iface.Speed = value * 1_000_000
+			}
+		case 18: // ifAlias
+			iface.Alias = text(pdu)
+		}
+	}
+
+	interfaces := make([]models.SNMPInterface, 0, len(order))
+	for _, index := range order {
+		interfaces = append(interfaces, *byIndex[index])
+	}
+	return interfaces, nil
+}
+
+// tableCell splits a table OID into its column and single-part index
+func tableCell(table, oid string) (column, index int, ok bool) {
+	rest, found := strings.CutPrefix(oid, table+".")
+	if !found {
+		return 0, 0, false
+	}
+	c, i, found := strings.Cut(rest, ".")
+	if !found {
+		return 0, 0, false
+	}
+	column, err := strconv.Atoi(c)
+	if err != nil {
+		return 0, 0, false
+	}
+	index, err = strconv.Atoi(i)
+	if err != nil {
+		return 0, 0, false
+	}
+	return column, index, true
+}
+
+// getValues polls the configured OIDs, in as few requests as the client allows
+func getValues(client *gosnmp.GoSNMP, oids []config.SNMPOID) ([]models.SNMPValue, error) {
+	values := make([]models.SNMPValue, 0, len(oids))
+	for start := 0; start < len(oids); start += client.MaxOids {
+		end := start + client.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		batch := oids[start:end]
+
+		names := make([]string, len(batch))
+		for i, oid := range batch {
+			names[i] = "." + strings.TrimPrefix(oid.OID, ".")
+		}
+		result, err := client.Get(names)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get configured OIDs: %w", err)
+		}
+		if len(result.Variables) != len(batch) {
+			return nil, fmt.Errorf("device returned %d values for %d OIDs", len(result.Variables), len(batch))
+		}
+
+		for i, pdu := range result.Variables {
+			values = append(values, value(batch[i], pdu))
+		}
+	}
+	return values, nil
+}
+
+// value converts a PDU into a reported value
+func value(oid config.SNMPOID, pdu gosnmp.SnmpPDU) models.SNMPValue {
+	v := models.SNMPValue{Name: oid.Name, OID: oid.OID}
+
+	var number float64
+	switch pdu.Type {
+	case gosnmp.NoSuchObject:
+		v.Error = "no such object"
+		return v
+	case gosnmp.NoSuchInstance:
+		v.Error = "no such instance"
+		return v
+	case gosnmp.Null, gosnmp.EndOfMibView:
+		v.Error = "no value"
+		return v
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		number, _ = new(big.Float).SetInt(gosnmp.ToBigInt(pdu.Value)).Float64()
+	case gosnmp.OpaqueFloat:
+		number = float64(pdu.Value.(float32))
+	case gosnmp.OpaqueDouble:
+		number = pdu.Value.(float64)
+	default:
+		v.Text = text(pdu)
+		return v
+	}
+	v.Value = &number
+	return v
+}
+
+// text renders a PDU as a string. Octet strings that are not printable text,
+// such as MAC addresses, are shown as colon-separated hex.
+func text(pdu gosnmp.SnmpPDU) string {
+	switch value := pdu.Value.(type) {
+	case []byte:
+		if utf8.Valid(value) && strings.IndexFunc(string(value), func(r rune) bool {
+			return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+		}) < 0 {
+			return strings.TrimRight(string(value), "\x00")
+		}
+		hex := make([]string, len(value))
+		for i, b := range value {
+			hex[i] = fmt.Sprintf("%02x", b)
+		}
+		return strings.Join(hex, ":")
+	case string:
+		return value
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,112 @@
+// Package snmp polls network devices over SNMP on behalf of the server. Each
+// target is reported as a device of its own, with the polling agent recorded
+// as its proxy.
+package snmp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// maxConcurrentPolls bounds how many targets are polled at once
+const maxConcurrentPolls = 16
+
+// Poller polls the configured SNMP targets
+type Poller struct {
+	mu        sync.RWMutex
+	config    *config.Config
+	apiClient *api.Client
+}
+
+// NewPoller creates a new SNMP poller
+func NewPoller(cfg *config.Config, client *api.Client) *Poller {
+	return &Poller{
+		config:    cfg,
+		apiClient: client,
+	}
+}
+
+// UpdateConfig applies a reloaded configuration to subsequent polls
+func (p *Poller) UpdateConfig(cfg *config.Config) {
+	p.mu.Lock()
+	p.config = cfg
+	p.mu.Unlock()
+}
+
+// Poll polls every target concurrently and returns one sample per target.
+// An unreachable target yields a sample with Reachable false, so the server
+// can mark the device offline.
+func (p *Poller) Poll(ctx context.Context) []*models.SystemMetrics {
+	p.mu.RLock()
+	cfg := p.config
+	p.mu.RUnlock()
+
+	if !cfg.Agent.EnableSNMP || len(cfg.SNMP) == 0 {
+		return nil
+	}
+
+	// Leave room in the cycle so a slow device does not delay the next one
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Agent.CheckInterval)*time.Second*9/10)
+	defer cancel()
+
+	samples := make([]*models.SystemMetrics, len(cfg.SNMP))
+	sem := make(chan struct{}, maxConcurrentPolls)
+	var wg sync.WaitGroup
+	for i, target := range cfg.SNMP {
+		wg.Add(1)
+		go func(i int, target config.SNMPTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			samples[i] = pollTarget(ctx, cfg, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+// PollAndSend polls every target and sends each sample to the server
+func (p *Poller) PollAndSend(ctx context.Context) error {
+	var firstErr error
+	for _, sample := range p.Poll(ctx) {
+		if err := p.apiClient.SendMetrics(sample); err != nil {
+			log.WithError(err).WithField("device_id", sample.DeviceID).Error("Failed to send SNMP metrics")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// pollTarget polls one target into a sample reported under its own device ID
+func pollTarget(ctx context.Context, cfg *config.Config, target config.SNMPTarget) *models.SystemMetrics {
+	sample := &models.SystemMetrics{
+		Timestamp: time.Now(),
+		DeviceID:  target.ID(),
+		Hostname:  target.Name,
+		ProxyID:   cfg.Agent.DeviceID,
+	}
+
+	metrics, err := poll(ctx, target)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"target":  target.Name,
+			"address": target.Address,
+		}).Warn("SNMP poll failed")
+		metrics = &models.SNMPMetrics{Error: err.Error()}
+	} else {
+		metrics.Reachable = true
+	}
+	metrics.Address = target.Address
+	sample.SNMP = metrics
+
+	return sample
+}
@@ -0,0 +1,115 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Fetch retrieves the status report from a running agent
+func Fetch(ctx context.Context, address string) (*Report, error) {
+	transport := &http.Transport{}
+	host := address
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		// The host is ignored when dialing a Unix socket
+		host = "agent"
+	}
+
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent at %s (is status.enabled set?): %w", address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned status %d", resp.StatusCode)
+	}
+
+	var report Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode status report: %w", err)
+	}
+	return &report, nil
+}
+
+// Print writes a human-readable summary of the report to w
+func Print(w io.Writer, r *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Agent:\t%s (%s)\n", r.DeviceID, r.Hostname)
+	fmt.Fprintf(tw, "Version:\t%s\n", r.Version)
+	fmt.Fprintf(tw, "Uptime:\t%s\n", (time.Duration(r.UptimeSeconds) * time.Second).String())
+	fmt.Fprintf(tw, "Policy version:\t%d\n", r.PolicyVersion)
+	fmt.Fprintln(tw)
+
+	c := r.Connection
+	fmt.Fprintf(tw, "Server:\t%s\n", c.ServerURL)
+	fmt.Fprintf(tw, "Connected:\t%t (WebSocket: %t)\n", c.Connected, c.WebSocketConnected)
+	fmt.Fprintf(tw, "Last heartbeat:\t%s\n", ago(c.LastHeartbeat))
+	fmt.Fprintf(tw, "Last metrics sent:\t%s\n", ago(c.LastMetrics))
+	if !c.LastEvents.IsZero() {
+		fmt.Fprintf(tw, "Last events sent:\t%s\n", ago(c.LastEvents))
+	}
+	if !c.LastInventory.IsZero() {
+		fmt.Fprintf(tw, "Last inventory sent:\t%s\n", ago(c.LastInventory))
+	}
+	fmt.Fprintf(tw, "Send failures:\t%d\n", c.SendFailures)
+	if c.LastError != "" {
+		fmt.Fprintf(tw, "Last error:\t%s (%s)\n", c.LastError, ago(c.LastErrorAt))
+	}
+	fmt.Fprintln(tw)
+
+	if res := r.Resources; res != nil {
+		fmt.Fprintf(tw, "Agent resources:\tCPU %.1f%%, RSS %d MB, %d goroutines, %d open files\n",
+			res.CPUPercent, res.RSSBytes>>20, res.Goroutines, res.OpenFDs)
+		if res.Throttled {
+			fmt.Fprintf(tw, "Throttled:\t%s\n", res.ThrottleReason)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintf(tw, "Last collection:\t%s\n", ago(r.LastCollection))
+	fmt.Fprintln(tw, "COLLECTOR\tDURATION\tERRORS\tLAST ERROR")
+	names := make([]string, 0, len(r.Collectors))
+	for name := range r.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		col := r.Collectors[name]
+		fmt.Fprintf(tw, "%s\t%.1fms\t%d\t%s\n", name, col.DurationMs, col.Errors, col.LastError)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range r.Config {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Path, s.Value, s.Source)
+	}
+
+	return tw.Flush()
+}
+
+// ago formats a timestamp relative to now, or "never" for the zero time
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s ago", time.Since(t).Round(time.Second))
+}
@@ -0,0 +1,145 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/api"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/monitor"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// Path is the HTTP path the status report is served on
+const Path = "/status"
+
+// Sources provides the live agent state included in the status report
+type Sources struct {
+	Version    string
+	StartedAt  time.Time
+	Config     func() *config.Config
+	Connection func() api.ConnectionStatus
+	Collectors func() map[string]monitor.CollectorStatus
+	Resources  func() *models.AgentResources
+	LastSample func() time.Time
+}
+
+// Report is the agent state served by the status endpoint
+type Report struct {
+	Version        string                             `json:"version"`
+	DeviceID       string                             `json:"device_id"`
+	Hostname       string                             `json:"hostname"`
+	StartedAt      time.Time                          `json:"started_at"`
+	UptimeSeconds  float64                            `json:"uptime_seconds"`
+	PolicyVersion  int64                              `json:"policy_version"`
+	Connection     api.ConnectionStatus               `json:"connection"`
+	LastCollection time.Time                          `json:"last_collection"`
+	Collectors     map[string]monitor.CollectorStatus `json:"collectors"`
+	Resources      *models.AgentResources             `json:"resources,omitempty"`
+	Config         []config.Setting                   `json:"config"`
+}
+
+// Server serves the local status endpoint
+type Server struct {
+	address string
+	sources Sources
+	server  *http.Server
+}
+
+// NewServer creates a status server listening on the configured address
+func NewServer(cfg *config.Config, sources Sources) *Server {
+	s := &Server{
+		address: cfg.Status.ListenAddress,
+		sources: sources,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, s.handleStatus)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start serves the status endpoint until Shutdown is called
+func (s *Server) Start() error {
+	listener, err := listen(s.address)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("address", s.address).Info("Status endpoint listening")
+
+	if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the status server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Build assembles the current status report
+func (s *Server) Build() Report {
+	cfg := s.sources.Config()
+	return Report{
+		Version:        s.sources.Version,
+		DeviceID:       cfg.Agent.DeviceID,
+		Hostname:       cfg.Agent.Hostname,
+		StartedAt:      s.sources.StartedAt,
+		UptimeSeconds:  time.Since(s.sources.StartedAt).Seconds(),
+		PolicyVersion:  cfg.PolicyVersion,
+		Connection:     s.sources.Connection(),
+		LastCollection: s.sources.LastSample(),
+		Collectors:     s.sources.Collectors(),
+		Resources:      s.sources.Resources(),
+		Config:         cfg.Settings(),
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Build()); err != nil {
+		log.WithError(err).Debug("Failed to write status report")
+	}
+}
+
+// listen opens a TCP listener, or a Unix socket for unix:/path addresses
+func listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// Remove a socket left behind by an agent that did not shut down cleanly
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the agent's user and group may query it
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart replaces the running process with binary, keeping its arguments,
+// environment and PID so the service manager does not notice
+func restart(binary string) error {
+	return syscall.Exec(binary, os.Args, os.Environ())
+}
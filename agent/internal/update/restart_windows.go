@@ -0,0 +1,11 @@
+//go:build windows
+
+package update
+
+import "fmt"
+
+// restart cannot replace a running process on Windows; returning an error
+// makes the agent exit so the service manager starts the new binary
+func restart(binary string) error {
+	return fmt.Errorf("in-place restart is not supported on Windows")
+}
@@ -0,0 +1,91 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFile is the name of the file tracking an update on trial
+const stateFile = "update-state.json"
+
+// state survives the restart into a new version so the new process knows it
+// is on trial and how to roll back
+type state struct {
+	PreviousVersion string     `json:"previous_version,omitempty"`
+	TargetVersion   string     `json:"target_version,omitempty"`
+	Deadline        *time.Time `json:"deadline,omitempty"` // nil when no update is on trial
+	Failed          []string   `json:"failed,omitempty"`   // versions that were rolled back
+}
+
+// pending reports whether an update is waiting to be confirmed
+func (s *state) pending() bool {
+	return s.Deadline != nil
+}
+
+// hasFailed reports whether v was rolled back before
+func (s *state) hasFailed(v string) bool {
+	for _, failed := range s.Failed {
+		if failed == v {
+			return true
+		}
+	}
+	return false
+}
+
+// markFailed records v as rolled back and clears the trial
+func (s *state) markFailed(v string) {
+	if !s.hasFailed(v) {
+		s.Failed = append(s.Failed, v)
+	}
+	s.clearTrial()
+}
+
+// clearTrial ends the current trial
+func (s *state) clearTrial() {
+	s.PreviousVersion = ""
+	s.TargetVersion = ""
+	s.Deadline = nil
+}
+
+// loadState reads the state file, returning an empty state if none exists
+func loadState(dir string) (*state, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read update state: %w", err)
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse update state: %w", err)
+	}
+	return &s, nil
+}
+
+// save writes the state file atomically
+func (s *state) save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode update state: %w", err)
+	}
+
+	path := filepath.Join(dir, stateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write update state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write update state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package update
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/version"
+)
+
+// maxTrialStartups is how often a version on trial may start without
+// confirming before it is rolled back, e.g. while crash looping
+const maxTrialStartups = 3
+
+// trialMarker is kept next to the binary while an update is on trial. Unlike
+// the state file it does not depend on the configuration, so a new version
+// that cannot load its configuration still finds it.
+type trialMarker struct {
+	TargetVersion string    `json:"target_version"`
+	Deadline      time.Time `json:"deadline"`
+	Startups      int       `json:"startups"`
+}
+
+// trialPath is where the trial marker of binary is kept
+func trialPath(binary string) string {
+	return binary + ".trial"
+}
+
+// loadTrialMarker reads the trial marker of binary, returning nil if there is
+// none
+func loadTrialMarker(binary string) (*trialMarker, error) {
+	data, err := os.ReadFile(trialPath(binary))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read update trial: %w", err)
+	}
+
+	var m trialMarker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse update trial: %w", err)
+	}
+	return &m, nil
+}
+
+// save writes the trial marker of binary atomically
+func (m *trialMarker) save(binary string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode update trial: %w", err)
+	}
+
+	path := trialPath(binary)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write update trial: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write update trial: %w", err)
+	}
+	return nil
+}
+
+// removeTrialMarker deletes the trial marker of binary, if any
+func removeTrialMarker(binary string) {
+	if err := os.Remove(trialPath(binary)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).Warn("Failed to remove update trial marker")
+	}
+}
+
+// GuardTrial must run before anything else when the agent starts. While an
+// update is on trial it counts the startups of the new version, and once the
+// grace period has passed or the version started maxTrialStartups times
+// without confirming, it restores the previous binary and restarts into it.
+// This catches versions that crash or exit before CheckTrial can run; the
+// previous version then records the update as failed. GuardTrial returns when
+// the agent should start normally.
+func GuardTrial() {
+	binary, err := executable()
+	if err != nil {
+		log.WithError(err).Error("Failed to check agent update trial")
+		return
+	}
+	marker, err := loadTrialMarker(binary)
+	if err != nil {
+		log.WithError(err).Error("Failed to check agent update trial")
+		return
+	}
+	if marker == nil {
+		return
+	}
+	if marker.TargetVersion != version.Version {
+		// The previous version is running again; CheckTrial records the failure
+		removeTrialMarker(binary)
+		return
+	}
+
+	marker.Startups++
+	if marker.Startups <= maxTrialStartups && time.Now().Before(marker.Deadline) {
+		if err := marker.save(binary); err != nil {
+			log.WithError(err).Error("Failed to record agent startup on trial")
+		}
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"target":   marker.TargetVersion,
+		"startups": marker.Startups,
+	})
+	logger.Error("Agent update did not confirm in time, rolling back before starting")
+
+	if err := os.Rename(binary+".previous", binary); err != nil {
+		logger.WithError(err).Error("Agent update rollback failed")
+		return
+	}
+	removeTrialMarker(binary)
+	if err := restart(binary); err != nil {
+		logger.WithError(err).Error("Failed to restart agent, exiting for the service manager to restart it")
+		os.Exit(1)
+	}
+}
+
+// executable returns the path of the running binary with symlinks resolved
+func executable() (string, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate agent binary: %w", err)
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		return "", fmt.Errorf("failed to locate agent binary: %w", err)
+	}
+	return binary, nil
+}
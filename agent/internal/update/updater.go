@@ -0,0 +1,354 @@
+// Package update applies agent versions advertised by the server. Binaries
+// must match their SHA-256 digest and carry an Ed25519 signature from the key
+// compiled into the agent. A new version is on trial until it sends a
+// heartbeat; if it does not within the grace period, or keeps failing to
+// start, the previous binary is restored.
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/agent/internal/config"
+	"github.com/yossibmoha/NinjaIT/agent/internal/version"
+	"github.com/yossibmoha/NinjaIT/agent/pkg/models"
+)
+
+// publicKey is the base64 Ed25519 key updates must be signed with, set at
+// build time with -ldflags "-X .../internal/update.publicKey=..."
+var publicKey string
+
+// maxBinarySize bounds how much a download may read
+const maxBinarySize = 256 << 20
+
+// Updater downloads, verifies and installs new agent binaries
+type Updater struct {
+	mu          sync.Mutex
+	cfg         config.UpdateConfig
+	binary      string
+	httpClient  *http.Client
+	busy        bool
+	restartChan chan struct{}
+}
+
+// New creates an updater for the running binary
+func New(cfg *config.Config) (*Updater, error) {
+	binary, err := executable()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Updater{
+		cfg:    cfg.Update,
+		binary: binary,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+		restartChan: make(chan struct{}, 1),
+	}, nil
+}
+
+// Restarts signals when a new binary is in place and the agent should stop
+// and call Restart
+func (u *Updater) Restarts() <-chan struct{} {
+	return u.restartChan
+}
+
+// Restart replaces the running process with the installed binary
+func (u *Updater) Restart() error {
+	log.WithField("binary", u.binary).Info("Restarting agent")
+	return restart(u.binary)
+}
+
+// Offer installs the advertised update in the background unless updates are
+// disabled, the version is already running, or it was rolled back before
+func (u *Updater) Offer(update *models.AgentUpdate) {
+	if !u.cfg.Enabled || update == nil || update.Version == version.Version {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"current": version.Version,
+		"target":  update.Version,
+	})
+	if publicKey == "" {
+		logger.Warn("Ignoring agent update: this build has no update signing key")
+		return
+	}
+
+	st, err := loadState(u.cfg.StateDir)
+	if err != nil {
+		logger.WithError(err).Error("Ignoring agent update")
+		return
+	}
+	if st.hasFailed(update.Version) {
+		logger.Debug("Ignoring agent update that was rolled back before")
+		return
+	}
+
+	u.mu.Lock()
+	if u.busy {
+		u.mu.Unlock()
+		return
+	}
+	u.busy = true
+	u.mu.Unlock()
+
+	go func() {
+		err := u.apply(update, st)
+
+		u.mu.Lock()
+		// Stay busy after a successful install; the agent is restarting
+		u.busy = err == nil
+		u.mu.Unlock()
+
+		if err != nil {
+			logger.WithError(err).Error("Agent update failed")
+			return
+		}
+		logger.Info("Agent update installed")
+		u.signalRestart()
+	}()
+}
+
+// apply downloads and verifies the update, installs it over the running
+// binary and starts the trial
+func (u *Updater) apply(update *models.AgentUpdate, st *state) error {
+	log.WithFields(log.Fields{
+		"version": update.Version,
+		"url":     update.URL,
+	}).Info("Downloading agent update")
+
+	binary, err := u.download(update.URL)
+	if err != nil {
+		return err
+	}
+	if err := verify(binary, update); err != nil {
+		return err
+	}
+	if err := u.install(binary); err != nil {
+		return err
+	}
+
+	st.PreviousVersion = version.Version
+	st.TargetVersion = update.Version
+	deadline := time.Now().Add(time.Duration(u.cfg.GracePeriod) * time.Second)
+	st.Deadline = &deadline
+	marker := &trialMarker{TargetVersion: update.Version, Deadline: deadline}
+	if err := errors.Join(st.save(u.cfg.StateDir), marker.save(u.binary)); err != nil {
+		// Without the state the new version could not roll itself back
+		removeTrialMarker(u.binary)
+		if rollbackErr := u.restorePrevious(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// download fetches the binary into memory
+func (u *Updater) download(url string) ([]byte, error) {
+	resp, err := u.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download update: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download update: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBinarySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download update: %w", err)
+	}
+	if len(data) > maxBinarySize {
+		return nil, fmt.Errorf("update exceeds %d bytes", maxBinarySize)
+	}
+	return data, nil
+}
+
+// verify checks the binary against its digest and signature
+func verify(binary []byte, update *models.AgentUpdate) error {
+	digest := sha256.Sum256(binary)
+	if !strings.EqualFold(hex.EncodeToString(digest[:]), update.SHA256) {
+		return fmt.Errorf("update checksum mismatch")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid update signing key compiled into agent")
+	}
+	signature, err := base64.StdEncoding.DecodeString(update.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid update signature: %w", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), binary, signature) {
+		return fmt.Errorf("update signature verification failed")
+	}
+	return nil
+}
+
+// install keeps the running binary as a rollback copy and moves the new one
+// into its place
+func (u *Updater) install(binary []byte) error {
+	// The temporary file must be on the same filesystem for the rename
+	tmp, err := os.CreateTemp(filepath.Dir(u.binary), ".ninjait-agent-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+
+	if err := u.keepPrevious(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), u.binary); err != nil {
+		return fmt.Errorf("failed to replace agent binary: %w", err)
+	}
+	return nil
+}
+
+// previousPath is where the binary being replaced is kept for rollback
+func (u *Updater) previousPath() string {
+	return u.binary + ".previous"
+}
+
+// keepPrevious links the running binary to the rollback path
+func (u *Updater) keepPrevious() error {
+	previous := u.previousPath()
+	if err := os.Remove(previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove old rollback binary: %w", err)
+	}
+	if err := os.Link(u.binary, previous); err != nil {
+		return fmt.Errorf("failed to keep rollback binary: %w", err)
+	}
+	return nil
+}
+
+// restorePrevious moves the rollback binary back into place
+func (u *Updater) restorePrevious() error {
+	if err := os.Rename(u.previousPath(), u.binary); err != nil {
+		return fmt.Errorf("failed to restore previous agent binary: %w", err)
+	}
+	return nil
+}
+
+// CheckTrial confirms a freshly installed version once lastHeartbeat reports
+// a successful heartbeat, or rolls back to the previous binary when the grace
+// period ends first. It returns immediately when no update is on trial.
+func (u *Updater) CheckTrial(ctx context.Context, lastHeartbeat func() time.Time) {
+	st, err := loadState(u.cfg.StateDir)
+	if err != nil {
+		log.WithError(err).Error("Failed to check agent update")
+		return
+	}
+	if !st.pending() {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{
+		"previous": st.PreviousVersion,
+		"target":   st.TargetVersion,
+	})
+
+	// Someone else restored the previous binary, so the target failed
+	if version.Version != st.TargetVersion {
+		logger.Warn("Agent update was reverted, not retrying this version")
+		st.markFailed(st.TargetVersion)
+		if err := st.save(u.cfg.StateDir); err != nil {
+			logger.WithError(err).Error("Failed to record reverted update")
+		}
+		os.Remove(u.previousPath())
+		removeTrialMarker(u.binary)
+		return
+	}
+
+	logger.WithField("deadline", *st.Deadline).Info("Agent update on trial, waiting for a heartbeat")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if !lastHeartbeat().IsZero() {
+			u.commit(st, logger)
+			return
+		}
+		if time.Now().After(*st.Deadline) {
+			u.rollback(st, logger)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// commit ends the trial and drops the rollback binary
+func (u *Updater) commit(st *state, logger *log.Entry) {
+	st.clearTrial()
+	if err := st.save(u.cfg.StateDir); err != nil {
+		logger.WithError(err).Error("Failed to confirm agent update")
+		return
+	}
+	removeTrialMarker(u.binary)
+	if err := os.Remove(u.previousPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WithError(err).Warn("Failed to remove rollback binary")
+	}
+	logger.Info("Agent update confirmed")
+}
+
+// rollback restores the previous binary, records the failed version and
+// restarts into the previous binary
+func (u *Updater) rollback(st *state, logger *log.Entry) {
+	logger.Error("No heartbeat within the grace period, rolling back agent update")
+
+	if err := u.restorePrevious(); err != nil {
+		logger.WithError(err).Error("Agent update rollback failed")
+		return
+	}
+	removeTrialMarker(u.binary)
+	st.markFailed(st.TargetVersion)
+	if err := st.save(u.cfg.StateDir); err != nil {
+		logger.WithError(err).Error("Failed to record rolled back update")
+	}
+
+	u.mu.Lock()
+	u.busy = true
+	u.mu.Unlock()
+	u.signalRestart()
+}
+
+// signalRestart asks the agent to stop and call Restart
+func (u *Updater) signalRestart() {
+	select {
+	case u.restartChan <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,8 @@
+// Package version holds the version of the running agent binary. Release
+// builds set it with:
+//
+//	-ldflags "-X github.com/yossibmoha/NinjaIT/agent/internal/version.Version=1.2.3"
+package version
+
+// Version is the running agent version
+var Version = "0.1.0"
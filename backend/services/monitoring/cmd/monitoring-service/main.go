@@ -9,10 +9,12 @@ import (
 	"syscall"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/api"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/inventory"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/policy"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/storage"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -25,6 +27,8 @@ func main() {
 	configFile := flag.String("config", "config.yaml", "Path to configuration file")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
 	version := flag.Bool("version", false, "Print version and exit")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration and exit")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration and where each value came from, then exit")
 	flag.Parse()
 
 	// Print version
@@ -33,6 +37,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *checkConfig || *printConfig {
+		os.Exit(checkConfiguration(*configFile, *printConfig))
+	}
+
 	// Initialize logger
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetOutput(os.Stdout)
@@ -72,13 +80,25 @@ func main() {
 
 	log.Info("InfluxDB storage initialized")
 
+	// Load agent policies
+	policyStore, err := policy.NewStore(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load agent policies")
+	}
+
+	// Load device inventories
+	inventoryStore, err := inventory.NewStore(cfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load device inventories")
+	}
+
 	// Initialize API server
-	apiServer := api.NewServer(cfg, influxStorage)
+	apiServer := api.NewServer(cfg, influxStorage, policyStore, inventoryStore, Version)
 
 	// Start API server in goroutine
 	go func() {
 		log.WithField("port", cfg.Server.Port).Info("Starting API server")
-		if err := apiServer.Start(); err != nil {
+		if err := apiServer.Start(ctx); err != nil {
 			log.WithError(err).Fatal("API server failed")
 		}
 	}()
@@ -90,11 +110,12 @@ func main() {
 
 	log.Info("Shutdown signal received, stopping service...")
 
-	// Cancel context
+	// Cancel context, disconnecting agent WebSockets
 	cancel()
 
-	// Graceful shutdown of API server
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Graceful shutdown of API server, after the readiness drain
+	drain := time.Duration(cfg.Server.ShutdownDelay) * time.Second
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), drain+10*time.Second)
 	defer shutdownCancel()
 
 	if err := apiServer.Shutdown(shutdownCtx); err != nil {
@@ -104,3 +125,23 @@ func main() {
 	log.Info("Monitoring service stopped")
 }
 
+// checkConfiguration validates the configuration, optionally printing every
+// effective setting with its source, and returns the process exit code
+func checkConfiguration(configFile string, printSettings bool) int {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid: %v\n", err)
+		return 1
+	}
+
+	if printSettings {
+		if err := cfg.PrintSettings(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Configuration is valid (%s)\n", configFile)
+	return 0
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/gofiber/websocket/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/policy"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/telemetry"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// agentConn is a connected agent WebSocket
+type agentConn struct {
+	target policy.Target
+	conn   *websocket.Conn
+	mu     sync.Mutex // serializes writes to conn
+}
+
+// pushTimeout bounds how long writing one message to an agent may take
+const pushTimeout = 10 * time.Second
+
+// send writes a message to the agent
+func (a *agentConn) send(msg models.AgentMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writeLocked(msg)
+}
+
+// writeLocked writes a message within pushTimeout; the caller must hold a.mu
+func (a *agentConn) writeLocked(msg models.AgentMessage) error {
+	if err := a.conn.SetWriteDeadline(time.Now().Add(pushTimeout)); err != nil {
+		return err
+	}
+	return a.conn.WriteJSON(msg)
+}
+
+// agentHub tracks connected agents and pushes policy changes to them
+type agentHub struct {
+	mu     sync.RWMutex
+	agents map[*agentConn]struct{}
+	store  *policy.Store
+}
+
+func newAgentHub(store *policy.Store) *agentHub {
+	hub := &agentHub{
+		agents: make(map[*agentConn]struct{}),
+		store:  store,
+	}
+	store.OnChange(hub.pushPolicies)
+	telemetry.RegisterWebSocketAgents(hub.count)
+	return hub
+}
+
+func (h *agentHub) add(a *agentConn) {
+	h.mu.Lock()
+	h.agents[a] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *agentHub) remove(a *agentConn) {
+	h.mu.Lock()
+	delete(h.agents, a)
+	h.mu.Unlock()
+}
+
+// closeAll disconnects every agent, e.g. when the service stops, so agents
+// reconnect rather than wait on a connection nobody serves
+func (h *agentHub) closeAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for a := range h.agents {
+		a.mu.Lock()
+		_ = a.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+		a.conn.Close()
+		a.mu.Unlock()
+	}
+}
+
+// count returns the number of connected agents
+func (h *agentHub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.agents)
+}
+
+// pushPolicies sends every connected agent its current effective policy.
+// Each agent is written to in its own goroutine, so a slow agent delays
+// neither the others nor the change that triggered the push.
+func (h *agentHub) pushPolicies() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for a := range h.agents {
+		go h.push(a)
+	}
+}
+
+// push sends an agent its effective policy. The policy is resolved while
+// holding the connection, so when changes follow each other quickly the
+// last message an agent receives is always the latest policy. An agent that
+// cannot be written to is disconnected; it fetches its policy on reconnect.
+func (h *agentHub) push(a *agentConn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	effective := h.store.Resolve(a.target)
+	if err := a.writeLocked(models.AgentMessage{Type: "policy", Policy: &effective}); err != nil {
+		log.WithError(err).WithField("device_id", a.target.DeviceID).Warn("Failed to push policy to agent, disconnecting it")
+		a.conn.Close()
+	}
+}
+
+// handleAgentUpgrade only lets WebSocket upgrade requests through to handleAgentSocket
+func (s *Server) handleAgentUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	if c.Query("device_id") == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "device_id query parameter is required",
+		})
+	}
+	return c.Next()
+}
+
+// handleAgentSocket sends the agent its policy on connect and keeps the
+// connection open so later policy changes can be pushed
+func (s *Server) handleAgentSocket(conn *websocket.Conn) {
+	agent := &agentConn{
+		target: policy.Target{
+			DeviceID: conn.Query("device_id"),
+			TenantID: conn.Query("tenant_id"),
+			Group:    conn.Query("group"),
+			OS:       conn.Query("os"),
+			Arch:     conn.Query("arch"),
+		},
+		conn: conn,
+	}
+
+	s.hub.add(agent)
+	defer s.hub.remove(agent)
+
+	log.WithField("device_id", agent.target.DeviceID).Info("Agent WebSocket connected")
+
+	effective := s.policies.Resolve(agent.target)
+	if err := agent.send(models.AgentMessage{Type: "policy", Policy: &effective}); err != nil {
+		log.WithError(err).Warn("Failed to send initial policy to agent")
+		return
+	}
+
+	// Agents do not send anything yet; reading detects disconnects
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			log.WithField("device_id", agent.target.DeviceID).Info("Agent WebSocket disconnected")
+			return
+		}
+	}
+}
+
+// handleGetAgentPolicy returns the effective policy for a device
+func (s *Server) handleGetAgentPolicy(c *fiber.Ctx) error {
+	effective := s.policies.Resolve(policy.Target{
+		DeviceID: c.Params("deviceId"),
+		TenantID: c.Query("tenant_id"),
+		Group:    c.Query("group"),
+		OS:       c.Query("os"),
+		Arch:     c.Query("arch"),
+	})
+	return c.JSON(effective)
+}
+
+// handleListPolicies lists all agent policies
+func (s *Server) handleListPolicies(c *fiber.Ctx) error {
+	policies := s.policies.List()
+	return c.JSON(fiber.Map{
+		"count":    len(policies),
+		"policies": policies,
+	})
+}
+
+// handlePutPolicy creates or replaces the policy for a scope and target
+func (s *Server) handlePutPolicy(c *fiber.Ctx) error {
+	var p models.AgentPolicy
+	if err := json.Unmarshal(c.Body(), &p); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	// Params point into the request buffer, so copy them before storing
+	p.Scope = utils.CopyString(c.Params("scope"))
+	p.Target = utils.CopyString(c.Params("target"))
+
+	stored, err := s.policies.Put(p)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"scope":   stored.Scope,
+		"target":  stored.Target,
+		"version": stored.Version,
+	}).Info("Agent policy updated")
+
+	return c.JSON(stored)
+}
+
+// handleDeletePolicy removes the policy for a scope and target
+func (s *Server) handleDeletePolicy(c *fiber.Ctx) error {
+	err := s.policies.Delete(c.Params("scope"), c.Params("target"))
+	if errors.Is(err, policy.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Policy not found",
+		})
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to delete policy")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete policy",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleListReleases lists published agent releases
+func (s *Server) handleListReleases(c *fiber.Ctx) error {
+	releases := s.policies.ListReleases()
+	return c.JSON(fiber.Map{
+		"count":    len(releases),
+		"releases": releases,
+	})
+}
+
+// handlePutRelease publishes the signed binaries of an agent version
+func (s *Server) handlePutRelease(c *fiber.Ctx) error {
+	var r models.AgentRelease
+	if err := json.Unmarshal(c.Body(), &r); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	r.Version = utils.CopyString(c.Params("version"))
+
+	stored, err := s.policies.PutRelease(r)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"version":   stored.Version,
+		"artifacts": len(stored.Artifacts),
+	}).Info("Agent release published")
+
+	return c.JSON(stored)
+}
+
+// handleDeleteRelease removes an agent release
+func (s *Server) handleDeleteRelease(c *fiber.Ctx) error {
+	err := s.policies.DeleteRelease(c.Params("version"))
+	if errors.Is(err, policy.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Release not found",
+		})
+	}
+	if errors.Is(err, policy.ErrReleaseInUse) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to delete release")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete release",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -2,30 +2,57 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/websocket/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/inventory"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/policy"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/remotewrite"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/storage"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/telemetry"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/validation"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
-	log "github.com/sirupsen/logrus"
 )
 
 // Server represents the API server
 type Server struct {
-	app     *fiber.App
-	config  *config.Config
-	storage *storage.InfluxDBStorage
+	app       *fiber.App
+	config    *config.Config
+	storage   *storage.InfluxDBStorage
+	validator *validation.Validator
+	mapper    *remotewrite.Mapper
+	policies  *policy.Store
+	inventory *inventory.Store
+	hub       *agentHub
+	version   string
+
+	shuttingDown atomic.Bool
+}
+
+// probePaths are served without API key auth or rate limiting
+var probePaths = map[string]bool{
+	"/health":  true,
+	"/livez":   true,
+	"/readyz":  true,
+	"/metrics": true,
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, storage *storage.InfluxDBStorage) *Server {
+func NewServer(cfg *config.Config, storage *storage.InfluxDBStorage, policies *policy.Store, inventories *inventory.Store, version string) *Server {
 	app := fiber.New(fiber.Config{
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
@@ -34,9 +61,15 @@ func NewServer(cfg *config.Config, storage *storage.InfluxDBStorage) *Server {
 	})
 
 	server := &Server{
-		app:     app,
-		config:  cfg,
-		storage: storage,
+		app:       app,
+		config:    cfg,
+		storage:   storage,
+		validator: validation.NewValidator(cfg),
+		mapper:    remotewrite.NewMapper(cfg),
+		policies:  policies,
+		inventory: inventories,
+		hub:       newAgentHub(policies),
+		version:   version,
 	}
 
 	// Setup middleware
@@ -53,6 +86,9 @@ func (s *Server) setupMiddleware() {
 	// Recover from panics
 	s.app.Use(recover.New())
 
+	// Request metrics
+	s.app.Use(instrumentRequests)
+
 	// Logger
 	s.app.Use(logger.New(logger.Config{
 		Format: "${time} | ${status} | ${latency} | ${method} ${path}\n",
@@ -63,7 +99,7 @@ func (s *Server) setupMiddleware() {
 		s.app.Use(cors.New(cors.Config{
 			AllowOrigins: "*",
 			AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-			AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-Key",
+			AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Admin-Key",
 		}))
 	}
 
@@ -76,10 +112,15 @@ func (s *Server) setupMiddleware() {
 	s.app.Use(limiter.New(limiter.Config{
 		Max:        s.config.Security.RateLimit,
 		Expiration: 1 * time.Minute,
+		Next: func(c *fiber.Ctx) bool {
+			// Never throttle probes or Prometheus scrapes
+			return probePaths[c.Path()]
+		},
 		KeyGenerator: func(c *fiber.Ctx) string {
 			return c.IP()
 		},
 		LimitReached: func(c *fiber.Ctx) error {
+			telemetry.RateLimitRejections.Inc()
 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 				"error": "Rate limit exceeded",
 			})
@@ -89,13 +130,14 @@ func (s *Server) setupMiddleware() {
 	// API Key authentication
 	if s.config.Security.APIKey != "" {
 		s.app.Use(func(c *fiber.Ctx) error {
-			// Skip auth for health check
-			if c.Path() == "/health" {
+			// Skip auth for probes and Prometheus scrapes
+			if probePaths[c.Path()] {
 				return c.Next()
 			}
 
 			apiKey := c.Get("X-API-Key")
-			if apiKey != s.config.Security.APIKey {
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(s.config.Security.APIKey.Value())) != 1 {
+				telemetry.AuthFailures.Inc()
 				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 					"error": "Invalid API key",
 				})
@@ -109,18 +151,81 @@ func (s *Server) setupMiddleware() {
 func (s *Server) setupRoutes() {
 	// Health check
 	s.app.Get("/health", s.handleHealth)
+	s.app.Get("/livez", s.handleLivez)
+	s.app.Get("/readyz", s.handleReadyz)
+
+	// Prometheus self-metrics
+	s.app.Get("/metrics", adaptor.HTTPHandler(promhttp.HandlerFor(telemetry.Registry, promhttp.HandlerOpts{})))
+
+	// Agent WebSocket for pushed policy updates
+	s.app.Use("/ws/agent", s.handleAgentUpgrade)
+	s.app.Get("/ws/agent", websocket.New(s.handleAgentSocket))
 
 	// API routes
 	api := s.app.Group("/api/v1")
-	
+
 	// Metrics endpoints
 	api.Post("/metrics", s.handleMetrics)
 	api.Post("/heartbeat", s.handleHeartbeat)
-	api.Get("/devices/:deviceId/metrics", s.handleGetMetrics)
-	api.Get("/devices/:deviceId/status", s.handleGetStatus)
-	
+	api.Post("/events", s.handleEvents)
+	api.Post("/inventory", s.handleInventory)
+	if s.config.RemoteWrite.Enabled {
+		api.Post("/prom/write", s.handleRemoteWrite)
+	}
+	api.Get("/devices/:deviceId/metrics", s.requireDeviceID, s.handleGetMetrics)
+	api.Get("/devices/:deviceId/status", s.requireDeviceID, s.handleGetStatus)
+	api.Get("/devices/:deviceId/services", s.requireDeviceID, s.handleGetServices)
+	api.Get("/devices/:deviceId/checks", s.requireDeviceID, s.handleGetChecks)
+	api.Get("/devices/:deviceId/events", s.requireDeviceID, s.handleGetEvents)
+	api.Get("/devices/:deviceId/inventory", s.requireDeviceID, s.handleGetInventory)
+	api.Get("/devices/:deviceId/changes", s.requireDeviceID, s.handleGetChanges)
+	api.Get("/devices/:deviceId/snapshots", s.requireDeviceID, s.handleGetSnapshots)
+	api.Get("/devices/:deviceId/snapshots/:version", s.requireDeviceID, s.handleGetSnapshot)
+	api.Get("/devices/:deviceId/diff", s.requireDeviceID, s.handleGetDiff)
+	api.Get("/devices/:deviceId/proxied", s.requireDeviceID, s.handleGetProxiedDevices)
+
 	// Stats endpoints
 	api.Get("/stats/devices", s.handleGetDeviceStats)
+	api.Get("/stats/ingest", s.handleGetIngestStats)
+
+	// Agent policy endpoints
+	api.Get("/agents/:deviceId/policy", s.requireDeviceID, s.handleGetAgentPolicy)
+	api.Get("/policies", s.handleListPolicies)
+	api.Put("/policies/:scope/:target", s.requireAdmin, s.handlePutPolicy)
+	api.Delete("/policies/:scope/:target", s.requireAdmin, s.handleDeletePolicy)
+	api.Get("/releases", s.handleListReleases)
+	api.Put("/releases/:version", s.requireAdmin, s.handlePutRelease)
+	api.Delete("/releases/:version", s.requireAdmin, s.handleDeleteRelease)
+}
+
+// requireDeviceID rejects requests whose :deviceId is not a valid device ID
+// before any handler places it in a query
+func (s *Server) requireDeviceID(c *fiber.Ctx) error {
+	if !validation.ValidDeviceID(c.Params("deviceId")) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid device ID",
+		})
+	}
+	return c.Next()
+}
+
+// requireAdmin only lets requests with the admin key through. Every agent
+// holds the API key, so changes to fleet policy and releases need their own
+// credential; without an admin key configured they are refused.
+func (s *Server) requireAdmin(c *fiber.Ctx) error {
+	adminKey := s.config.Security.AdminKey.Value()
+	if adminKey == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Admin API is disabled; set security.admin_key to enable it",
+		})
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Key")), []byte(adminKey)) != 1 {
+		telemetry.AuthFailures.Inc()
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid admin key",
+		})
+	}
+	return c.Next()
 }
 
 // handleHealth handles health check requests
@@ -128,7 +233,70 @@ func (s *Server) handleHealth(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"status":  "healthy",
 		"service": "monitoring",
-		"version": "0.1.0",
+		"version": s.version,
+	})
+}
+
+// handleLivez reports whether the process is alive; it never checks dependencies
+// so a storage outage does not cause Kubernetes to restart healthy pods
+func (s *Server) handleLivez(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"status":  "alive",
+		"service": "monitoring",
+		"version": s.version,
+	})
+}
+
+// dependencyStatus is the readiness result for a single dependency
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	InFlight  int64   `json:"in_flight,omitempty"`
+	Capacity  int     `json:"capacity,omitempty"`
+}
+
+// handleReadyz reports whether the service can accept traffic, returning 503 when
+// InfluxDB is unreachable, the write queue is saturated, or the server is shutting down
+func (s *Server) handleReadyz(c *fiber.Ctx) error {
+	ready := !s.shuttingDown.Load()
+	checks := map[string]dependencyStatus{}
+
+	// InfluxDB connectivity
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	influx := dependencyStatus{Status: "up"}
+	if err := s.storage.Ping(ctx); err != nil {
+		influx.Status = "down"
+		influx.Error = err.Error()
+		ready = false
+	}
+	influx.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	checks["influxdb"] = influx
+
+	// Write queue saturation
+	queue := dependencyStatus{
+		Status:   "ok",
+		InFlight: s.storage.InflightWrites(),
+		Capacity: s.config.Server.MaxInflightWrites,
+	}
+	if queue.InFlight >= int64(queue.Capacity) {
+		queue.Status = "saturated"
+		ready = false
+	}
+	checks["write_queue"] = queue
+
+	status, code := "ready", fiber.StatusOK
+	if !ready {
+		status, code = "not_ready", fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status":        status,
+		"shutting_down": s.shuttingDown.Load(),
+		"checks":        checks,
 	})
 }
 
@@ -136,14 +304,21 @@ func (s *Server) handleHealth(c *fiber.Ctx) error {
 func (s *Server) handleMetrics(c *fiber.Ctx) error {
 	var metrics models.SystemMetrics
 	if err := c.BodyParser(&metrics); err != nil {
+		s.validator.Reject(validation.KindMetrics)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
 
 	// Set timestamp if not provided
+	now := time.Now()
 	if metrics.Timestamp.IsZero() {
-		metrics.Timestamp = time.Now()
+		metrics.Timestamp = now
+	}
+
+	// Validate and sanitize before anything reaches storage
+	if err := s.validator.ValidateMetrics(&metrics, now); err != nil {
+		return validationError(c, err)
 	}
 
 	// Write to InfluxDB
@@ -157,6 +332,20 @@ func (s *Server) handleMetrics(c *fiber.Ctx) error {
 		})
 	}
 
+	// Track changes to the system information of the device itself; proxied
+	// devices report none worth versioning
+	if metrics.System != nil && metrics.ProxyID == "" {
+		changes, err := s.inventory.PutSystem(metrics.DeviceID, metrics.Timestamp, metrics.System)
+		if err != nil {
+			log.WithError(err).Error("Failed to store system information")
+		} else if len(changes) > 0 {
+			log.WithFields(log.Fields{
+				"device_id": metrics.DeviceID,
+				"changes":   len(changes),
+			}).Info("Device system information changed")
+		}
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "Metrics stored successfully",
@@ -167,14 +356,21 @@ func (s *Server) handleMetrics(c *fiber.Ctx) error {
 func (s *Server) handleHeartbeat(c *fiber.Ctx) error {
 	var heartbeat models.Heartbeat
 	if err := c.BodyParser(&heartbeat); err != nil {
+		s.validator.Reject(validation.KindHeartbeat)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
 
 	// Set timestamp if not provided
+	now := time.Now()
 	if heartbeat.Timestamp.IsZero() {
-		heartbeat.Timestamp = time.Now()
+		heartbeat.Timestamp = now
+	}
+
+	// Validate and sanitize before anything reaches storage
+	if err := s.validator.ValidateHeartbeat(&heartbeat, now); err != nil {
+		return validationError(c, err)
 	}
 
 	// Write to InfluxDB
@@ -194,6 +390,142 @@ func (s *Server) handleHeartbeat(c *fiber.Ctx) error {
 	})
 }
 
+// handleEvents handles batches of log events forwarded by agents
+func (s *Server) handleEvents(c *fiber.Ctx) error {
+	var batch models.EventBatch
+	if err := c.BodyParser(&batch); err != nil {
+		s.validator.Reject(validation.KindEvents)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Set timestamp if not provided
+	now := time.Now()
+	if batch.Timestamp.IsZero() {
+		batch.Timestamp = now
+	}
+
+	// Validate and sanitize before anything reaches storage
+	if err := s.validator.ValidateEvents(&batch, now); err != nil {
+		return validationError(c, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.storage.WriteEvents(ctx, &batch); err != nil {
+		log.WithError(err).Error("Failed to write events")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store events",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Events stored successfully",
+	})
+}
+
+// handleInventory handles device inventories, which agents send when they change
+func (s *Server) handleInventory(c *fiber.Ctx) error {
+	var inv models.Inventory
+	if err := c.BodyParser(&inv); err != nil {
+		s.validator.Reject(validation.KindInventory)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Set timestamp if not provided
+	now := time.Now()
+	if inv.Timestamp.IsZero() {
+		inv.Timestamp = now
+	}
+
+	// Validate and sanitize before anything is stored
+	if err := s.validator.ValidateInventory(&inv, now); err != nil {
+		return validationError(c, err)
+	}
+
+	changes, err := s.inventory.Put(&inv)
+	if err != nil {
+		log.WithError(err).Error("Failed to store inventory")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store inventory",
+		})
+	}
+
+	if len(changes) > 0 {
+		log.WithFields(log.Fields{
+			"device_id": inv.DeviceID,
+			"changes":   len(changes),
+		}).Info("Device inventory changed")
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Inventory stored successfully",
+		"changes": len(changes),
+	})
+}
+
+// handleRemoteWrite handles Prometheus remote-write requests
+func (s *Server) handleRemoteWrite(c *fiber.Ctx) error {
+	// Use the raw body: Prometheus sends Content-Encoding: snappy, which Fiber cannot decode itself
+	series, err := remotewrite.Decode(c.Request().Body(), remotewrite.Limits{
+		MaxSeries:       s.config.RemoteWrite.MaxSeries,
+		MaxDecodedBytes: s.config.Server.MaxRequestSize,
+	})
+	if err != nil {
+		s.validator.Reject(validation.KindRemote)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	samples, unmapped, droppedLabels := s.mapper.Map(series)
+	for i := 0; i < unmapped; i++ {
+		s.validator.Reject(validation.KindRemote)
+	}
+	if droppedLabels > 0 {
+		s.validator.Truncate(validation.KindRemote, "labels", droppedLabels)
+	}
+
+	// Drop individual invalid samples rather than failing the batch, since
+	// Prometheus would otherwise retry or discard all of it
+	now := time.Now()
+	valid := samples[:0]
+	for i := range samples {
+		if err := s.validator.ValidateRemoteSample(&samples[i], now); err != nil {
+			continue
+		}
+		valid = append(valid, samples[i])
+	}
+
+	if dropped := len(samples) - len(valid); dropped > 0 || unmapped > 0 || droppedLabels > 0 {
+		log.WithFields(log.Fields{
+			"unmapped_series": unmapped,
+			"invalid_samples": dropped,
+			"dropped_labels":  droppedLabels,
+		}).Debug("Dropped remote-write data")
+	}
+
+	if len(valid) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := s.storage.WriteRemoteSamples(ctx, valid); err != nil {
+			log.WithError(err).Error("Failed to write remote-write samples")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store samples",
+			})
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
 // handleGetMetrics retrieves metrics for a device
 func (s *Server) handleGetMetrics(c *fiber.Ctx) error {
 	deviceID := c.Params("deviceId")
@@ -244,6 +576,237 @@ func (s *Server) handleGetStatus(c *fiber.Ctx) error {
 	})
 }
 
+// handleGetServices retrieves the latest systemd unit states of a device
+func (s *Server) handleGetServices(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	services, err := s.storage.QueryServices(ctx, deviceID)
+	if err != nil {
+		log.WithError(err).Error("Failed to query services")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve services",
+		})
+	}
+
+	failed := 0
+	for _, service := range services {
+		if service["failed"] == true {
+			failed++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"count":     len(services),
+		"failed":    failed,
+		"services":  services,
+	})
+}
+
+// handleGetChecks retrieves the latest synthetic check results of a device
+func (s *Server) handleGetChecks(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checks, err := s.storage.QueryChecks(ctx, deviceID)
+	if err != nil {
+		log.WithError(err).Error("Failed to query checks")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve checks",
+		})
+	}
+
+	failing := 0
+	for _, check := range checks {
+		if check["success"] == false {
+			failing++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"count":     len(checks),
+		"failing":   failing,
+		"checks":    checks,
+	})
+}
+
+// handleGetInventory retrieves the latest inventory of a device
+func (s *Server) handleGetInventory(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+
+	inv, ok := s.inventory.Latest(deviceID)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No inventory reported for this device",
+		})
+	}
+
+	return c.JSON(inv)
+}
+
+// handleGetChanges retrieves the change timeline of a device, newest first
+func (s *Server) handleGetChanges(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "limit must be between 1 and 1000",
+		})
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "since must be an RFC 3339 time",
+			})
+		}
+	}
+
+	category := c.Query("category")
+	if category != "" && !inventory.IsCategory(category) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("unknown category %q", category),
+		})
+	}
+
+	revisions := s.inventory.Revisions(deviceID, since, category, limit)
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"count":     len(revisions),
+		"revisions": revisions,
+	})
+}
+
+// handleGetSnapshots lists the versions of a device that are still kept
+func (s *Server) handleGetSnapshots(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+
+	version, snapshots := s.inventory.Snapshots(deviceID)
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"version":   version,
+		"count":     len(snapshots),
+		"snapshots": snapshots,
+	})
+}
+
+// handleGetSnapshot retrieves one version of a device
+func (s *Server) handleGetSnapshot(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+	version, err := strconv.ParseInt(c.Params("version"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "version must be a number",
+		})
+	}
+
+	snapshot, ok := s.inventory.Snapshot(deviceID, version)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Snapshot not found",
+		})
+	}
+
+	return c.JSON(snapshot)
+}
+
+// handleGetDiff compares two versions of a device. Without to, the current
+// version is compared.
+func (s *Server) handleGetDiff(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+	current, _ := s.inventory.Snapshots(deviceID)
+
+	from, errFrom := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, errTo := current, error(nil)
+	if value := c.Query("to"); value != "" {
+		to, errTo = strconv.ParseInt(value, 10, 64)
+	}
+	if errFrom != nil || errTo != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "from and to must be version numbers",
+		})
+	}
+
+	before, okFrom := s.inventory.Snapshot(deviceID, from)
+	after, okTo := s.inventory.Snapshot(deviceID, to)
+	if !okFrom || !okTo {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Snapshot not found",
+		})
+	}
+
+	changes := inventory.DiffSnapshots(before, after)
+	if changes == nil {
+		changes = []models.InventoryChange{}
+	}
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"from":      from,
+		"to":        to,
+		"count":     len(changes),
+		"changes":   changes,
+	})
+}
+
+// handleGetEvents retrieves the latest log events of a device
+func (s *Server) handleGetEvents(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "limit must be between 1 and 1000",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := s.storage.QueryEvents(ctx, deviceID, limit)
+	if err != nil {
+		log.WithError(err).Error("Failed to query events")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve events",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"device_id": deviceID,
+		"count":     len(events),
+		"events":    events,
+	})
+}
+
+// handleGetProxiedDevices lists the devices an agent polls on their behalf,
+// such as SNMP devices
+func (s *Server) handleGetProxiedDevices(c *fiber.Ctx) error {
+	deviceID := c.Params("deviceId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	devices, err := s.storage.QueryProxiedDevices(ctx, deviceID)
+	if err != nil {
+		log.WithError(err).Error("Failed to query proxied devices")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve proxied devices",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"proxy_id": deviceID,
+		"count":    len(devices),
+		"devices":  devices,
+	})
+}
+
 // handleGetDeviceStats retrieves device statistics
 func (s *Server) handleGetDeviceStats(c *fiber.Ctx) error {
 	// TODO: Implement device statistics aggregation
@@ -254,18 +817,87 @@ func (s *Server) handleGetDeviceStats(c *fiber.Ctx) error {
 	})
 }
 
-// Start starts the API server
-func (s *Server) Start() error {
+// handleGetIngestStats reports ingest rejection and truncation counters
+func (s *Server) handleGetIngestStats(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"rejected":  s.validator.RejectedCounts(),
+		"truncated": s.validator.TruncatedCounts(),
+	})
+}
+
+// validationError writes a 422 response listing each invalid field
+func validationError(c *fiber.Ctx, err error) error {
+	fields, ok := err.(validation.Errors)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	log.WithField("fields", fields.Error()).Debug("Rejected invalid sample")
+
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error":  "Validation failed",
+		"fields": fields,
+	})
+}
+
+// Start starts the API server; agent WebSockets are closed once ctx is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	// WebSocket connections are hijacked from the HTTP server, so Shutdown
+	// does not close them; close them when the service stops instead
+	go func() {
+		<-ctx.Done()
+		s.hub.closeAll()
+	}()
+
 	addr := fmt.Sprintf(":%d", s.config.Server.Port)
 	log.WithField("address", addr).Info("API server listening")
 	return s.app.Listen(addr)
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. Readiness fails for
+// server.shutdown_delay seconds first, bounded by ctx, while requests are
+// still served, so load balancers polling /readyz stop routing new traffic
+// before the listener closes.
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+
+	if delay := time.Duration(s.config.Server.ShutdownDelay) * time.Second; delay > 0 {
+		log.WithField("delay", delay.String()).Info("Failing readiness before shutting down")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
 	return s.app.ShutdownWithContext(ctx)
 }
 
+// instrumentRequests records request count, size and latency per route
+func instrumentRequests(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// The error handler has not run yet, so derive the status it will send
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+
+	// Use the matched route pattern rather than the raw path to bound label cardinality
+	route := c.Route().Path
+	telemetry.HTTPRequests.WithLabelValues(route, c.Method(), strconv.Itoa(status)).Inc()
+	telemetry.HTTPRequestSize.WithLabelValues(route).Observe(float64(len(c.Body())))
+	telemetry.HTTPRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
 // customErrorHandler handles errors
 func customErrorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
@@ -274,10 +906,9 @@ func customErrorHandler(c *fiber.Ctx, err error) error {
 	}
 
 	return c.Status(code).JSON(fiber.Map{
-		"error":   err.Error(),
-		"code":    code,
-		"path":    c.Path(),
-		"method":  c.Method(),
+		"error":  err.Error(),
+		"code":   code,
+		"path":   c.Path(),
+		"method": c.Method(),
 	})
 }
-
@@ -1,11 +1,19 @@
+// Package config loads the monitoring service configuration. Each setting
+// starts at its built-in default, is overridden by its environment variable
+// (or a .env file) and then by the YAML config file, so the file wins. Load
+// logs a warning for every setting the file overrides although its
+// environment variable is set. Secret references are resolved last.
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v3"
+	log "github.com/sirupsen/logrus"
 )
 
 // Config holds the monitoring service configuration
@@ -13,36 +21,89 @@ type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	InfluxDB InfluxDBConfig `yaml:"influxdb"`
 	Security SecurityConfig `yaml:"security"`
+	Ingest   IngestConfig   `yaml:"ingest"`
+
+	RemoteWrite RemoteWriteConfig `yaml:"remote_write"`
+	Policy      PolicyConfig      `yaml:"policy"`
+	Inventory   InventoryConfig   `yaml:"inventory"`
+
+	// Sources records where each setting came from, keyed by YAML path.
+	// Settings not listed keep their built-in default.
+	Sources map[string]string `yaml:"-"`
 }
 
 // ServerConfig holds server settings
 type ServerConfig struct {
-	Port            int    `yaml:"port"`
-	ReadTimeout     int    `yaml:"read_timeout"`
-	WriteTimeout    int    `yaml:"write_timeout"`
-	MaxRequestSize  int    `yaml:"max_request_size"`
-	EnableCORS      bool   `yaml:"enable_cors"`
-	TrustedProxies  []string `yaml:"trusted_proxies"`
+	Port              int      `yaml:"port"`
+	ReadTimeout       int      `yaml:"read_timeout"`
+	WriteTimeout      int      `yaml:"write_timeout"`
+	MaxRequestSize    int      `yaml:"max_request_size"`
+	EnableCORS        bool     `yaml:"enable_cors"`
+	TrustedProxies    []string `yaml:"trusted_proxies"`
+	MaxInflightWrites int      `yaml:"max_inflight_writes"` // readiness fails at this many pending InfluxDB writes
+	ShutdownDelay     int      `yaml:"shutdown_delay"`      // seconds readiness fails before the listener stops on shutdown
 }
 
 // InfluxDBConfig holds InfluxDB connection settings
 type InfluxDBConfig struct {
-	URL             string `yaml:"url"`
-	Token           string `yaml:"token"`
-	Org             string `yaml:"org"`
-	Bucket          string `yaml:"bucket"`
-	RetentionDays   int    `yaml:"retention_days"`
-	BatchSize       int    `yaml:"batch_size"`
-	FlushInterval   int    `yaml:"flush_interval"` // seconds
+	URL           string `yaml:"url"`
+	Token         Secret `yaml:"token"`
+	Org           string `yaml:"org"`
+	Bucket        string `yaml:"bucket"`
+	RetentionDays int    `yaml:"retention_days"`
+	BatchSize     int    `yaml:"batch_size"`
+	FlushInterval int    `yaml:"flush_interval"` // seconds
 }
 
 // SecurityConfig holds security settings
 type SecurityConfig struct {
-	APIKey       string `yaml:"api_key"`
-	EnableTLS    bool   `yaml:"enable_tls"`
-	TLSCert      string `yaml:"tls_cert"`
-	TLSKey       string `yaml:"tls_key"`
-	RateLimit    int    `yaml:"rate_limit"` // requests per minute
+	APIKey    Secret `yaml:"api_key"`
+	AdminKey  Secret `yaml:"admin_key"` // required to change policies and releases
+	EnableTLS bool   `yaml:"enable_tls"`
+	TLSCert   string `yaml:"tls_cert"`
+	TLSKey    string `yaml:"tls_key"`
+	RateLimit int    `yaml:"rate_limit"` // requests per minute
+}
+
+// IngestConfig holds validation limits for ingested samples
+type IngestConfig struct {
+	MaxFutureSkew     int `yaml:"max_future_skew"` // seconds
+	MaxSampleAge      int `yaml:"max_sample_age"`  // seconds
+	MaxDisks          int `yaml:"max_disks"`
+	MaxCores          int `yaml:"max_cores"`
+	MaxServices       int `yaml:"max_services"` // systemd units per sample
+	MaxSensors        int `yaml:"max_sensors"`  // readings of each sensor kind per sample
+	MaxContainers     int `yaml:"max_containers"`
+	MaxChecks         int `yaml:"max_checks"`          // synthetic check results per sample
+	MaxScripts        int `yaml:"max_scripts"`         // check script results per sample
+	MaxPerfdata       int `yaml:"max_perfdata"`        // perfdata values per script result
+	MaxSNMPInterfaces int `yaml:"max_snmp_interfaces"` // interfaces per SNMP device sample
+	MaxSNMPValues     int `yaml:"max_snmp_values"`     // configured OID values per SNMP device sample
+	MaxEvents         int `yaml:"max_events"`          // log events per batch
+	MaxEventLength    int `yaml:"max_event_length"`    // bytes of a log event message
+	MaxPackages       int `yaml:"max_packages"`        // installed packages per inventory
+	MaxInventoryItems int `yaml:"max_inventory_items"` // memory modules, disks and NICs each per inventory
+	MaxTagLength      int `yaml:"max_tag_length"`
+}
+
+// RemoteWriteConfig holds Prometheus remote-write receiver settings
+type RemoteWriteConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	DeviceLabels []string `yaml:"device_labels"` // first label present on a series becomes its device ID
+	MaxSeries    int      `yaml:"max_series"`    // per request
+	MaxLabels    int      `yaml:"max_labels"`    // per series, beyond the metric name and device label
+}
+
+// PolicyConfig holds central agent policy settings
+type PolicyConfig struct {
+	File string `yaml:"file"` // JSON file policies are persisted to; empty keeps them in memory
+}
+
+// InventoryConfig holds device inventory settings
+type InventoryConfig struct {
+	Dir       string `yaml:"dir"`       // directory inventories are persisted to, one file per device; empty keeps them in memory
+	History   int    `yaml:"history"`   // inventory changes kept per device
+	Snapshots int    `yaml:"snapshots"` // full device snapshots kept per device, for diffing versions
 }
 
 // Load loads configuration from file or environment variables
@@ -50,45 +111,107 @@ func Load(configFile string) (*Config, error) {
 	// Try to load .env file
 	_ = godotenv.Load()
 
+	env := newEnvReader()
 	cfg := &Config{
 		Server: ServerConfig{
-			Port:            getEnvInt("MONITORING_PORT", 3002),
-			ReadTimeout:     getEnvInt("MONITORING_READ_TIMEOUT", 30),
-			WriteTimeout:    getEnvInt("MONITORING_WRITE_TIMEOUT", 30),
-			MaxRequestSize:  getEnvInt("MONITORING_MAX_REQUEST_SIZE", 10*1024*1024), // 10MB
-			EnableCORS:      getEnvBool("MONITORING_ENABLE_CORS", true),
-			TrustedProxies:  []string{},
+			Port:              env.Int("server.port", "MONITORING_PORT", 3002),
+			ReadTimeout:       env.Int("server.read_timeout", "MONITORING_READ_TIMEOUT", 30),
+			WriteTimeout:      env.Int("server.write_timeout", "MONITORING_WRITE_TIMEOUT", 30),
+			MaxRequestSize:    env.Int("server.max_request_size", "MONITORING_MAX_REQUEST_SIZE", 10*1024*1024), // 10MB
+			EnableCORS:        env.Bool("server.enable_cors", "MONITORING_ENABLE_CORS", true),
+			TrustedProxies:    []string{},
+			MaxInflightWrites: env.Int("server.max_inflight_writes", "MONITORING_MAX_INFLIGHT_WRITES", 256),
+			ShutdownDelay:     env.Int("server.shutdown_delay", "MONITORING_SHUTDOWN_DELAY", 5),
 		},
 		InfluxDB: InfluxDBConfig{
-			URL:           getEnv("INFLUXDB_URL", "http://localhost:8086"),
-			Token:         getEnv("INFLUXDB_TOKEN", ""),
-			Org:           getEnv("INFLUXDB_ORG", "ninjait"),
-			Bucket:        getEnv("INFLUXDB_BUCKET", "metrics"),
-			RetentionDays: getEnvInt("INFLUXDB_RETENTION_DAYS", 90),
-			BatchSize:     getEnvInt("INFLUXDB_BATCH_SIZE", 100),
-			FlushInterval: getEnvInt("INFLUXDB_FLUSH_INTERVAL", 10),
+			URL:           env.String("influxdb.url", "INFLUXDB_URL", "http://localhost:8086"),
+			Token:         env.Secret("influxdb.token", "INFLUXDB_TOKEN"),
+			Org:           env.String("influxdb.org", "INFLUXDB_ORG", "ninjait"),
+			Bucket:        env.String("influxdb.bucket", "INFLUXDB_BUCKET", "metrics"),
+			RetentionDays: env.Int("influxdb.retention_days", "INFLUXDB_RETENTION_DAYS", 90),
+			BatchSize:     env.Int("influxdb.batch_size", "INFLUXDB_BATCH_SIZE", 100),
+			FlushInterval: env.Int("influxdb.flush_interval", "INFLUXDB_FLUSH_INTERVAL", 10),
 		},
 		Security: SecurityConfig{
-			APIKey:    getEnv("MONITORING_API_KEY", ""),
-			EnableTLS: getEnvBool("MONITORING_ENABLE_TLS", false),
-			TLSCert:   getEnv("MONITORING_TLS_CERT", ""),
-			TLSKey:    getEnv("MONITORING_TLS_KEY", ""),
-			RateLimit: getEnvInt("MONITORING_RATE_LIMIT", 1000),
+			APIKey:    env.Secret("security.api_key", "MONITORING_API_KEY"),
+			AdminKey:  env.Secret("security.admin_key", "MONITORING_ADMIN_KEY"),
+			EnableTLS: env.Bool("security.enable_tls", "MONITORING_ENABLE_TLS", false),
+			TLSCert:   env.String("security.tls_cert", "MONITORING_TLS_CERT", ""),
+			TLSKey:    env.String("security.tls_key", "MONITORING_TLS_KEY", ""),
+			RateLimit: env.Int("security.rate_limit", "MONITORING_RATE_LIMIT", 1000),
+		},
+		Ingest: IngestConfig{
+			MaxFutureSkew:     env.Int("ingest.max_future_skew", "MONITORING_MAX_FUTURE_SKEW", 300),
+			MaxSampleAge:      env.Int("ingest.max_sample_age", "MONITORING_MAX_SAMPLE_AGE", 86400),
+			MaxDisks:          env.Int("ingest.max_disks", "MONITORING_MAX_DISKS", 64),
+			MaxCores:          env.Int("ingest.max_cores", "MONITORING_MAX_CORES", 1024),
+			MaxServices:       env.Int("ingest.max_services", "MONITORING_MAX_SERVICES", 256),
+			MaxSensors:        env.Int("ingest.max_sensors", "MONITORING_MAX_SENSORS", 256),
+			MaxContainers:     env.Int("ingest.max_containers", "MONITORING_MAX_CONTAINERS", 256),
+			MaxChecks:         env.Int("ingest.max_checks", "MONITORING_MAX_CHECKS", 256),
+			MaxScripts:        env.Int("ingest.max_scripts", "MONITORING_MAX_SCRIPTS", 256),
+			MaxPerfdata:       env.Int("ingest.max_perfdata", "MONITORING_MAX_PERFDATA", 64),
+			MaxSNMPInterfaces: env.Int("ingest.max_snmp_interfaces", "MONITORING_MAX_SNMP_INTERFACES", 1024),
+			MaxSNMPValues:     env.Int("ingest.max_snmp_values", "MONITORING_MAX_SNMP_VALUES", 256),
+			MaxEvents:         env.Int("ingest.max_events", "MONITORING_MAX_EVENTS", 1000),
+			MaxEventLength:    env.Int("ingest.max_event_length", "MONITORING_MAX_EVENT_LENGTH", 4096),
+			MaxPackages:       env.Int("ingest.max_packages", "MONITORING_MAX_PACKAGES", 20000),
+			MaxInventoryItems: env.Int("ingest.max_inventory_items", "MONITORING_MAX_INVENTORY_ITEMS", 256),
+			MaxTagLength:      env.Int("ingest.max_tag_length", "MONITORING_MAX_TAG_LENGTH", 256),
+		},
+		RemoteWrite: RemoteWriteConfig{
+			Enabled:      env.Bool("remote_write.enabled", "MONITORING_REMOTE_WRITE_ENABLED", false),
+			DeviceLabels: []string{"ninjait_device_id", "instance"},
+			MaxSeries:    env.Int("remote_write.max_series", "MONITORING_REMOTE_WRITE_MAX_SERIES", 10000),
+			MaxLabels:    env.Int("remote_write.max_labels", "MONITORING_REMOTE_WRITE_MAX_LABELS", 16),
 		},
+		Policy: PolicyConfig{
+			File: env.String("policy.file", "MONITORING_POLICY_FILE", ""),
+		},
+		Inventory: InventoryConfig{
+			Dir:       env.String("inventory.dir", "MONITORING_INVENTORY_DIR", ""),
+			History:   env.Int("inventory.history", "MONITORING_INVENTORY_HISTORY", 100),
+			Snapshots: env.Int("inventory.snapshots", "MONITORING_INVENTORY_SNAPSHOTS", 10),
+		},
+	}
+
+	if len(env.errs) > 0 {
+		return nil, fmt.Errorf("invalid environment: %w", errors.Join(env.errs...))
 	}
+	cfg.Sources = env.sources
 
-	// Try to load from YAML file if it exists
+	// Try to load from YAML file if it exists; it overrides the environment
 	if _, err := os.Stat(configFile); err == nil {
 		data, err := os.ReadFile(configFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		shadowed, err := decodeFile(data, cfg, cfg.Sources)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+		}
+		for _, s := range shadowed {
+			log.WithFields(log.Fields{
+				"setting":     s.Path,
+				"environment": strings.TrimPrefix(s.Source, SourceEnv+" "),
+				"file":        configFile,
+			}).Warn("Config file overrides a setting also set in the environment")
 		}
 	}
 
+	// Secrets may be references such as file:/run/secrets/token
+	var err error
+	if cfg.InfluxDB.Token, err = resolveSecret("influxdb.token", cfg.InfluxDB.Token); err != nil {
+		return nil, err
+	}
+	if cfg.Security.APIKey, err = resolveSecret("security.api_key", cfg.Security.APIKey); err != nil {
+		return nil, err
+	}
+	if cfg.Security.AdminKey, err = resolveSecret("security.admin_key", cfg.Security.AdminKey); err != nil {
+		return nil, err
+	}
+
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -102,8 +225,14 @@ func (c *Config) Validate() error {
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", c.Server.Port)
 	}
-	if c.InfluxDB.URL == "" {
-		return fmt.Errorf("InfluxDB URL is required")
+	if c.Server.MaxInflightWrites < 1 {
+		return fmt.Errorf("max inflight writes must be at least 1")
+	}
+	if c.Server.ShutdownDelay < 0 {
+		return fmt.Errorf("shutdown delay must not be negative")
+	}
+	if err := validateURL("InfluxDB URL", c.InfluxDB.URL); err != nil {
+		return err
 	}
 	if c.InfluxDB.Token == "" {
 		return fmt.Errorf("InfluxDB token is required")
@@ -114,31 +243,117 @@ func (c *Config) Validate() error {
 	if c.InfluxDB.Bucket == "" {
 		return fmt.Errorf("InfluxDB bucket is required")
 	}
+	if c.Security.EnableTLS {
+		if err := validateFile("TLS certificate", c.Security.TLSCert); err != nil {
+			return err
+		}
+		if err := validateFile("TLS key", c.Security.TLSKey); err != nil {
+			return err
+		}
+	}
+	if c.Security.AdminKey != "" && c.Security.AdminKey == c.Security.APIKey {
+		return fmt.Errorf("admin key must differ from the API key agents use")
+	}
+	if c.Ingest.MaxFutureSkew < 0 {
+		return fmt.Errorf("ingest max future skew must not be negative")
+	}
+	if c.Ingest.MaxSampleAge < 1 {
+		return fmt.Errorf("ingest max sample age must be at least 1 second")
+	}
+	if c.Ingest.MaxDisks < 1 {
+		return fmt.Errorf("ingest max disks must be at least 1")
+	}
+	if c.Ingest.MaxCores < 1 {
+		return fmt.Errorf("ingest max cores must be at least 1")
+	}
+	if c.Ingest.MaxServices < 1 {
+		return fmt.Errorf("ingest max services must be at least 1")
+	}
+	if c.Ingest.MaxSensors < 1 {
+		return fmt.Errorf("ingest max sensors must be at least 1")
+	}
+	if c.Ingest.MaxContainers < 1 {
+		return fmt.Errorf("ingest max containers must be at least 1")
+	}
+	if c.Ingest.MaxChecks < 1 {
+		return fmt.Errorf("ingest max checks must be at least 1")
+	}
+	if c.Ingest.MaxScripts < 1 {
+		return fmt.Errorf("ingest max scripts must be at least 1")
+	}
+	if c.Ingest.MaxPerfdata < 1 {
+		return fmt.Errorf("ingest max perfdata must be at least 1")
+	}
+	if c.Ingest.MaxSNMPInterfaces < 1 {
+		return fmt.Errorf("ingest max SNMP interfaces must be at least 1")
+	}
+	if c.Ingest.MaxSNMPValues < 1 {
+		return fmt.Errorf("ingest max SNMP values must be at least 1")
+	}
+	if c.Ingest.MaxEvents < 1 {
+		return fmt.Errorf("ingest max events must be at least 1")
+	}
+	if c.Ingest.MaxEventLength < 1 {
+		return fmt.Errorf("ingest max event length must be at least 1")
+	}
+	if c.Ingest.MaxPackages < 1 {
+		return fmt.Errorf("ingest max packages must be at least 1")
+	}
+	if c.Ingest.MaxInventoryItems < 1 {
+		return fmt.Errorf("ingest max inventory items must be at least 1")
+	}
+	if c.Ingest.MaxTagLength < 1 {
+		return fmt.Errorf("ingest max tag length must be at least 1")
+	}
+	if c.Inventory.History < 1 {
+		return fmt.Errorf("inventory history must be at least 1")
+	}
+	if c.Inventory.Snapshots < 1 {
+		return fmt.Errorf("inventory snapshots must be at least 1")
+	}
+	if c.RemoteWrite.Enabled {
+		if len(c.RemoteWrite.DeviceLabels) == 0 {
+			return fmt.Errorf("remote write requires at least one device label")
+		}
+		if c.RemoteWrite.MaxSeries < 1 {
+			return fmt.Errorf("remote write max series must be at least 1")
+		}
+		if c.RemoteWrite.MaxLabels < 0 {
+			return fmt.Errorf("remote write max labels must not be negative")
+		}
+	}
 	return nil
 }
 
-// Helper functions
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// validateURL checks that value is an absolute http(s) URL
+func validateURL(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", name)
 	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		var result int
-		if _, err := fmt.Sscanf(value, "%d", &result); err == nil {
-			return result
-		}
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", name, err)
 	}
-	return defaultValue
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s must use http or https, got %q", name, value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s must include a host, got %q", name, value)
+	}
+	return nil
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		return value == "true" || value == "1" || value == "yes"
+// validateFile checks that path names a readable regular file
+func validateFile(name, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s path is required when TLS is enabled", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", name, path, err)
 	}
-	return defaultValue
+	if info.IsDir() {
+		return fmt.Errorf("%s %s is a directory", name, path)
+	}
+	return nil
 }
-
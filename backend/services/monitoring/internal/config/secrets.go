@@ -0,0 +1,126 @@
+package config
+
+// This file is kept identical in the agent and the monitoring service
+// (agent/internal/config and backend/services/monitoring/internal/config);
+// so is secrets_test.go, and TestSecretsCopiesMatch fails when they drift.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// secretMask replaces secret values wherever they are formatted
+const secretMask = "********"
+
+// Secret is a sensitive configuration value. It always formats as a mask, so
+// it cannot leak through logs or configuration dumps; use Value to read it.
+type Secret string
+
+// Value returns the secret in plain text
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretMask
+}
+
+// GoString implements fmt.GoStringer so %#v is masked as well
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalJSON masks the secret in JSON output, including structured logs
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// MarshalYAML masks the secret in YAML output
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// SecretProvider resolves secret references of the form "<scheme>:<reference>"
+type SecretProvider interface {
+	Resolve(reference string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to SecretProvider
+type SecretProviderFunc func(reference string) (string, error)
+
+// Resolve implements SecretProvider
+func (f SecretProviderFunc) Resolve(reference string) (string, error) {
+	return f(reference)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = map[string]SecretProvider{
+		"file": SecretProviderFunc(readSecretFile),
+		"env":  SecretProviderFunc(readSecretEnv),
+	}
+)
+
+// RegisterSecretProvider makes secret values starting with "<scheme>:" resolve
+// through p. It must be called before the configuration is loaded. The
+// "literal" scheme is reserved.
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[scheme] = p
+}
+
+// literalScheme marks a secret value that must not be resolved, for secrets
+// that themselves start with a provider scheme such as "file:"
+const literalScheme = "literal"
+
+// resolveSecret replaces a "<scheme>:<reference>" value with the secret the
+// registered provider returns. Values without a registered scheme are literal,
+// and "literal:<value>" always stands for <value>.
+func resolveSecret(name string, s Secret) (Secret, error) {
+	scheme, reference, ok := strings.Cut(s.Value(), ":")
+	if !ok {
+		return s, nil
+	}
+	if scheme == literalScheme {
+		return Secret(reference), nil
+	}
+
+	secretProvidersMu.RLock()
+	provider, ok := secretProviders[scheme]
+	secretProvidersMu.RUnlock()
+	if !ok {
+		return s, nil
+	}
+
+	value, err := provider.Resolve(reference)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s from %s provider: %w", name, scheme, err)
+	}
+	return Secret(value), nil
+}
+
+// readSecretFile reads a secret from a file such as a Kubernetes secret
+// mount, ignoring the trailing newline most tools write
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func readSecretEnv(key string) (string, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", key)
+	}
+	return value, nil
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting sources, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+)
+
+// EnvError reports an environment variable whose value cannot be parsed
+type EnvError struct {
+	Key   string
+	Value string
+	Type  string
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("environment variable %s=%q is not a valid %s", e.Key, e.Value, e.Type)
+}
+
+// envReader reads environment overrides, recording which settings they set
+// and collecting values that fail to parse
+type envReader struct {
+	sources map[string]string
+	errs    []error
+}
+
+func newEnvReader() *envReader {
+	return &envReader{sources: make(map[string]string)}
+}
+
+func (r *envReader) String(path, key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		r.sources[path] = SourceEnv + " " + key
+		return value
+	}
+	return defaultValue
+}
+
+func (r *envReader) Int(path, key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	result, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		r.errs = append(r.errs, &EnvError{Key: key, Value: value, Type: "integer"})
+		return defaultValue
+	}
+	r.sources[path] = SourceEnv + " " + key
+	return result
+}
+
+func (r *envReader) Bool(path, key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "on":
+		r.sources[path] = SourceEnv + " " + key
+		return true
+	case "false", "0", "no", "off":
+		r.sources[path] = SourceEnv + " " + key
+		return false
+	}
+	r.errs = append(r.errs, &EnvError{Key: key, Value: value, Type: "boolean"})
+	return defaultValue
+}
+
+// Secret reads key, or the file named by key_FILE such as a mounted Kubernetes secret
+func (r *envReader) Secret(path, key string) Secret {
+	value := os.Getenv(key)
+	file := os.Getenv(key + "_FILE")
+
+	switch {
+	case value != "" && file != "":
+		r.errs = append(r.errs, fmt.Errorf("only one of %s and %s_FILE may be set", key, key))
+	case file != "":
+		secret, err := readSecretFile(file)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("failed to read %s_FILE: %w", key, err))
+			return ""
+		}
+		r.sources[path] = SourceEnv + " " + key + "_FILE"
+		return Secret(secret)
+	case value != "":
+		r.sources[path] = SourceEnv + " " + key
+		return Secret(value)
+	}
+	return ""
+}
+
+// decodeFile strictly decodes YAML over cfg, rejecting unknown keys, and
+// records every setting the file defines. It returns the settings the file
+// overrides although an environment variable set them, with their previous
+// source.
+func decodeFile(data []byte, cfg *Config, sources map[string]string) ([]Setting, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			// Empty file
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var shadowed []Setting
+	if len(doc.Content) > 0 {
+		markFileSettings(doc.Content[0], "", sources, &shadowed)
+	}
+	return shadowed, nil
+}
+
+func markFileSettings(node *yaml.Node, prefix string, sources map[string]string, shadowed *[]Setting) {
+	if node.Kind != yaml.MappingNode {
+		if prefix != "" {
+			if source := sources[prefix]; strings.HasPrefix(source, SourceEnv+" ") {
+				*shadowed = append(*shadowed, Setting{Path: prefix, Source: source})
+			}
+			sources[prefix] = SourceFile
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		path := node.Content[i].Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		markFileSettings(node.Content[i+1], path, sources, shadowed)
+	}
+}
+
+// Setting is a single effective configuration value and where it came from
+type Setting struct {
+	Path   string
+	Value  string
+	Source string
+}
+
+// Settings lists every configuration value in declaration order. Secrets are
+// masked by their String method.
+func (c *Config) Settings() []Setting {
+	var settings []Setting
+	walkSettings(reflect.ValueOf(*c), "", func(path string, value reflect.Value) {
+		s := Setting{
+			Path:   path,
+			Value:  fmt.Sprint(value.Interface()),
+			Source: c.Sources[path],
+		}
+		if s.Source == "" {
+			s.Source = SourceDefault
+		}
+		settings = append(settings, s)
+	})
+	return settings
+}
+
+// PrintSettings writes every effective setting and its source to w
+func (c *Config) PrintSettings(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range c.Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Path, s.Value, s.Source)
+	}
+	return tw.Flush()
+}
+
+func walkSettings(v reflect.Value, prefix string, fn func(path string, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if v.Field(i).Kind() == reflect.Struct {
+			walkSettings(v.Field(i), path, fn)
+			continue
+		}
+		fn(path, v.Field(i))
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeFileShadowedEnv(t *testing.T) {
+	t.Setenv("MONITORING_PORT", "4000")
+	t.Setenv("MONITORING_RATE_LIMIT", "50")
+	t.Setenv("INFLUXDB_ORG", "from-env")
+
+	env := newEnvReader()
+	cfg := &Config{
+		Server:   ServerConfig{Port: env.Int("server.port", "MONITORING_PORT", 3002)},
+		InfluxDB: InfluxDBConfig{Org: env.String("influxdb.org", "INFLUXDB_ORG", "ninjait")},
+		Security: SecurityConfig{RateLimit: env.Int("security.rate_limit", "MONITORING_RATE_LIMIT", 1000)},
+	}
+
+	shadowed, err := decodeFile([]byte("server:\n  port: 5000\n  read_timeout: 10\ninfluxdb:\n  org: from-file\n"), cfg, env.sources)
+	if err != nil {
+		t.Fatalf("decodeFile() error = %v", err)
+	}
+
+	want := []Setting{
+		{Path: "server.port", Source: "env MONITORING_PORT"},
+		{Path: "influxdb.org", Source: "env INFLUXDB_ORG"},
+	}
+	if !reflect.DeepEqual(shadowed, want) {
+		t.Errorf("decodeFile() shadowed = %+v, want %+v", shadowed, want)
+	}
+	if cfg.Server.Port != 5000 || cfg.InfluxDB.Org != "from-file" || cfg.Security.RateLimit != 50 {
+		t.Errorf("decodeFile() config = %+v", cfg)
+	}
+
+	wantSources := map[string]string{
+		"server.port":         SourceFile,
+		"server.read_timeout": SourceFile,
+		"influxdb.org":        SourceFile,
+		"security.rate_limit": "env MONITORING_RATE_LIMIT",
+	}
+	if !reflect.DeepEqual(env.sources, wantSources) {
+		t.Errorf("decodeFile() sources = %v, want %v", env.sources, wantSources)
+	}
+}
+
+func TestDecodeFileRejectsUnknownKeys(t *testing.T) {
+	if _, err := decodeFile([]byte("server:\n  prot: 5000\n"), &Config{}, map[string]string{}); err == nil {
+		t.Error("decodeFile() accepted an unknown key")
+	}
+	if shadowed, err := decodeFile(nil, &Config{}, map[string]string{}); err != nil || shadowed != nil {
+		t.Errorf("decodeFile() of an empty file = %v, %v", shadowed, err)
+	}
+}
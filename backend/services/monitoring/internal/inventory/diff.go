@@ -0,0 +1,227 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// Change actions
+const (
+	ActionAdded   = "added"
+	ActionRemoved = "removed"
+	ActionChanged = "changed"
+)
+
+// systemCategories lists the system information categories in the order
+// changes are reported
+var systemCategories = []string{"hostname", "os", "kernel"}
+
+// categories lists the inventory categories in the order changes are reported
+var categories = []string{"system", "bios", "cpu", "memory", "disk", "nic", "package"}
+
+// IsCategory reports whether name is a category changes are reported in
+func IsCategory(name string) bool {
+	return slices.Contains(systemCategories, name) || slices.Contains(categories, name)
+}
+
+// DiffSnapshots returns what changed from one snapshot to another: system
+// information first, then the inventory
+func DiffSnapshots(old, new *models.InventorySnapshot) []models.InventoryChange {
+	return append(DiffSystem(old.System, new.System), Diff(old.Inventory, new.Inventory)...)
+}
+
+// DiffSystem returns what changed in a device's system information. Uptime,
+// boot time and the processor count are not compared; the inventory covers
+// the processors.
+func DiffSystem(old, new *models.SystemInfo) []models.InventoryChange {
+	return diffCategories(systemCategories, describeSystem(old), describeSystem(new))
+}
+
+// Diff returns what changed from old to new. Each item is compared by a
+// short description, so a change to any described attribute reports the item
+// as changed with both descriptions. A nil inventory has no items.
+func Diff(old, new *models.Inventory) []models.InventoryChange {
+	return diffCategories(categories, describe(old), describe(new))
+}
+
+// diffCategories compares described items category by category
+func diffCategories(categories []string, before, after map[string]map[string]string) []models.InventoryChange {
+	var changes []models.InventoryChange
+	for _, category := range categories {
+		changes = append(changes, diffItems(category, before[category], after[category])...)
+	}
+	return changes
+}
+
+// diffItems compares the items of one category, keyed by identity
+func diffItems(category string, before, after map[string]string) []models.InventoryChange {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []models.InventoryChange
+	for _, key := range keys {
+		old, hadOld := before[key]
+		new, hasNew := after[key]
+		change := models.InventoryChange{Category: category, Item: key, Before: old, After: new}
+		switch {
+		case !hadOld:
+			change.Action = ActionAdded
+		case !hasNew:
+			change.Action = ActionRemoved
+		case old != new:
+			change.Action = ActionChanged
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// describeSystem describes the compared system information by category
+func describeSystem(info *models.SystemInfo) map[string]map[string]string {
+	items := newItems(systemCategories)
+	if info == nil {
+		return items
+	}
+
+	if info.Hostname != "" {
+		items["hostname"]["hostname"] = info.Hostname
+	}
+	if os := join(" ", info.Platform, info.PlatformVersion); os != "" {
+		items["os"]["os"] = os
+	} else if info.OS != "" {
+		items["os"]["os"] = info.OS
+	}
+	if kernel := join(" ", info.KernelVersion, info.KernelArch); kernel != "" {
+		items["kernel"]["kernel"] = kernel
+	}
+	return items
+}
+
+// describe maps every item of an inventory to a description, by category and
+// by the key that identifies the item across inventories
+func describe(inv *models.Inventory) map[string]map[string]string {
+	items := newItems(categories)
+	if inv == nil {
+		return items
+	}
+	hw := inv.Hardware
+
+	if system := join(" ", hw.System.Manufacturer, hw.System.Product); system != "" || hw.System.Serial != "" {
+		if hw.System.Serial != "" {
+			system = join(", ", system, "serial "+hw.System.Serial)
+		}
+		items["system"]["system"] = system
+	}
+	if bios := join(" ", hw.BIOS.Vendor, hw.BIOS.Version); bios != "" {
+		if hw.BIOS.Date != "" {
+			bios += " (" + hw.BIOS.Date + ")"
+		}
+		items["bios"]["bios"] = bios
+	}
+	if hw.CPU.Model != "" || hw.CPU.Threads > 0 {
+		cpu := join(", ", hw.CPU.Model, fmt.Sprintf("%d cores", hw.CPU.Cores), fmt.Sprintf("%d threads", hw.CPU.Threads))
+		if hw.CPU.Sockets > 1 {
+			cpu = join(", ", cpu, fmt.Sprintf("%d sockets", hw.CPU.Sockets))
+		}
+		items["cpu"]["cpu"] = cpu
+	}
+
+	for i, module := range hw.Memory {
+		key := module.Locator
+		if key == "" {
+			key = fmt.Sprintf("slot %d", i)
+		}
+		var speed string
+		if module.Speed > 0 {
+			speed = fmt.Sprintf("%d MT/s", module.Speed)
+		}
+		description := join(" ", formatSize(module.Size), module.Type, speed, module.Manufacturer, module.PartNumber)
+		if module.Serial != "" {
+			description = join(", ", description, "serial "+module.Serial)
+		}
+		items["memory"][key] = description
+	}
+
+	for _, disk := range hw.Disks {
+		// A disk keeps its serial when the kernel names it differently after a reboot
+		key := disk.Serial
+		if key == "" {
+			key = disk.Name
+		}
+		kind := "SSD"
+		if disk.Rotational {
+			kind = "HDD"
+		}
+		items["disk"][key] = join(", ", disk.Name, disk.Model, formatSize(disk.Size), kind)
+	}
+
+	for _, nic := range hw.NICs {
+		var mtu, speed string
+		if nic.MTU > 0 {
+			mtu = fmt.Sprintf("MTU %d", nic.MTU)
+		}
+		if nic.SpeedMbps > 0 {
+			speed = fmt.Sprintf("%d Mb/s", nic.SpeedMbps)
+		}
+		items["nic"][nic.Name] = join(", ", nic.MAC, mtu, speed, nic.Driver)
+	}
+
+	for _, pkg := range inv.Packages {
+		key := pkg.Name
+		if pkg.Arch != "" {
+			key += ":" + pkg.Arch
+		}
+		items["package"][key] = pkg.Version
+	}
+
+	return items
+}
+
+// newItems creates an empty item map for each category
+func newItems(categories []string) map[string]map[string]string {
+	items := make(map[string]map[string]string, len(categories))
+	for _, category := range categories {
+		items[category] = make(map[string]string)
+	}
+	return items
+}
+
+// join joins the non-empty parts with sep
+func join(sep string, parts ...string) string {
+	nonEmpty := parts[:0:0]
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+// formatSize formats a size in bytes with a binary unit
+func formatSize(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value == float64(int64(value)) {
+		return fmt.Sprintf("%d %s", int64(value), units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,189 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// testInventory returns an inventory with one item in every category
+func testInventory() *models.Inventory {
+	return &models.Inventory{
+		DeviceID: "dev-1",
+		Hardware: models.HardwareInventory{
+			CPU:    models.CPUInventory{Model: "Xeon E-2236", Sockets: 1, Cores: 6, Threads: 12},
+			Memory: []models.MemoryModule{{Locator: "DIMM_A1", Size: 16 << 30, Type: "DDR4", Speed: 2666}},
+			Disks:  []models.DiskInventory{{Name: "sda", Model: "Samsung SSD", Serial: "S1", Size: 512 << 30}},
+			NICs:   []models.NICInventory{{Name: "eth0", MAC: "00:11:22:33:44:55", MTU: 1500, SpeedMbps: 1000}},
+			BIOS:   models.BIOSInventory{Vendor: "Dell", Version: "2.1.0", Date: "2023-01-01"},
+			System: models.ProductInventory{Manufacturer: "Dell", Product: "R240", Serial: "ABC"},
+		},
+		Packages: []models.Package{
+			{Name: "openssl", Version: "3.0.2", Arch: "amd64", Manager: "dpkg"},
+			{Name: "curl", Version: "7.81", Arch: "amd64", Manager: "dpkg"},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    *models.Inventory
+		modify func(inv *models.Inventory)
+		want   []models.InventoryChange
+	}{
+		{
+			name:   "unchanged",
+			old:    testInventory(),
+			modify: func(inv *models.Inventory) {},
+		},
+		{
+			name:   "order of items does not matter",
+			old:    testInventory(),
+			modify: func(inv *models.Inventory) { inv.Packages[0], inv.Packages[1] = inv.Packages[1], inv.Packages[0] },
+		},
+		{
+			name: "package upgraded, added and removed",
+			old:  testInventory(),
+			modify: func(inv *models.Inventory) {
+				inv.Packages = []models.Package{
+					{Name: "openssl", Version: "3.0.13", Arch: "amd64"},
+					{Name: "zlib", Version: "1.3", Arch: "amd64"},
+				}
+			},
+			want: []models.InventoryChange{
+				{Category: "package", Item: "curl:amd64", Action: ActionRemoved, Before: "7.81"},
+				{Category: "package", Item: "openssl:amd64", Action: ActionChanged, Before: "3.0.2", After: "3.0.13"},
+				{Category: "package", Item: "zlib:amd64", Action: ActionAdded, After: "1.3"},
+			},
+		},
+		{
+			name: "disk renamed after a reboot",
+			old:  testInventory(),
+			modify: func(inv *models.Inventory) {
+				inv.Hardware.Disks[0].Name = "sdb"
+			},
+			want: []models.InventoryChange{
+				{Category: "disk", Item: "S1", Action: ActionChanged, Before: "sda, Samsung SSD, 512 GiB, SSD", After: "sdb, Samsung SSD, 512 GiB, SSD"},
+			},
+		},
+		{
+			name: "hardware changes in category order",
+			old:  testInventory(),
+			modify: func(inv *models.Inventory) {
+				inv.Hardware.NICs[0].SpeedMbps = 0
+				inv.Hardware.Memory = append(inv.Hardware.Memory, models.MemoryModule{Size: 8 << 30})
+				inv.Hardware.BIOS.Version = "2.2.0"
+			},
+			want: []models.InventoryChange{
+				{Category: "bios", Item: "bios", Action: ActionChanged, Before: "Dell 2.1.0 (2023-01-01)", After: "Dell 2.2.0 (2023-01-01)"},
+				{Category: "memory", Item: "slot 1", Action: ActionAdded, After: "8 GiB"},
+				{Category: "nic", Item: "eth0", Action: ActionChanged, Before: "00:11:22:33:44:55, MTU 1500, 1000 Mb/s", After: "00:11:22:33:44:55, MTU 1500"},
+			},
+		},
+		{
+			name: "first inventory",
+			old:  nil,
+			modify: func(inv *models.Inventory) {
+				inv.Hardware = models.HardwareInventory{CPU: models.CPUInventory{Model: "ARM", Cores: 4, Threads: 4}}
+				inv.Packages = inv.Packages[:1]
+			},
+			want: []models.InventoryChange{
+				{Category: "cpu", Item: "cpu", Action: ActionAdded, After: "ARM, 4 cores, 4 threads"},
+				{Category: "package", Item: "openssl:amd64", Action: ActionAdded, After: "3.0.2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			new := testInventory()
+			tt.modify(new)
+			if got := Diff(tt.old, new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSystem(t *testing.T) {
+	old := &models.SystemInfo{OS: "linux", Platform: "ubuntu", PlatformVersion: "22.04", KernelVersion: "5.15.0", KernelArch: "x86_64", Hostname: "web1", Uptime: 100, NumProcs: 200}
+
+	tests := []struct {
+		name string
+		old  *models.SystemInfo
+		new  *models.SystemInfo
+		want []models.InventoryChange
+	}{
+		{
+			name: "uptime and process count are ignored",
+			old:  old,
+			new:  &models.SystemInfo{OS: "linux", Platform: "ubuntu", PlatformVersion: "22.04", KernelVersion: "5.15.0", KernelArch: "x86_64", Hostname: "web1", Uptime: 9000, NumProcs: 250},
+		},
+		{
+			name: "upgrade and rename",
+			old:  old,
+			new:  &models.SystemInfo{OS: "linux", Platform: "ubuntu", PlatformVersion: "24.04", KernelVersion: "6.8.0", KernelArch: "x86_64", Hostname: "web2"},
+			want: []models.InventoryChange{
+				{Category: "hostname", Item: "hostname", Action: ActionChanged, Before: "web1", After: "web2"},
+				{Category: "os", Item: "os", Action: ActionChanged, Before: "ubuntu 22.04", After: "ubuntu 24.04"},
+				{Category: "kernel", Item: "kernel", Action: ActionChanged, Before: "5.15.0 x86_64", After: "6.8.0 x86_64"},
+			},
+		},
+		{
+			name: "OS without a platform",
+			old:  nil,
+			new:  &models.SystemInfo{OS: "windows", Hostname: "pc1"},
+			want: []models.InventoryChange{
+				{Category: "hostname", Item: "hostname", Action: ActionAdded, After: "pc1"},
+				{Category: "os", Item: "os", Action: ActionAdded, After: "windows"},
+			},
+		},
+		{
+			name: "both missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffSystem(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffSystem() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	old := &models.InventorySnapshot{System: &models.SystemInfo{Hostname: "web1"}, Inventory: testInventory()}
+	new := &models.InventorySnapshot{System: &models.SystemInfo{Hostname: "web2"}, Inventory: testInventory()}
+	new.Inventory.Packages = new.Inventory.Packages[:1]
+
+	want := []models.InventoryChange{
+		{Category: "hostname", Item: "hostname", Action: ActionChanged, Before: "web1", After: "web2"},
+		{Category: "package", Item: "curl:amd64", Action: ActionRemoved, Before: "7.81"},
+	}
+	if got := DiffSnapshots(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffSnapshots() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes uint64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{16 << 30, "16 GiB"},
+		{3 << 50, "3 PiB"},
+		{5 << 60, "5120 PiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatSize(tt.bytes); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package inventory keeps what is known about each device's configuration:
+// the system information it reports with its metrics and its hardware and
+// software inventory. Every change produces a new version, with a snapshot
+// of the device and a list of what changed.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// Store keeps device snapshots and changes in memory, optionally persisted
+// to a directory with one JSON file per device
+type Store struct {
+	mu        sync.RWMutex
+	dir       string
+	history   int
+	snapshots int
+	devices   map[string]*record
+}
+
+// record is what is kept for, and persisted of, one device
+type record struct {
+	DeviceID  string                     `json:"device_id"`
+	Version   int64                      `json:"version"`
+	System    *models.SystemInfo         `json:"system,omitempty"`
+	Latest    *models.Inventory          `json:"latest,omitempty"`
+	Revisions []models.InventoryRevision `json:"revisions,omitempty"` // oldest first
+	Snapshots []models.InventorySnapshot `json:"snapshots,omitempty"` // oldest first
+}
+
+// NewStore creates an inventory store, loading the records persisted in the
+// configured directory
+func NewStore(cfg *config.Config) (*Store, error) {
+	s := &Store{
+		dir:       cfg.Inventory.Dir,
+		history:   cfg.Inventory.History,
+		snapshots: cfg.Inventory.Snapshots,
+		devices:   make(map[string]*record),
+	}
+
+	if s.dir == "" {
+		log.Warn("No inventory directory configured, device inventories will not survive restarts")
+		return s, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inventory directory: %w", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory file: %w", err)
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse inventory file %s: %w", file, err)
+		}
+		if rec.DeviceID == "" && rec.Latest != nil {
+			// Written before system information was tracked
+			rec.DeviceID = rec.Latest.DeviceID
+		}
+		if rec.DeviceID == "" {
+			continue
+		}
+		s.devices[rec.DeviceID] = &rec
+	}
+
+	log.WithField("devices", len(s.devices)).Info("Device inventories loaded")
+	return s, nil
+}
+
+// Put stores inv as the device's latest inventory and returns how it differs
+// from the previous one. The first inventory of a device has no changes.
+func (s *Store) Put(inv *models.Inventory) ([]models.InventoryChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.recordLocked(inv.DeviceID)
+	var changes []models.InventoryChange
+	if rec.Latest != nil {
+		changes = Diff(rec.Latest, inv)
+		if len(changes) == 0 {
+			// Sent again after an agent restart; nothing to record
+			return nil, nil
+		}
+	}
+
+	next := *rec
+	next.Latest = inv
+	if err := s.commitLocked(&next, inv.Timestamp, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// PutSystem records the system information a device reported with its
+// metrics and returns what changed. Only a change produces a new version, so
+// most samples leave the store untouched.
+func (s *Store) PutSystem(deviceID string, ts time.Time, info *models.SystemInfo) ([]models.InventoryChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.recordLocked(deviceID)
+	var changes []models.InventoryChange
+	if rec.System != nil {
+		changes = DiffSystem(rec.System, info)
+		if len(changes) == 0 {
+			return nil, nil
+		}
+	}
+
+	next := *rec
+	next.System = info
+	if err := s.commitLocked(&next, ts, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// recordLocked returns the device's record, or an empty one for a new device
+func (s *Store) recordLocked(deviceID string) *record {
+	if rec, ok := s.devices[deviceID]; ok {
+		return rec
+	}
+	return &record{DeviceID: deviceID}
+}
+
+// commitLocked makes rec the device's next version, recording changes and a
+// snapshot, and persists it
+func (s *Store) commitLocked(rec *record, ts time.Time, changes []models.InventoryChange) error {
+	rec.Version++
+	if len(changes) > 0 {
+		rec.Revisions = appendBounded(rec.Revisions, models.InventoryRevision{
+			Version:   rec.Version,
+			Timestamp: ts,
+			Changes:   changes,
+		}, s.history)
+	}
+	rec.Snapshots = appendBounded(rec.Snapshots, models.InventorySnapshot{
+		Version:   rec.Version,
+		Timestamp: ts,
+		System:    rec.System,
+		Inventory: rec.Latest,
+	}, s.snapshots)
+
+	if err := s.persistLocked(rec); err != nil {
+		return err
+	}
+	s.devices[rec.DeviceID] = rec
+	return nil
+}
+
+// appendBounded appends item and drops the oldest entries beyond max. It
+// copies rather than appending in place, as the slice may be shared with the
+// record being replaced.
+func appendBounded[T any](list []T, item T, max int) []T {
+	if excess := len(list) + 1 - max; excess > 0 {
+		list = list[excess:]
+	}
+	next := make([]T, 0, len(list)+1)
+	return append(append(next, list...), item)
+}
+
+// Latest returns the most recent inventory of a device
+func (s *Store) Latest(deviceID string) (*models.Inventory, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.devices[deviceID]
+	if !ok || rec.Latest == nil {
+		return nil, false
+	}
+	return rec.Latest, true
+}
+
+// Revisions returns up to limit of the device's changes since the given
+// time, newest first, keeping only changes in category when it is set
+func (s *Store) Revisions(deviceID string, since time.Time, category string, limit int) []models.InventoryRevision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := []models.InventoryRevision{}
+	rec, ok := s.devices[deviceID]
+	if !ok {
+		return revisions
+	}
+	for i := len(rec.Revisions) - 1; i >= 0 && len(revisions) < limit; i-- {
+		revision := rec.Revisions[i]
+		if revision.Timestamp.Before(since) {
+			break
+		}
+		if category != "" {
+			var changes []models.InventoryChange
+			for _, change := range revision.Changes {
+				if change.Category == category {
+					changes = append(changes, change)
+				}
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			revision.Changes = changes
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions
+}
+
+// Snapshots returns the device's current version and the versions and times
+// of the snapshots still kept, newest first, without their contents
+func (s *Store) Snapshots(deviceID string) (int64, []models.InventorySnapshot) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshots := []models.InventorySnapshot{}
+	rec, ok := s.devices[deviceID]
+	if !ok {
+		return 0, snapshots
+	}
+	for i := len(rec.Snapshots) - 1; i >= 0; i-- {
+		snapshots = append(snapshots, models.InventorySnapshot{
+			Version:   rec.Snapshots[i].Version,
+			Timestamp: rec.Snapshots[i].Timestamp,
+		})
+	}
+	return rec.Version, snapshots
+}
+
+// Snapshot returns a version of a device, if it is still kept
+func (s *Store) Snapshot(deviceID string, version int64) (*models.InventorySnapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.devices[deviceID]
+	if !ok {
+		return nil, false
+	}
+	for i := range rec.Snapshots {
+		if rec.Snapshots[i].Version == version {
+			snapshot := rec.Snapshots[i]
+			return &snapshot, true
+		}
+	}
+	return nil, false
+}
+
+// persistLocked writes a device's record to its file atomically. Device IDs
+// are validated to letters, digits and ".:_-", so they are safe file names.
+func (s *Store) persistLocked(rec *record) error {
+	if s.dir == "" {
+		return nil
+	}
+	if rec.DeviceID == "" || strings.ContainsAny(rec.DeviceID, `/\`) || strings.HasPrefix(rec.DeviceID, ".") {
+		return fmt.Errorf("invalid device ID %q", rec.DeviceID)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create inventory directory: %w", err)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %w", err)
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a partial file
+	tmp, err := os.CreateTemp(s.dir, ".inventory-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write inventory file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write inventory file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write inventory file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, rec.DeviceID+".json")); err != nil {
+		return fmt.Errorf("failed to write inventory file: %w", err)
+	}
+	return nil
+}
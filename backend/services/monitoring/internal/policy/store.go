@@ -0,0 +1,469 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// Policy scopes, from lowest to highest precedence
+const (
+	ScopeTenant = "tenant"
+	ScopeGroup  = "group"
+	ScopeDevice = "device"
+)
+
+// scopeOrder lists scopes in the order they are merged
+var scopeOrder = []string{ScopeTenant, ScopeGroup, ScopeDevice}
+
+// Collectors lists the collector names a policy may enable or disable
+var Collectors = map[string]bool{
+	"cpu":        true,
+	"memory":     true,
+	"disk":       true,
+	"network":    true,
+	"processes":  true,
+	"systemd":    true,
+	"sensors":    true,
+	"containers": true,
+	"checks":     true,
+	"scripts":    true,
+	"snmp":       true,
+	"logs":       true,
+	"inventory":  true,
+}
+
+// Thresholds lists the metrics a policy may set thresholds on, all percentages
+var Thresholds = map[string]bool{
+	"cpu_percent":    true,
+	"memory_percent": true,
+	"swap_percent":   true,
+	"disk_percent":   true,
+	"inodes_percent": true,
+}
+
+// ErrNotFound is returned when a policy or release does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrReleaseInUse is returned when deleting a release a policy still targets
+var ErrReleaseInUse = errors.New("release is targeted by a policy")
+
+// Target identifies the agent a policy is resolved for
+type Target struct {
+	DeviceID string
+	TenantID string
+	Group    string
+	OS       string // platform used to pick an update binary
+	Arch     string
+}
+
+// Store keeps agent policies and the agent release catalog in memory,
+// optionally persisted to a JSON file
+type Store struct {
+	mu       sync.RWMutex
+	file     string
+	revision int64
+	policies map[string]*models.AgentPolicy  // keyed by scope:target
+	releases map[string]*models.AgentRelease // keyed by version
+
+	listeners []func()
+}
+
+// storeFile is the on-disk representation of the store
+type storeFile struct {
+	Revision int64                  `json:"revision"`
+	Policies []*models.AgentPolicy  `json:"policies"`
+	Releases []*models.AgentRelease `json:"releases,omitempty"`
+}
+
+// NewStore creates a policy store, loading existing policies from the configured file
+func NewStore(cfg *config.Config) (*Store, error) {
+	s := &Store{
+		file:     cfg.Policy.File,
+		policies: make(map[string]*models.AgentPolicy),
+		releases: make(map[string]*models.AgentRelease),
+	}
+
+	if s.file == "" {
+		log.Warn("No policy file configured, agent policies will not survive restarts")
+		return s, nil
+	}
+
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var stored storeFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	s.revision = stored.Revision
+	for _, p := range stored.Policies {
+		s.policies[key(p.Scope, p.Target)] = p
+	}
+	for _, r := range stored.Releases {
+		s.releases[r.Version] = r
+	}
+
+	log.WithField("policies", len(s.policies)).Info("Agent policies loaded")
+	return s, nil
+}
+
+// OnChange registers a function called after any policy is changed
+func (s *Store) OnChange(fn func()) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.mu.Unlock()
+}
+
+// List returns all policies ordered by scope precedence and target
+func (s *Store) List() []models.AgentPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.AgentPolicy, 0, len(s.policies))
+	for _, p := range s.policies {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Scope != list[j].Scope {
+			return scopeRank(list[i].Scope) < scopeRank(list[j].Scope)
+		}
+		return list[i].Target < list[j].Target
+	})
+	return list
+}
+
+// Put creates or replaces the policy for p.Scope and p.Target
+func (s *Store) Put(p models.AgentPolicy) (models.AgentPolicy, error) {
+	if err := Validate(&p); err != nil {
+		return p, err
+	}
+
+	s.mu.Lock()
+	if p.AgentVersion != "" && s.releases[p.AgentVersion] == nil {
+		s.mu.Unlock()
+		return p, fmt.Errorf("agent_version %q is not a published release", p.AgentVersion)
+	}
+	p.Version = s.revision + 1
+	p.UpdatedAt = time.Now()
+	policies := maps.Clone(s.policies)
+	policies[key(p.Scope, p.Target)] = &p
+	err := s.commitLocked(policies, s.releases)
+	s.mu.Unlock()
+
+	if err != nil {
+		return p, err
+	}
+	s.notify()
+	return p, nil
+}
+
+// Delete removes the policy for a scope and target
+func (s *Store) Delete(scope, target string) error {
+	s.mu.Lock()
+	k := key(scope, target)
+	if _, ok := s.policies[k]; !ok {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	policies := maps.Clone(s.policies)
+	delete(policies, k)
+	err := s.commitLocked(policies, s.releases)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Resolve merges the tenant, group and device policies that apply to t.
+// Higher-precedence scopes override individual settings of lower ones. The
+// version is the store revision, so any policy change yields a new version.
+func (s *Store) Resolve(t Target) models.EffectivePolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	effective := models.EffectivePolicy{
+		DeviceID: t.DeviceID,
+		Version:  s.revision,
+	}
+
+	targets := map[string]string{
+		ScopeTenant: t.TenantID,
+		ScopeGroup:  t.Group,
+		ScopeDevice: t.DeviceID,
+	}
+
+	for _, scope := range scopeOrder {
+		if targets[scope] == "" {
+			continue
+		}
+		p, ok := s.policies[key(scope, targets[scope])]
+		if !ok {
+			continue
+		}
+
+		if p.CheckInterval != nil {
+			effective.CheckInterval = p.CheckInterval
+		}
+		if p.HeartbeatInterval != nil {
+			effective.HeartbeatInterval = p.HeartbeatInterval
+		}
+		for name, enabled := range p.Collectors {
+			if effective.Collectors == nil {
+				effective.Collectors = make(map[string]bool)
+			}
+			effective.Collectors[name] = enabled
+		}
+		for name, value := range p.Thresholds {
+			if effective.Thresholds == nil {
+				effective.Thresholds = make(map[string]float64)
+			}
+			effective.Thresholds[name] = value
+		}
+		if p.AgentVersion != "" {
+			effective.AgentVersion = p.AgentVersion
+		}
+		effective.Sources = append(effective.Sources, key(scope, p.Target))
+	}
+
+	if release := s.releases[effective.AgentVersion]; release != nil {
+		for _, artifact := range release.Artifacts {
+			if artifact.OS == t.OS && artifact.Arch == t.Arch {
+				effective.Update = &models.AgentUpdate{
+					Version:   release.Version,
+					URL:       artifact.URL,
+					SHA256:    artifact.SHA256,
+					Signature: artifact.Signature,
+				}
+				break
+			}
+		}
+	}
+
+	return effective
+}
+
+// ListReleases returns all published agent releases ordered by version
+func (s *Store) ListReleases() []models.AgentRelease {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.AgentRelease, 0, len(s.releases))
+	for _, r := range s.releases {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// PutRelease publishes or replaces an agent release
+func (s *Store) PutRelease(r models.AgentRelease) (models.AgentRelease, error) {
+	if err := ValidateRelease(&r); err != nil {
+		return r, err
+	}
+
+	s.mu.Lock()
+	r.CreatedAt = time.Now()
+	releases := maps.Clone(s.releases)
+	releases[r.Version] = &r
+	err := s.commitLocked(s.policies, releases)
+	s.mu.Unlock()
+
+	if err != nil {
+		return r, err
+	}
+	s.notify()
+	return r, nil
+}
+
+// DeleteRelease removes an agent release. Releases still targeted by a
+// policy cannot be deleted.
+func (s *Store) DeleteRelease(version string) error {
+	s.mu.Lock()
+	if _, ok := s.releases[version]; !ok {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	for _, p := range s.policies {
+		if p.AgentVersion == version {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrReleaseInUse, key(p.Scope, p.Target))
+		}
+	}
+	releases := maps.Clone(s.releases)
+	delete(releases, version)
+	err := s.commitLocked(s.policies, releases)
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Validate checks a policy before it is stored
+func Validate(p *models.AgentPolicy) error {
+	if scopeRank(p.Scope) < 0 {
+		return fmt.Errorf("scope must be one of tenant, group, device")
+	}
+	if p.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if p.CheckInterval != nil && *p.CheckInterval < 10 {
+		return fmt.Errorf("check_interval must be at least 10 seconds")
+	}
+	if p.HeartbeatInterval != nil && *p.HeartbeatInterval < 10 {
+		return fmt.Errorf("heartbeat_interval must be at least 10 seconds")
+	}
+	for name := range p.Collectors {
+		if !Collectors[name] {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	for name, value := range p.Thresholds {
+		if !Thresholds[name] {
+			return fmt.Errorf("unknown threshold %q", name)
+		}
+		if math.IsNaN(value) || value <= 0 || value > 100 {
+			return fmt.Errorf("threshold %s must be above 0 and at most 100", name)
+		}
+	}
+	return nil
+}
+
+// ValidateRelease checks a release before it is published
+func ValidateRelease(r *models.AgentRelease) error {
+	if r.Version == "" {
+		return fmt.Errorf("version is required")
+	}
+	if len(r.Artifacts) == 0 {
+		return fmt.Errorf("at least one artifact is required")
+	}
+
+	platforms := make(map[string]bool)
+	for i, a := range r.Artifacts {
+		if a.OS == "" || a.Arch == "" {
+			return fmt.Errorf("artifacts[%d]: os and arch are required", i)
+		}
+		if platforms[a.OS+"/"+a.Arch] {
+			return fmt.Errorf("artifacts[%d]: duplicate platform %s/%s", i, a.OS, a.Arch)
+		}
+		platforms[a.OS+"/"+a.Arch] = true
+
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("artifacts[%d]: url must be an absolute http(s) URL", i)
+		}
+		if digest, err := hex.DecodeString(a.SHA256); err != nil || len(digest) != sha256.Size {
+			return fmt.Errorf("artifacts[%d]: sha256 must be a hex SHA-256 digest", i)
+		}
+		if sig, err := base64.StdEncoding.DecodeString(a.Signature); err != nil || len(sig) != ed25519.SignatureSize {
+			return fmt.Errorf("artifacts[%d]: signature must be a base64 Ed25519 signature", i)
+		}
+	}
+	return nil
+}
+
+// commitLocked makes policies and releases the store's contents at the next
+// revision. They are persisted first and only swapped in once saved, so a
+// failed save leaves the store as it was on disk.
+func (s *Store) commitLocked(policies map[string]*models.AgentPolicy, releases map[string]*models.AgentRelease) error {
+	revision := s.revision + 1
+	if err := s.persist(revision, policies, releases); err != nil {
+		return err
+	}
+	s.revision = revision
+	s.policies = policies
+	s.releases = releases
+	return nil
+}
+
+// persist writes the given revision of the store to its file
+func (s *Store) persist(revision int64, policies map[string]*models.AgentPolicy, releases map[string]*models.AgentRelease) error {
+	if s.file == "" {
+		return nil
+	}
+
+	stored := storeFile{Revision: revision}
+	for _, p := range policies {
+		stored.Policies = append(stored.Policies, p)
+	}
+	for _, r := range releases {
+		stored.Releases = append(stored.Releases, r)
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal policies: %w", err)
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".policies-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write policy file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write policy file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write policy file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return fmt.Errorf("failed to write policy file: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) notify() {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+func key(scope, target string) string {
+	return scope + ":" + target
+}
+
+func scopeRank(scope string) int {
+	for i, s := range scopeOrder {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+var (
+	testDigest    = strings.Repeat("ab", 32)
+	testSignature = base64.StdEncoding.EncodeToString(make([]byte, 64))
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func artifact(os, arch string) models.AgentArtifact {
+	return models.AgentArtifact{
+		OS:        os,
+		Arch:      arch,
+		URL:       "https://example.com/ninjait-agent-" + os + "-" + arch,
+		SHA256:    testDigest,
+		Signature: testSignature,
+	}
+}
+
+// newTestStore creates an in-memory store holding the given policies and releases
+func newTestStore(t *testing.T, policies []models.AgentPolicy, releases []models.AgentRelease) *Store {
+	t.Helper()
+	s, err := NewStore(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range releases {
+		if _, err := s.PutRelease(r); err != nil {
+			t.Fatalf("PutRelease(%s) error = %v", r.Version, err)
+		}
+	}
+	for _, p := range policies {
+		if _, err := s.Put(p); err != nil {
+			t.Fatalf("Put(%s:%s) error = %v", p.Scope, p.Target, err)
+		}
+	}
+	return s
+}
+
+func TestResolve(t *testing.T) {
+	s := newTestStore(t, []models.AgentPolicy{
+		{
+			Scope:             ScopeTenant,
+			Target:            "acme",
+			CheckInterval:     intPtr(60),
+			HeartbeatInterval: intPtr(30),
+			Collectors:        map[string]bool{"sensors": false, "snmp": false},
+			Thresholds:        map[string]float64{"cpu_percent": 90, "disk_percent": 85},
+			AgentVersion:      "1.0.0",
+		},
+		{
+			Scope:         ScopeGroup,
+			Target:        "web",
+			CheckInterval: intPtr(30),
+			Collectors:    map[string]bool{"sensors": true},
+			AgentVersion:  "1.1.0",
+		},
+		{
+			Scope:      ScopeDevice,
+			Target:     "web1",
+			Thresholds: map[string]float64{"cpu_percent": 95},
+		},
+	}, []models.AgentRelease{
+		{Version: "1.0.0", Artifacts: []models.AgentArtifact{artifact("linux", "amd64")}},
+		{Version: "1.1.0", Artifacts: []models.AgentArtifact{artifact("linux", "amd64"), artifact("linux", "arm64"), artifact("windows", "amd64")}},
+	})
+
+	tests := []struct {
+		name   string
+		target Target
+		want   models.EffectivePolicy
+	}{
+		{
+			name:   "no matching policy",
+			target: Target{DeviceID: "db1", TenantID: "other", OS: "linux", Arch: "amd64"},
+			want:   models.EffectivePolicy{DeviceID: "db1"},
+		},
+		{
+			name:   "tenant only",
+			target: Target{DeviceID: "db1", TenantID: "acme", OS: "linux", Arch: "amd64"},
+			want: models.EffectivePolicy{
+				DeviceID:          "db1",
+				CheckInterval:     intPtr(60),
+				HeartbeatInterval: intPtr(30),
+				Collectors:        map[string]bool{"sensors": false, "snmp": false},
+				Thresholds:        map[string]float64{"cpu_percent": 90, "disk_percent": 85},
+				AgentVersion:      "1.0.0",
+				Update:            &models.AgentUpdate{Version: "1.0.0", URL: "https://example.com/ninjait-agent-linux-amd64", SHA256: testDigest, Signature: testSignature},
+				Sources:           []string{"tenant:acme"},
+			},
+		},
+		{
+			name:   "group overrides tenant, device overrides group",
+			target: Target{DeviceID: "web1", TenantID: "acme", Group: "web", OS: "linux", Arch: "arm64"},
+			want: models.EffectivePolicy{
+				DeviceID:          "web1",
+				CheckInterval:     intPtr(30),
+				HeartbeatInterval: intPtr(30),
+				Collectors:        map[string]bool{"sensors": true, "snmp": false},
+				Thresholds:        map[string]float64{"cpu_percent": 95, "disk_percent": 85},
+				AgentVersion:      "1.1.0",
+				Update:            &models.AgentUpdate{Version: "1.1.0", URL: "https://example.com/ninjait-agent-linux-arm64", SHA256: testDigest, Signature: testSignature},
+				Sources:           []string{"tenant:acme", "group:web", "device:web1"},
+			},
+		},
+		{
+			name:   "device without tenant or group",
+			target: Target{DeviceID: "web1", OS: "windows", Arch: "amd64"},
+			want: models.EffectivePolicy{
+				DeviceID:   "web1",
+				Thresholds: map[string]float64{"cpu_percent": 95},
+				Sources:    []string{"device:web1"},
+			},
+		},
+		{
+			name:   "release without an artifact for the platform",
+			target: Target{DeviceID: "db1", TenantID: "acme", OS: "windows", Arch: "amd64"},
+			want: models.EffectivePolicy{
+				DeviceID:          "db1",
+				CheckInterval:     intPtr(60),
+				HeartbeatInterval: intPtr(30),
+				Collectors:        map[string]bool{"sensors": false, "snmp": false},
+				Thresholds:        map[string]float64{"cpu_percent": 90, "disk_percent": 85},
+				AgentVersion:      "1.0.0",
+				Sources:           []string{"tenant:acme"},
+			},
+		},
+		{
+			name:   "artifact picked by OS and arch",
+			target: Target{DeviceID: "pc1", TenantID: "acme", Group: "web", OS: "windows", Arch: "amd64"},
+			want: models.EffectivePolicy{
+				DeviceID:          "pc1",
+				CheckInterval:     intPtr(30),
+				HeartbeatInterval: intPtr(30),
+				Collectors:        map[string]bool{"sensors": true, "snmp": false},
+				Thresholds:        map[string]float64{"cpu_percent": 90, "disk_percent": 85},
+				AgentVersion:      "1.1.0",
+				Update:            &models.AgentUpdate{Version: "1.1.0", URL: "https://example.com/ninjait-agent-windows-amd64", SHA256: testDigest, Signature: testSignature},
+				Sources:           []string{"tenant:acme", "group:web"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Resolve(tt.target)
+			tt.want.Version = s.revision
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveVersionFollowsChanges(t *testing.T) {
+	s := newTestStore(t, []models.AgentPolicy{{Scope: ScopeDevice, Target: "web1", CheckInterval: intPtr(60)}}, nil)
+	target := Target{DeviceID: "web1"}
+
+	before := s.Resolve(target)
+	if _, err := s.Put(models.AgentPolicy{Scope: ScopeTenant, Target: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if after := s.Resolve(target); after.Version <= before.Version {
+		t.Errorf("Resolve() version = %d after a change, want above %d", after.Version, before.Version)
+	}
+}
+
+func TestDeleteReleaseInUse(t *testing.T) {
+	s := newTestStore(t,
+		[]models.AgentPolicy{{Scope: ScopeGroup, Target: "web", AgentVersion: "1.0.0"}},
+		[]models.AgentRelease{{Version: "1.0.0", Artifacts: []models.AgentArtifact{artifact("linux", "amd64")}}},
+	)
+	if err := s.DeleteRelease("1.0.0"); !errors.Is(err, ErrReleaseInUse) {
+		t.Errorf("DeleteRelease() error = %v, want %v", err, ErrReleaseInUse)
+	}
+	if err := s.DeleteRelease("2.0.0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteRelease() error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,191 @@
+package remotewrite
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a single Prometheus label pair
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single Prometheus sample
+type Sample struct {
+	Value     float64
+	Timestamp int64 // milliseconds since epoch
+}
+
+// TimeSeries is a labelled series of samples from a remote-write request
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Protobuf field numbers from prometheus/prompb (remote.proto and types.proto)
+const (
+	fieldWriteRequestTimeseries = 1
+
+	fieldTimeSeriesLabels  = 1
+	fieldTimeSeriesSamples = 2
+
+	fieldLabelName  = 1
+	fieldLabelValue = 2
+
+	fieldSampleValue     = 1
+	fieldSampleTimestamp = 2
+)
+
+// Limits bounds the size of a decoded request
+type Limits struct {
+	MaxSeries       int
+	MaxDecodedBytes int
+}
+
+// Decode decompresses and parses a snappy-compressed prompb.WriteRequest.
+// Only labels and float samples are decoded; metadata, exemplars and native
+// histograms are skipped.
+func Decode(body []byte, limits Limits) ([]TimeSeries, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snappy header: %w", err)
+	}
+	if size > limits.MaxDecodedBytes {
+		return nil, fmt.Errorf("decompressed body of %d bytes exceeds limit of %d", size, limits.MaxDecodedBytes)
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snappy body: %w", err)
+	}
+
+	var series []TimeSeries
+	err = walk(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != fieldWriteRequestTimeseries || typ != protowire.BytesType {
+			return nil
+		}
+		if len(series) >= limits.MaxSeries {
+			return fmt.Errorf("request exceeds %d series", limits.MaxSeries)
+		}
+		ts, err := decodeTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case fieldTimeSeriesLabels:
+			label, err := decodeLabel(value)
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, label)
+		case fieldTimeSeriesSamples:
+			sample, err := decodeSample(value)
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func decodeLabel(data []byte) (Label, error) {
+	var label Label
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case fieldLabelName:
+			label.Name = string(value)
+		case fieldLabelValue:
+			label.Value = string(value)
+		}
+		return nil
+	})
+	return label, err
+}
+
+func decodeSample(data []byte) (Sample, error) {
+	var sample Sample
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return sample, fmt.Errorf("malformed sample: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		switch {
+		case num == fieldSampleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return sample, fmt.Errorf("malformed sample value: %w", protowire.ParseError(n))
+			}
+			sample.Value = math.Float64frombits(v)
+			data = data[n:]
+		case num == fieldSampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return sample, fmt.Errorf("malformed sample timestamp: %w", protowire.ParseError(n))
+			}
+			sample.Timestamp = int64(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return sample, fmt.Errorf("malformed sample: %w", protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	return sample, nil
+}
+
+// walk iterates over the fields of a protobuf message, passing the raw bytes of
+// length-delimited fields to fn and skipping the contents of all other fields
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("malformed protobuf: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			value, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("malformed protobuf: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
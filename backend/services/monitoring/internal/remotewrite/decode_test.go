@@ -0,0 +1,210 @@
+package remotewrite
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var testLimits = Limits{MaxSeries: 10, MaxDecodedBytes: 1 << 20}
+
+func encodeLabel(name, value string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, fieldLabelName, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, fieldLabelValue, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func encodeSample(value float64, timestamp int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, fieldSampleValue, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = protowire.AppendTag(b, fieldSampleTimestamp, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(timestamp))
+}
+
+// encodeSeries encodes a TimeSeries message with the given labels and samples
+func encodeSeries(ts TimeSeries) []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		b = protowire.AppendTag(b, fieldTimeSeriesLabels, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeLabel(l.Name, l.Value))
+	}
+	for _, s := range ts.Samples {
+		b = protowire.AppendTag(b, fieldTimeSeriesSamples, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeSample(s.Value, s.Timestamp))
+	}
+	return b
+}
+
+// encodeRequest encodes a WriteRequest from already encoded TimeSeries messages
+func encodeRequest(series ...[]byte) []byte {
+	var b []byte
+	for _, ts := range series {
+		b = protowire.AppendTag(b, fieldWriteRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	up := TimeSeries{
+		Labels:  []Label{{"__name__", "up"}, {"instance", "web1:9100"}},
+		Samples: []Sample{{1, 1700000000000}, {0, 1700000015000}},
+	}
+	special := TimeSeries{
+		Labels:  []Label{{"__name__", "x"}},
+		Samples: []Sample{{math.Inf(1), 1}, {math.Inf(-1), 2}},
+	}
+
+	// Unknown fields of every wire type are skipped
+	withUnknown := encodeSeries(up)
+	withUnknown = protowire.AppendTag(withUnknown, 3, protowire.BytesType)
+	withUnknown = protowire.AppendString(withUnknown, "exemplar")
+	withUnknown = protowire.AppendTag(withUnknown, 9, protowire.VarintType)
+	withUnknown = protowire.AppendVarint(withUnknown, 42)
+	metadata := protowire.AppendTag(nil, 3, protowire.BytesType)
+	metadata = protowire.AppendString(metadata, "metadata")
+
+	// A length prefix that runs past the end of the buffer
+	pastEnd := protowire.AppendTag(nil, fieldWriteRequestTimeseries, protowire.BytesType)
+	pastEnd = protowire.AppendVarint(pastEnd, 100)
+	pastEnd = append(pastEnd, encodeSeries(up)...)
+
+	// A label whose length runs past the end of its series
+	badLabel := protowire.AppendTag(nil, fieldTimeSeriesLabels, protowire.BytesType)
+	badLabel = protowire.AppendBytes(badLabel, append(protowire.AppendTag(nil, fieldLabelName, protowire.BytesType), 50, 'a'))
+
+	// A sample with a truncated timestamp varint
+	badSample := encodeSample(1, 1700000000000)
+	badSample = badSample[:len(badSample)-2]
+	badSeries := protowire.AppendTag(nil, fieldTimeSeriesSamples, protowire.BytesType)
+	badSeries = protowire.AppendBytes(badSeries, badSample)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []TimeSeries
+		wantErr string
+	}{
+		{
+			name: "valid request",
+			data: encodeRequest(encodeSeries(up), encodeSeries(up)),
+			want: []TimeSeries{up, up},
+		},
+		{
+			name: "empty request",
+			data: nil,
+			want: nil,
+		},
+		{
+			name: "unknown fields",
+			data: append(encodeRequest(withUnknown), metadata...),
+			want: []TimeSeries{up},
+		},
+		{
+			name: "infinite samples",
+			data: encodeRequest(encodeSeries(special)),
+			want: []TimeSeries{special},
+		},
+		{
+			name:    "truncated tag varint",
+			data:    append(encodeRequest(encodeSeries(up)), 0x80),
+			wantErr: "malformed protobuf",
+		},
+		{
+			name:    "truncated length varint",
+			data:    []byte{0x0a, 0xff, 0xff},
+			wantErr: "malformed protobuf",
+		},
+		{
+			name:    "length past the buffer",
+			data:    pastEnd,
+			wantErr: "malformed protobuf",
+		},
+		{
+			name:    "label past its series",
+			data:    encodeRequest(badLabel),
+			wantErr: "malformed protobuf",
+		},
+		{
+			name:    "truncated sample timestamp",
+			data:    encodeRequest(badSeries),
+			wantErr: "malformed sample timestamp",
+		},
+		{
+			name:    "too many series",
+			data:    encodeRequest(make([][]byte, testLimits.MaxSeries+1)...),
+			wantErr: "exceeds 10 series",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(snappy.Encode(nil, tt.data), testLimits)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeNaN(t *testing.T) {
+	ts := TimeSeries{Labels: []Label{{"__name__", "x"}}, Samples: []Sample{{math.NaN(), 1}}}
+	got, err := Decode(snappy.Encode(nil, encodeRequest(encodeSeries(ts))), testLimits)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(got) != 1 || len(got[0].Samples) != 1 || !math.IsNaN(got[0].Samples[0].Value) {
+		t.Errorf("Decode() = %+v, want one NaN sample", got)
+	}
+}
+
+func TestDecodeBodyLimits(t *testing.T) {
+	data := encodeRequest(encodeSeries(TimeSeries{Labels: []Label{{"__name__", strings.Repeat("x", 1000)}}}))
+
+	tests := []struct {
+		name    string
+		body    []byte
+		wantErr string
+	}{
+		{
+			name:    "decoded size over the limit",
+			body:    snappy.Encode(nil, data),
+			wantErr: "exceeds limit of 100",
+		},
+		{
+			name:    "not snappy",
+			body:    []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			wantErr: "snappy header",
+		},
+		{
+			name:    "corrupt snappy body",
+			body:    []byte{0x10, 0xff, 0xff},
+			wantErr: "decompress",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.body, Limits{MaxSeries: 10, MaxDecodedBytes: 100})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package remotewrite
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+const metricNameLabel = "__name__"
+
+// renamedLabelPrefix is prepended to label names InfluxDB or the storage
+// writer reserve, so they cannot shadow or overwrite those tags
+const renamedLabelPrefix = "label_"
+
+// writerTags are the tags WriteRemoteSamples sets on every point itself
+var writerTags = map[string]bool{
+	"device_id": true,
+	"hostname":  true,
+	"metric":    true,
+}
+
+// Mapper assigns remote-write series to NinjaIT devices based on their labels
+type Mapper struct {
+	deviceLabels []string
+	maxLabels    int
+	maxNameLen   int
+}
+
+// NewMapper creates a mapper using the configured device labels and limits
+func NewMapper(cfg *config.Config) *Mapper {
+	return &Mapper{
+		deviceLabels: cfg.RemoteWrite.DeviceLabels,
+		maxLabels:    cfg.RemoteWrite.MaxLabels,
+		maxNameLen:   cfg.Ingest.MaxTagLength,
+	}
+}
+
+// Map converts decoded series into samples. Series without a metric name or
+// device label are counted as unmapped; stale markers (NaN) are dropped.
+// Labels become InfluxDB tags, so their names are sanitized and each series
+// keeps at most the configured number of them; droppedLabels counts the rest.
+func (m *Mapper) Map(series []TimeSeries) (samples []models.RemoteSample, unmapped, droppedLabels int) {
+	for _, ts := range series {
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Value != "" {
+				labels[l.Name] = l.Value
+			}
+		}
+
+		metric := labels[metricNameLabel]
+		deviceLabel, device := m.device(labels)
+		if metric == "" || device == "" {
+			unmapped++
+			continue
+		}
+
+		hostname := device
+		if host, _, err := net.SplitHostPort(device); err == nil {
+			// instance labels are usually host:port of the exporter
+			hostname = host
+		}
+
+		delete(labels, metricNameLabel)
+		delete(labels, deviceLabel)
+		labels, dropped := m.limitLabels(labels)
+		droppedLabels += dropped
+
+		for _, s := range ts.Samples {
+			if math.IsNaN(s.Value) {
+				continue
+			}
+			samples = append(samples, models.RemoteSample{
+				DeviceID:  hostname,
+				Hostname:  hostname,
+				Metric:    metric,
+				Labels:    labels,
+				Value:     s.Value,
+				Timestamp: time.UnixMilli(s.Timestamp),
+			})
+		}
+	}
+	return samples, unmapped, droppedLabels
+}
+
+// device returns the first configured device label present on a series
+func (m *Mapper) device(labels map[string]string) (string, string) {
+	for _, name := range m.deviceLabels {
+		if value := labels[name]; value != "" {
+			return name, value
+		}
+	}
+	return "", ""
+}
+
+// limitLabels sanitizes label names and keeps the first maxLabels labels in
+// name order, returning how many were dropped. Reserved labels ("__" prefix)
+// and labels that collide with another after sanitizing are dropped too.
+// Names starting with '_', which InfluxDB reserves (_field, _measurement,
+// _time), and names of the writer's own tags get the "label_" prefix.
+func (m *Mapper) limitLabels(labels map[string]string) (map[string]string, int) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	kept := make(map[string]string, len(labels))
+	for _, name := range names {
+		clean := sanitizeLabelName(name, m.maxNameLen)
+		if strings.HasPrefix(clean, "_") || writerTags[clean] {
+			clean = sanitizeLabelName(renamedLabelPrefix+clean, m.maxNameLen)
+		}
+		if clean == "" || strings.HasPrefix(name, "__") || len(kept) >= m.maxLabels {
+			continue
+		}
+		if _, taken := kept[clean]; taken {
+			continue
+		}
+		kept[clean] = labels[name]
+	}
+	return kept, len(labels) - len(kept)
+}
+
+// sanitizeLabelName replaces characters outside the Prometheus label name
+// charset [A-Za-z0-9_] with '_', so names are safe InfluxDB tag keys, and
+// truncates the result to max bytes
+func sanitizeLabelName(name string, max int) string {
+	clean := []byte(name)
+	for i, c := range clean {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0) {
+			clean[i] = '_'
+		}
+	}
+	if len(clean) > max {
+		clean = clean[:max]
+	}
+	return string(clean)
+}
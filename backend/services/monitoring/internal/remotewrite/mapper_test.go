@@ -0,0 +1,102 @@
+package remotewrite
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// series builds a single-sample series from alternating label names and values
+func series(value float64, labels ...string) TimeSeries {
+	ts := TimeSeries{Samples: []Sample{{Value: value, Timestamp: 1700000000000}}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		ts.Labels = append(ts.Labels, Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return ts
+}
+
+func TestMapperLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		series      TimeSeries
+		want        map[string]string
+		wantDropped int
+	}{
+		{
+			name:   "plain labels",
+			series: series(1, "__name__", "up", "instance", "web1:9100", "job", "node", "env", "prod"),
+			want:   map[string]string{"env": "prod", "job": "node"},
+		},
+		{
+			name:   "sanitized names",
+			series: series(1, "__name__", "up", "instance", "web1", "bad-name", "a", "9lives", "b"),
+			want:   map[string]string{"bad_name": "a", "label__lives": "b"},
+		},
+		{
+			name:   "influx reserved names",
+			series: series(1, "__name__", "up", "instance", "web1", "_field", "a", "_measurement", "b", "_time", "c"),
+			want:   map[string]string{"label__field": "a", "label__measurement": "b", "label__time": "c"},
+		},
+		{
+			name:   "writer tags",
+			series: series(1, "__name__", "up", "instance", "web1", "device_id", "a", "hostname", "b", "metric", "c"),
+			want:   map[string]string{"label_device_id": "a", "label_hostname": "b", "label_metric": "c"},
+		},
+		{
+			name:        "collision after renaming",
+			series:      series(1, "__name__", "up", "instance", "web1", "hostname", "a", "label_hostname", "b"),
+			want:        map[string]string{"label_hostname": "a"},
+			wantDropped: 1,
+		},
+		{
+			name:        "reserved prometheus labels",
+			series:      series(1, "__name__", "up", "instance", "web1", "__meta_x", "a", "job", "node"),
+			want:        map[string]string{"job": "node"},
+			wantDropped: 1,
+		},
+		{
+			name:        "over the label limit",
+			series:      series(1, "__name__", "up", "instance", "web1", "a", "1", "b", "2", "c", "3", "d", "4"),
+			want:        map[string]string{"a": "1", "b": "2", "c": "3"},
+			wantDropped: 1,
+		},
+	}
+
+	m := &Mapper{deviceLabels: []string{"ninjait_device_id", "instance"}, maxLabels: 3, maxNameLen: 64}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, unmapped, dropped := m.Map([]TimeSeries{tt.series})
+			if unmapped != 0 || len(samples) != 1 {
+				t.Fatalf("Map() = %d samples, %d unmapped, want 1 sample", len(samples), unmapped)
+			}
+			if !reflect.DeepEqual(samples[0].Labels, tt.want) {
+				t.Errorf("Map() labels = %v, want %v", samples[0].Labels, tt.want)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("Map() dropped = %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMapperDevice(t *testing.T) {
+	m := &Mapper{deviceLabels: []string{"ninjait_device_id", "instance"}, maxLabels: 16, maxNameLen: 64}
+	samples, unmapped, _ := m.Map([]TimeSeries{
+		series(1, "__name__", "up", "instance", "web1:9100"),
+		series(2, "__name__", "up", "instance", "web1:9100", "ninjait_device_id", "dev-1"),
+		series(3, "__name__", "up"),
+		series(4, "instance", "web1:9100"),
+		series(math.NaN(), "__name__", "up", "instance", "web2"),
+	})
+
+	if unmapped != 2 {
+		t.Errorf("Map() unmapped = %d, want 2", unmapped)
+	}
+	var got []string
+	for _, s := range samples {
+		got = append(got, s.DeviceID+"/"+s.Hostname+"/"+s.Metric)
+	}
+	if want := []string{"web1/web1/up", "dev-1/dev-1/up"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Map() samples = %v, want %v", got, want)
+	}
+}
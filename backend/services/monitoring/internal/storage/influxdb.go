@@ -3,13 +3,18 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	log "github.com/sirupsen/logrus"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/telemetry"
 	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
-	log "github.com/sirupsen/logrus"
 )
 
 // InfluxDBStorage handles metric storage in InfluxDB
@@ -18,12 +23,14 @@ type InfluxDBStorage struct {
 	writeAPI api.WriteAPIBlocking
 	queryAPI api.QueryAPI
 	config   *config.Config
+
+	inflightWrites atomic.Int64
 }
 
 // NewInfluxDBStorage creates a new InfluxDB storage instance
 func NewInfluxDBStorage(cfg *config.Config) (*InfluxDBStorage, error) {
 	// Create InfluxDB client
-	client := influxdb2.NewClient(cfg.InfluxDB.URL, cfg.InfluxDB.Token)
+	client := influxdb2.NewClient(cfg.InfluxDB.URL, cfg.InfluxDB.Token.Value())
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -65,22 +72,53 @@ func (s *InfluxDBStorage) Close() {
 	}
 }
 
+// Ping checks that InfluxDB is reachable
+func (s *InfluxDBStorage) Ping(ctx context.Context) error {
+	ok, err := s.client.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to ping InfluxDB: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("InfluxDB ping failed")
+	}
+	return nil
+}
+
+// InflightWrites returns the number of writes currently waiting on InfluxDB
+func (s *InfluxDBStorage) InflightWrites() int64 {
+	return s.inflightWrites.Load()
+}
+
+// write sends points to InfluxDB, tracking in-flight writes and latency
+func (s *InfluxDBStorage) write(ctx context.Context, operation string, points ...*write.Point) error {
+	s.inflightWrites.Add(1)
+	defer s.inflightWrites.Add(-1)
+
+	start := time.Now()
+	err := s.writeAPI.WritePoint(ctx, points...)
+	telemetry.ObserveStorage(operation, start, err)
+	return err
+}
+
 // WriteMetrics writes system metrics to InfluxDB
 func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.SystemMetrics) error {
-	points := []*influxdb2.Point{}
+	points := []*write.Point{}
 
 	// CPU metrics
 	if metrics.CPU != nil {
+		fields := map[string]interface{}{
+			"usage_percent": metrics.CPU.UsagePercent,
+			"cores":         metrics.CPU.Cores,
+		}
+		addStats(fields, "usage_percent", metrics.CPU.UsageStats)
+
 		p := influxdb2.NewPoint(
 			"cpu",
 			map[string]string{
 				"device_id": metrics.DeviceID,
 				"hostname":  metrics.Hostname,
 			},
-			map[string]interface{}{
-				"usage_percent": metrics.CPU.UsagePercent,
-				"cores":         metrics.CPU.Cores,
-			},
+			fields,
 			metrics.Timestamp,
 		)
 		points = append(points, p)
@@ -88,21 +126,25 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 
 	// Memory metrics
 	if metrics.Memory != nil {
+		fields := map[string]interface{}{
+			"total":        metrics.Memory.Total,
+			"available":    metrics.Memory.Available,
+			"used":         metrics.Memory.Used,
+			"used_percent": metrics.Memory.UsedPercent,
+			"free":         metrics.Memory.Free,
+			"swap_total":   metrics.Memory.SwapTotal,
+			"swap_used":    metrics.Memory.SwapUsed,
+		}
+		addStats(fields, "used_percent", metrics.Memory.UsedPercentStats)
+		addStats(fields, "swap_used_percent", metrics.Memory.SwapUsedPercentStats)
+
 		p := influxdb2.NewPoint(
 			"memory",
 			map[string]string{
 				"device_id": metrics.DeviceID,
 				"hostname":  metrics.Hostname,
 			},
-			map[string]interface{}{
-				"total":        metrics.Memory.Total,
-				"available":    metrics.Memory.Available,
-				"used":         metrics.Memory.Used,
-				"used_percent": metrics.Memory.UsedPercent,
-				"free":         metrics.Memory.Free,
-				"swap_total":   metrics.Memory.SwapTotal,
-				"swap_used":    metrics.Memory.SwapUsed,
-			},
+			fields,
 			metrics.Timestamp,
 		)
 		points = append(points, p)
@@ -110,6 +152,21 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 
 	// Disk metrics
 	for _, disk := range metrics.Disks {
+		fields := map[string]interface{}{
+			"total":        disk.Total,
+			"used":         disk.Used,
+			"free":         disk.Free,
+			"used_percent": disk.UsedPercent,
+		}
+		if disk.InodesTotal > 0 {
+			fields["inodes_total"] = disk.InodesTotal
+			fields["inodes_used"] = disk.InodesUsed
+			fields["inodes_free"] = disk.InodesFree
+			fields["inodes_used_percent"] = disk.InodesUsedPercent
+		}
+		addStats(fields, "used_percent", disk.UsedPercentStats)
+		addStats(fields, "inodes_used_percent", disk.InodesUsedPercentStats)
+
 		p := influxdb2.NewPoint(
 			"disk",
 			map[string]string{
@@ -119,12 +176,7 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 				"mountpoint": disk.Mountpoint,
 				"fs_type":    disk.FsType,
 			},
-			map[string]interface{}{
-				"total":        disk.Total,
-				"used":         disk.Used,
-				"free":         disk.Free,
-				"used_percent": disk.UsedPercent,
-			},
+			fields,
 			metrics.Timestamp,
 		)
 		points = append(points, p)
@@ -132,23 +184,215 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 
 	// Network metrics
 	if metrics.Network != nil {
+		fields := map[string]interface{}{
+			"bytes_sent":   metrics.Network.BytesSent,
+			"bytes_recv":   metrics.Network.BytesRecv,
+			"packets_sent": metrics.Network.PacketsSent,
+			"packets_recv": metrics.Network.PacketsRecv,
+			"errors_in":    metrics.Network.ErrorsIn,
+			"errors_out":   metrics.Network.ErrorsOut,
+		}
+		addStats(fields, "bytes_sent_rate", metrics.Network.BytesSentRateStats)
+		addStats(fields, "bytes_recv_rate", metrics.Network.BytesRecvRateStats)
+
 		p := influxdb2.NewPoint(
 			"network",
 			map[string]string{
 				"device_id": metrics.DeviceID,
 				"hostname":  metrics.Hostname,
 			},
+			fields,
+			metrics.Timestamp,
+		)
+		points = append(points, p)
+	}
+
+	// systemd unit states
+	for _, service := range metrics.Services {
+		fields := map[string]interface{}{
+			"load_state":   service.LoadState,
+			"active_state": service.ActiveState,
+			"sub_state":    service.SubState,
+			"result":       service.Result,
+			"active":       service.ActiveState == "active",
+			"failed":       service.ActiveState == "failed",
+			"restarts":     service.Restarts,
+		}
+		if service.FailedAt != nil {
+			fields["failed_at"] = service.FailedAt.Unix()
+		}
+		p := influxdb2.NewPoint(
+			"systemd_unit",
+			map[string]string{
+				"device_id": metrics.DeviceID,
+				"hostname":  metrics.Hostname,
+				"unit":      service.Name,
+			},
+			fields,
+			metrics.Timestamp,
+		)
+		points = append(points, p)
+	}
+
+	// Hardware sensors, tagged with the sensor key
+	if metrics.Sensors != nil {
+		tags := func(key string) map[string]string {
+			return map[string]string{
+				"device_id": metrics.DeviceID,
+				"hostname":  metrics.Hostname,
+				"sensor":    key,
+			}
+		}
+		for _, t := range metrics.Sensors.Temperatures {
+			fields := map[string]interface{}{
+				"celsius": t.Celsius,
+			}
+			// Zero means the sensor has no such threshold
+			if t.High > 0 {
+				fields["high"] = t.High
+			}
+			if t.Critical > 0 {
+				fields["critical"] = t.Critical
+			}
+			points = append(points, influxdb2.NewPoint("temperature", tags(t.Key), fields, metrics.Timestamp))
+		}
+		for _, fan := range metrics.Sensors.Fans {
+			points = append(points, influxdb2.NewPoint("fan", tags(fan.Key), map[string]interface{}{
+				"rpm": fan.RPM,
+			}, metrics.Timestamp))
+		}
+		for _, p := range metrics.Sensors.Power {
+			points = append(points, influxdb2.NewPoint("power", tags(p.Key), map[string]interface{}{
+				"watts": p.Watts,
+			}, metrics.Timestamp))
+		}
+	}
+
+	// Containers, tagged with ID, name and image
+	for _, container := range metrics.Containers {
+		fields := map[string]interface{}{
+			"cpu_percent":       container.CPUPercent,
+			"cpu_seconds":       container.CPUSeconds,
+			"memory_usage":      container.MemoryUsage,
+			"block_read_bytes":  container.BlockReadBytes,
+			"block_write_bytes": container.BlockWriteBytes,
+			"network_rx_bytes":  container.NetworkRxBytes,
+			"network_tx_bytes":  container.NetworkTxBytes,
+			"pids":              container.Pids,
+			"restart_count":     container.RestartCount,
+		}
+		// Zero means no limit is set
+		if container.MemoryLimit > 0 {
+			fields["memory_limit"] = container.MemoryLimit
+		}
+		if container.State != "" {
+			fields["state"] = container.State
+		}
+		p := influxdb2.NewPoint(
+			"container",
+			map[string]string{
+				"device_id":      metrics.DeviceID,
+				"hostname":       metrics.Hostname,
+				"container_id":   container.ID,
+				"container_name": container.Name,
+				"image":          container.Image,
+				"runtime":        container.Runtime,
+			},
+			fields,
+			metrics.Timestamp,
+		)
+		points = append(points, p)
+	}
+
+	// Synthetic checks, tagged with the check name, type and target
+	for _, check := range metrics.Checks {
+		fields := map[string]interface{}{
+			"success":    check.Success,
+			"latency_ms": check.LatencyMs,
+		}
+		if check.Error != "" {
+			fields["error"] = check.Error
+		}
+		if check.StatusCode != 0 {
+			fields["status_code"] = check.StatusCode
+		}
+		if check.CertExpiresAt != nil {
+			fields["cert_expires_at"] = check.CertExpiresAt.Unix()
+		}
+		if len(check.Addresses) > 0 {
+			fields["addresses"] = strings.Join(check.Addresses, ",")
+		}
+		p := influxdb2.NewPoint(
+			"check",
+			map[string]string{
+				"device_id": metrics.DeviceID,
+				"hostname":  metrics.Hostname,
+				"check":     check.Name,
+				"type":      check.Type,
+				"target":    check.Target,
+			},
+			fields,
+			metrics.Timestamp,
+		)
+		points = append(points, p)
+	}
+
+	// Check scripts, with each perfdata value as its own point
+	for _, script := range metrics.Scripts {
+		p := influxdb2.NewPoint(
+			"script",
+			map[string]string{
+				"device_id": metrics.DeviceID,
+				"hostname":  metrics.Hostname,
+				"script":    script.Name,
+			},
 			map[string]interface{}{
-				"bytes_sent":   metrics.Network.BytesSent,
-				"bytes_recv":   metrics.Network.BytesRecv,
-				"packets_sent": metrics.Network.PacketsSent,
-				"packets_recv": metrics.Network.PacketsRecv,
-				"errors_in":    metrics.Network.ErrorsIn,
-				"errors_out":   metrics.Network.ErrorsOut,
+				"status":      script.Status,
+				"exit_code":   script.ExitCode,
+				"ok":          script.Status == "OK",
+				"output":      script.Output,
+				"duration_ms": script.DurationMs,
 			},
 			metrics.Timestamp,
 		)
 		points = append(points, p)
+
+		for _, perf := range script.Perfdata {
+			fields := map[string]interface{}{
+				"value": perf.Value,
+			}
+			if perf.Warn != "" {
+				fields["warn"] = perf.Warn
+			}
+			if perf.Crit != "" {
+				fields["crit"] = perf.Crit
+			}
+			if perf.Min != nil {
+				fields["min"] = *perf.Min
+			}
+			if perf.Max != nil {
+				fields["max"] = *perf.Max
+			}
+			p := influxdb2.NewPoint(
+				"script_perfdata",
+				map[string]string{
+					"device_id": metrics.DeviceID,
+					"hostname":  metrics.Hostname,
+					"script":    script.Name,
+					"label":     perf.Label,
+					"unit":      perf.Unit,
+				},
+				fields,
+				metrics.Timestamp,
+			)
+			points = append(points, p)
+		}
+	}
+
+	// SNMP devices are polled by an agent acting as their proxy. The device
+	// has no heartbeat of its own, so its reachability stands in for one.
+	if metrics.SNMP != nil {
+		points = append(points, snmpPoints(metrics)...)
 	}
 
 	// System info
@@ -171,7 +415,7 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 	}
 
 	// Write all points
-	if err := s.writeAPI.WritePoint(ctx, points...); err != nil {
+	if err := s.write(ctx, "write_metrics", points...); err != nil {
 		return fmt.Errorf("failed to write metrics: %w", err)
 	}
 
@@ -184,8 +428,138 @@ func (s *InfluxDBStorage) WriteMetrics(ctx context.Context, metrics *models.Syst
 	return nil
 }
 
+// addStats adds the summary of a sampled value as fields named after it,
+// e.g. usage_percent_max
+func addStats(fields map[string]interface{}, name string, stats *models.SampleStats) {
+	if stats == nil {
+		return
+	}
+	fields[name+"_min"] = stats.Min
+	fields[name+"_max"] = stats.Max
+	fields[name+"_mean"] = stats.Mean
+	fields[name+"_p95"] = stats.P95
+	fields[name+"_last"] = stats.Last
+	fields[name+"_samples"] = stats.Count
+}
+
+// snmpPoints converts an SNMP device sample into points, including a
+// heartbeat recording whether the proxy could reach the device
+func snmpPoints(metrics *models.SystemMetrics) []*write.Point {
+	snmp := metrics.SNMP
+	baseTags := func(extra map[string]string) map[string]string {
+		tags := map[string]string{
+			"device_id": metrics.DeviceID,
+			"hostname":  metrics.Hostname,
+			"proxy_id":  metrics.ProxyID,
+		}
+		for name, value := range extra {
+			tags[name] = value
+		}
+		return tags
+	}
+
+	status := "offline"
+	if snmp.Reachable {
+		status = "online"
+	}
+	points := []*write.Point{
+		influxdb2.NewPoint(
+			"heartbeat",
+			baseTags(map[string]string{
+				"status":  status,
+				"version": "snmp",
+			}),
+			map[string]interface{}{
+				"online": snmp.Reachable,
+			},
+			metrics.Timestamp,
+		),
+	}
+
+	fields := map[string]interface{}{
+		"reachable": snmp.Reachable,
+	}
+	if snmp.Error != "" {
+		fields["error"] = snmp.Error
+	}
+	if snmp.Reachable {
+		fields["uptime"] = snmp.Uptime
+		fields["sys_name"] = snmp.SysName
+		fields["sys_descr"] = snmp.SysDescr
+		fields["sys_object_id"] = snmp.SysObjectID
+	}
+	points = append(points, influxdb2.NewPoint(
+		"snmp_system",
+		baseTags(map[string]string{"address": snmp.Address}),
+		fields,
+		metrics.Timestamp,
+	))
+
+	for _, iface := range snmp.Interfaces {
+		fields := map[string]interface{}{
+			"admin_up":     iface.AdminUp,
+			"oper_up":      iface.OperUp,
+			"speed":        iface.Speed,
+			"in_octets":    iface.InOctets,
+			"out_octets":   iface.OutOctets,
+			"in_errors":    iface.InErrors,
+			"out_errors":   iface.OutErrors,
+			"in_discards":  iface.InDiscards,
+			"out_discards": iface.OutDiscards,
+		}
+		if iface.Alias != "" {
+			fields["alias"] = iface.Alias
+		}
+		points = append(points, influxdb2.NewPoint(
+			"snmp_interface",
+			baseTags(map[string]string{
+				"interface": iface.Name,
+				"if_index":  strconv.Itoa(iface.Index),
+			}),
+			fields,
+			metrics.Timestamp,
+		))
+	}
+
+	for _, value := range snmp.Values {
+		fields := map[string]interface{}{}
+		switch {
+		case value.Error != "":
+			fields["error"] = value.Error
+		case value.Value != nil:
+			fields["value"] = *value.Value
+		default:
+			fields["text"] = value.Text
+		}
+		points = append(points, influxdb2.NewPoint(
+			"snmp_value",
+			baseTags(map[string]string{
+				"name": value.Name,
+				"oid":  value.OID,
+			}),
+			fields,
+			metrics.Timestamp,
+		))
+	}
+
+	return points
+}
+
 // WriteHeartbeat writes a heartbeat event to InfluxDB
 func (s *InfluxDBStorage) WriteHeartbeat(ctx context.Context, heartbeat *models.Heartbeat) error {
+	fields := map[string]interface{}{
+		"online":         heartbeat.Status == "online",
+		"policy_version": heartbeat.PolicyVersion,
+	}
+	if r := heartbeat.Resources; r != nil {
+		// The agent's own resource usage
+		fields["agent_cpu_percent"] = r.CPUPercent
+		fields["agent_rss_bytes"] = r.RSSBytes
+		fields["agent_goroutines"] = r.Goroutines
+		fields["agent_open_fds"] = r.OpenFDs
+		fields["throttled"] = r.Throttled
+	}
+
 	p := influxdb2.NewPoint(
 		"heartbeat",
 		map[string]string{
@@ -194,13 +568,11 @@ func (s *InfluxDBStorage) WriteHeartbeat(ctx context.Context, heartbeat *models.
 			"status":    heartbeat.Status,
 			"version":   heartbeat.Version,
 		},
-		map[string]interface{}{
-			"online": heartbeat.Status == "online",
-		},
+		fields,
 		heartbeat.Timestamp,
 	)
 
-	if err := s.writeAPI.WritePoint(ctx, p); err != nil {
+	if err := s.write(ctx, "write_heartbeat", p); err != nil {
 		return fmt.Errorf("failed to write heartbeat: %w", err)
 	}
 
@@ -212,17 +584,150 @@ func (s *InfluxDBStorage) WriteHeartbeat(ctx context.Context, heartbeat *models.
 	return nil
 }
 
+// WriteEvents writes a batch of log events to InfluxDB, one "log_event"
+// point per event, and the lines the agent's rate limit dropped
+func (s *InfluxDBStorage) WriteEvents(ctx context.Context, batch *models.EventBatch) error {
+	points := make([]*write.Point, 0, len(batch.Events)+len(batch.Dropped))
+
+	// Points of one series with the same timestamp overwrite each other, so
+	// events logged within the same instant are spread a nanosecond apart
+	last := make(map[string]time.Time)
+	for _, event := range batch.Events {
+		tags := map[string]string{
+			"device_id": batch.DeviceID,
+			"hostname":  batch.Hostname,
+			"source":    event.Source,
+			"pattern":   event.Pattern,
+			"unit":      event.Unit,
+		}
+		series := event.Source + "\x00" + event.Pattern + "\x00" + event.Unit
+		ts := event.Timestamp
+		if prev, ok := last[series]; ok && !ts.After(prev) {
+			ts = prev.Add(time.Nanosecond)
+		}
+		last[series] = ts
+
+		fields := map[string]interface{}{
+			"message": event.Message,
+		}
+		if event.Path != "" {
+			fields["path"] = event.Path
+		}
+		points = append(points, influxdb2.NewPoint("log_event", tags, fields, ts))
+	}
+
+	for source, count := range batch.Dropped {
+		points = append(points, influxdb2.NewPoint(
+			"log_events_dropped",
+			map[string]string{
+				"device_id": batch.DeviceID,
+				"hostname":  batch.Hostname,
+				"source":    source,
+			},
+			map[string]interface{}{
+				"count": count,
+			},
+			batch.Timestamp,
+		))
+	}
+
+	if len(points) == 0 {
+		return nil
+	}
+	if err := s.write(ctx, "write_events", points...); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"device_id": batch.DeviceID,
+		"events":    len(batch.Events),
+	}).Debug("Log events written to InfluxDB")
+
+	return nil
+}
+
+// WriteRemoteSamples writes Prometheus remote-write samples to InfluxDB. Each
+// sample becomes a point in the "prometheus" measurement tagged with its metric
+// name and labels, and every device in the batch is recorded as online so that
+// hosts without an agent still show up in device status.
+func (s *InfluxDBStorage) WriteRemoteSamples(ctx context.Context, samples []models.RemoteSample) error {
+	points := make([]*write.Point, 0, len(samples))
+	latest := make(map[string]models.RemoteSample)
+
+	for _, sample := range samples {
+		tags := make(map[string]string, len(sample.Labels)+3)
+		for name, value := range sample.Labels {
+			tags[name] = value
+		}
+		tags["device_id"] = sample.DeviceID
+		tags["hostname"] = sample.Hostname
+		tags["metric"] = sample.Metric
+
+		points = append(points, influxdb2.NewPoint(
+			"prometheus",
+			tags,
+			map[string]interface{}{
+				"value": sample.Value,
+			},
+			sample.Timestamp,
+		))
+
+		if prev, ok := latest[sample.DeviceID]; !ok || sample.Timestamp.After(prev.Timestamp) {
+			latest[sample.DeviceID] = sample
+		}
+	}
+
+	for _, sample := range latest {
+		points = append(points, influxdb2.NewPoint(
+			"heartbeat",
+			map[string]string{
+				"device_id": sample.DeviceID,
+				"hostname":  sample.Hostname,
+				"status":    "online",
+				"version":   "prometheus-remote-write",
+			},
+			map[string]interface{}{
+				"online": true,
+			},
+			sample.Timestamp,
+		))
+	}
+
+	if err := s.write(ctx, "write_remote", points...); err != nil {
+		return fmt.Errorf("failed to write remote samples: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"devices": len(latest),
+		"points":  len(points),
+	}).Debug("Remote-write samples written to InfluxDB")
+
+	return nil
+}
+
+// fluxEscaper escapes the characters that are special in a Flux string
+// literal, including the ${ of string interpolation
+var fluxEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// fluxString quotes a value as a Flux string literal, so values taken from
+// requests cannot change the query they are placed in
+func fluxString(value string) string {
+	return `"` + fluxEscaper.Replace(value) + `"`
+}
+
 // QueryLatestMetrics queries the latest metrics for a device
 func (s *InfluxDBStorage) QueryLatestMetrics(ctx context.Context, deviceID string, limit int) ([]map[string]interface{}, error) {
 	query := fmt.Sprintf(`
 		from(bucket: "%s")
 		  |> range(start: -1h)
-		  |> filter(fn: (r) => r["device_id"] == "%s")
+		  |> filter(fn: (r) => r["device_id"] == %s)
 		  |> limit(n: %d)
-	`, s.config.InfluxDB.Bucket, deviceID, limit)
+	`, s.config.InfluxDB.Bucket, fluxString(deviceID), limit)
 
+	start := time.Now()
 	result, err := s.queryAPI.Query(ctx, query)
 	if err != nil {
+		telemetry.ObserveStorage("query_metrics", start, err)
 		return nil, fmt.Errorf("failed to query metrics: %w", err)
 	}
 	defer result.Close()
@@ -233,6 +738,7 @@ func (s *InfluxDBStorage) QueryLatestMetrics(ctx context.Context, deviceID strin
 		metrics = append(metrics, record.Values())
 	}
 
+	telemetry.ObserveStorage("query_metrics", start, result.Err())
 	if result.Err() != nil {
 		return nil, fmt.Errorf("query error: %w", result.Err())
 	}
@@ -240,17 +746,200 @@ func (s *InfluxDBStorage) QueryLatestMetrics(ctx context.Context, deviceID strin
 	return metrics, nil
 }
 
+// QueryServices returns the most recent state of each systemd unit a device
+// reported in the last hour
+func (s *InfluxDBStorage) QueryServices(ctx context.Context, deviceID string) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -1h)
+		  |> filter(fn: (r) => r["_measurement"] == "systemd_unit")
+		  |> filter(fn: (r) => r["device_id"] == %s)
+		  |> last()
+		  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+		  |> group()
+		  |> sort(columns: ["unit"])
+	`, s.config.InfluxDB.Bucket, fluxString(deviceID))
+
+	start := time.Now()
+	result, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		telemetry.ObserveStorage("query_services", start, err)
+		return nil, fmt.Errorf("failed to query services: %w", err)
+	}
+	defer result.Close()
+
+	services := []map[string]interface{}{}
+	for result.Next() {
+		values := result.Record().Values()
+		service := map[string]interface{}{
+			"unit":       values["unit"],
+			"updated_at": values["_time"],
+		}
+		for _, field := range []string{"load_state", "active_state", "sub_state", "result", "active", "failed", "restarts", "failed_at"} {
+			if value, ok := values[field]; ok && value != nil {
+				service[field] = value
+			}
+		}
+		services = append(services, service)
+	}
+
+	telemetry.ObserveStorage("query_services", start, result.Err())
+	if result.Err() != nil {
+		return nil, fmt.Errorf("query error: %w", result.Err())
+	}
+
+	return services, nil
+}
+
+// QueryChecks returns the most recent result of each synthetic check a
+// device ran in the last hour
+func (s *InfluxDBStorage) QueryChecks(ctx context.Context, deviceID string) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -1h)
+		  |> filter(fn: (r) => r["_measurement"] == "check")
+		  |> filter(fn: (r) => r["device_id"] == %s)
+		  |> last()
+		  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+		  |> group()
+		  |> sort(columns: ["check"])
+	`, s.config.InfluxDB.Bucket, fluxString(deviceID))
+
+	start := time.Now()
+	result, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		telemetry.ObserveStorage("query_checks", start, err)
+		return nil, fmt.Errorf("failed to query checks: %w", err)
+	}
+	defer result.Close()
+
+	checks := []map[string]interface{}{}
+	for result.Next() {
+		values := result.Record().Values()
+		check := map[string]interface{}{
+			"check":      values["check"],
+			"type":       values["type"],
+			"target":     values["target"],
+			"updated_at": values["_time"],
+		}
+		for _, field := range []string{"success", "latency_ms", "error", "status_code", "cert_expires_at", "addresses"} {
+			if value, ok := values[field]; ok && value != nil {
+				check[field] = value
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	telemetry.ObserveStorage("query_checks", start, result.Err())
+	if result.Err() != nil {
+		return nil, fmt.Errorf("query error: %w", result.Err())
+	}
+
+	return checks, nil
+}
+
+// QueryEvents returns the most recent log events a device forwarded in the
+// last 24 hours, newest first
+func (s *InfluxDBStorage) QueryEvents(ctx context.Context, deviceID string, limit int) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -24h)
+		  |> filter(fn: (r) => r["_measurement"] == "log_event")
+		  |> filter(fn: (r) => r["device_id"] == %s)
+		  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+		  |> group()
+		  |> sort(columns: ["_time"], desc: true)
+		  |> limit(n: %d)
+	`, s.config.InfluxDB.Bucket, fluxString(deviceID), limit)
+
+	start := time.Now()
+	result, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		telemetry.ObserveStorage("query_events", start, err)
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer result.Close()
+
+	events := []map[string]interface{}{}
+	for result.Next() {
+		values := result.Record().Values()
+		event := map[string]interface{}{
+			"timestamp": values["_time"],
+			"source":    values["source"],
+			"message":   values["message"],
+		}
+		for _, field := range []string{"pattern", "unit", "path"} {
+			if value, ok := values[field]; ok && value != nil && value != "" {
+				event[field] = value
+			}
+		}
+		events = append(events, event)
+	}
+
+	telemetry.ObserveStorage("query_events", start, result.Err())
+	if result.Err() != nil {
+		return nil, fmt.Errorf("query error: %w", result.Err())
+	}
+
+	return events, nil
+}
+
+// QueryProxiedDevices returns the devices an agent polled on their behalf in
+// the last hour, such as SNMP devices, with whether each was last reachable
+func (s *InfluxDBStorage) QueryProxiedDevices(ctx context.Context, proxyID string) ([]map[string]interface{}, error) {
+	query := fmt.Sprintf(`
+		from(bucket: "%s")
+		  |> range(start: -1h)
+		  |> filter(fn: (r) => r["_measurement"] == "heartbeat")
+		  |> filter(fn: (r) => r["proxy_id"] == %s)
+		  |> filter(fn: (r) => r["_field"] == "online")
+		  |> group(columns: ["device_id"])
+		  |> last()
+		  |> group()
+		  |> sort(columns: ["device_id"])
+	`, s.config.InfluxDB.Bucket, fluxString(proxyID))
+
+	start := time.Now()
+	result, err := s.queryAPI.Query(ctx, query)
+	if err != nil {
+		telemetry.ObserveStorage("query_proxied", start, err)
+		return nil, fmt.Errorf("failed to query proxied devices: %w", err)
+	}
+	defer result.Close()
+
+	devices := []map[string]interface{}{}
+	for result.Next() {
+		values := result.Record().Values()
+		devices = append(devices, map[string]interface{}{
+			"device_id":  values["device_id"],
+			"hostname":   values["hostname"],
+			"type":       values["version"],
+			"online":     values["_value"],
+			"updated_at": values["_time"],
+		})
+	}
+
+	telemetry.ObserveStorage("query_proxied", start, result.Err())
+	if result.Err() != nil {
+		return nil, fmt.Errorf("query error: %w", result.Err())
+	}
+
+	return devices, nil
+}
+
 // GetDeviceStatus checks if a device is online based on recent heartbeats
 func (s *InfluxDBStorage) GetDeviceStatus(ctx context.Context, deviceID string) (bool, error) {
 	query := fmt.Sprintf(`
 		from(bucket: "%s")
 		  |> range(start: -5m)
 		  |> filter(fn: (r) => r["_measurement"] == "heartbeat")
-		  |> filter(fn: (r) => r["device_id"] == "%s")
+		  |> filter(fn: (r) => r["device_id"] == %s)
 		  |> last()
-	`, s.config.InfluxDB.Bucket, deviceID)
+	`, s.config.InfluxDB.Bucket, fluxString(deviceID))
 
+	start := time.Now()
 	result, err := s.queryAPI.Query(ctx, query)
+	telemetry.ObserveStorage("query_status", start, err)
 	if err != nil {
 		return false, fmt.Errorf("failed to query device status: %w", err)
 	}
@@ -266,4 +955,3 @@ func (s *InfluxDBStorage) GetDeviceStatus(ctx context.Context, deviceID string)
 	// No recent heartbeat = offline
 	return false, nil
 }
-
@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "ninjait_monitoring"
+
+// Registry holds the service's own Prometheus metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts handled requests by route, method and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestSize observes request body sizes by route
+	HTTPRequestSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_size_bytes",
+		Help:      "HTTP request body sizes in bytes, by route.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8), // 256B .. 4MB
+	}, []string{"route"})
+
+	// HTTPRequestDuration observes request handling latency by route
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request handling latency in seconds, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// StorageDuration observes InfluxDB operation latency
+	StorageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "InfluxDB operation latency in seconds, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// StorageErrors counts failed InfluxDB operations
+	StorageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Failed InfluxDB operations, by operation.",
+	}, []string{"operation"})
+
+	// RateLimitRejections counts requests refused by the rate limiter
+	RateLimitRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter.",
+	})
+
+	// AuthFailures counts requests with a missing or invalid API key
+	AuthFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected because of a missing or invalid API key.",
+	})
+
+	// IngestRejected counts samples refused by ingest validation
+	IngestRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingest_rejected_samples_total",
+		Help:      "Ingested samples rejected as malformed or invalid, by kind.",
+	}, []string{"kind"})
+
+	// IngestTruncated counts list entries dropped from samples over the ingest limits
+	IngestTruncated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingest_truncated_entries_total",
+		Help:      "Entries dropped from samples with more than the ingest limit allows, by kind and field.",
+	}, []string{"kind", "field"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestSize,
+		HTTPRequestDuration,
+		StorageDuration,
+		StorageErrors,
+		RateLimitRejections,
+		AuthFailures,
+		IngestRejected,
+		IngestTruncated,
+	)
+}
+
+// RegisterWebSocketAgents exposes the number of agents connected over
+// WebSocket, read from count at scrape time. It is registered by whatever
+// tracks the connections, so the gauge never exists without a source.
+func RegisterWebSocketAgents(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_agents_connected",
+		Help:      "Agents currently connected over WebSocket.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// ObserveStorage records the latency and outcome of a storage operation
+func ObserveStorage(operation string, start time.Time, err error) {
+	StorageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		StorageErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,854 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/config"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/internal/telemetry"
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// Sample kinds used to label rejection counters
+const (
+	KindMetrics   = "metrics"
+	KindHeartbeat = "heartbeat"
+	KindRemote    = "remote_write"
+	KindEvents    = "events"
+	KindInventory = "inventory"
+)
+
+// deviceIDPattern restricts device IDs to characters that are safe as InfluxDB tags and URL path segments
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// validHeartbeatStatuses lists the statuses an agent may report
+var validHeartbeatStatuses = map[string]bool{
+	"online":   true,
+	"offline":  true,
+	"degraded": true,
+}
+
+// FieldError describes a single invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is a list of field-level validation errors
+type Errors []FieldError
+
+// Error implements the error interface
+func (e Errors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fe := range e {
+		parts = append(parts, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+func (e *Errors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validator sanitizes and validates ingested samples
+type Validator struct {
+	maxFutureSkew     time.Duration
+	maxSampleAge      time.Duration
+	maxDisks          int
+	maxCores          int
+	maxServices       int
+	maxSensors        int
+	maxContainers     int
+	maxChecks         int
+	maxScripts        int
+	maxPerfdata       int
+	maxSNMPInterfaces int
+	maxSNMPValues     int
+	maxEvents         int
+	maxEventLength    int
+	maxPackages       int
+	maxInventoryItems int
+	maxTagLength      int
+
+	rejected  sync.Map // kind -> *atomic.Int64
+	truncated sync.Map // "kind.field" -> *atomic.Int64
+}
+
+// NewValidator creates a validator using the configured ingest limits
+func NewValidator(cfg *config.Config) *Validator {
+	return &Validator{
+		maxFutureSkew:     time.Duration(cfg.Ingest.MaxFutureSkew) * time.Second,
+		maxSampleAge:      time.Duration(cfg.Ingest.MaxSampleAge) * time.Second,
+		maxDisks:          cfg.Ingest.MaxDisks,
+		maxCores:          cfg.Ingest.MaxCores,
+		maxServices:       cfg.Ingest.MaxServices,
+		maxSensors:        cfg.Ingest.MaxSensors,
+		maxContainers:     cfg.Ingest.MaxContainers,
+		maxChecks:         cfg.Ingest.MaxChecks,
+		maxScripts:        cfg.Ingest.MaxScripts,
+		maxPerfdata:       cfg.Ingest.MaxPerfdata,
+		maxSNMPInterfaces: cfg.Ingest.MaxSNMPInterfaces,
+		maxSNMPValues:     cfg.Ingest.MaxSNMPValues,
+		maxEvents:         cfg.Ingest.MaxEvents,
+		maxEventLength:    cfg.Ingest.MaxEventLength,
+		maxPackages:       cfg.Ingest.MaxPackages,
+		maxInventoryItems: cfg.Ingest.MaxInventoryItems,
+		maxTagLength:      cfg.Ingest.MaxTagLength,
+	}
+}
+
+// ValidateMetrics sanitizes tag values in place and validates a metrics sample
+func (v *Validator) ValidateMetrics(m *models.SystemMetrics, now time.Time) error {
+	var errs Errors
+
+	m.DeviceID = v.sanitize(m.DeviceID)
+	m.Hostname = v.sanitize(m.Hostname)
+	checkDeviceID(&errs, m.DeviceID)
+	v.checkTimestamp(&errs, m.Timestamp, now)
+
+	if m.ProxyID != "" {
+		m.ProxyID = v.sanitize(m.ProxyID)
+		if !deviceIDPattern.MatchString(m.ProxyID) {
+			errs.add("proxy_id", "may only contain letters, digits, '.', '_', ':' and '-'")
+		} else if m.ProxyID == m.DeviceID {
+			errs.add("proxy_id", "must differ from device_id")
+		}
+	}
+
+	if m.CPU != nil {
+		checkPercent(&errs, "cpu.usage_percent", m.CPU.UsagePercent)
+		if m.CPU.Cores < 0 || m.CPU.Cores > v.maxCores {
+			errs.add("cpu.cores", "must be between 0 and %d", v.maxCores)
+		}
+		if len(m.CPU.PerCore) > v.maxCores {
+			errs.add("cpu.per_core", "must have at most %d entries", v.maxCores)
+		} else {
+			for i, usage := range m.CPU.PerCore {
+				checkPercent(&errs, fmt.Sprintf("cpu.per_core[%d]", i), usage)
+			}
+		}
+		checkPercentStats(&errs, "cpu.usage_stats", m.CPU.UsageStats)
+	}
+
+	if m.Memory != nil {
+		checkPercent(&errs, "memory.used_percent", m.Memory.UsedPercent)
+		if m.Memory.Used > m.Memory.Total {
+			errs.add("memory.used", "must not exceed memory.total")
+		}
+		if m.Memory.SwapUsed > m.Memory.SwapTotal {
+			errs.add("memory.swap_used", "must not exceed memory.swap_total")
+		}
+		checkPercentStats(&errs, "memory.used_percent_stats", m.Memory.UsedPercentStats)
+		checkPercentStats(&errs, "memory.swap_used_percent_stats", m.Memory.SwapUsedPercentStats)
+	}
+
+	m.Disks = capEntries(v, KindMetrics, "disks", m.DeviceID, m.Disks, v.maxDisks)
+	seen := make(map[string]bool, len(m.Disks))
+	for i := range m.Disks {
+		disk := &m.Disks[i]
+		field := fmt.Sprintf("disks[%d]", i)
+
+		disk.Device = v.sanitize(disk.Device)
+		disk.Mountpoint = v.sanitize(disk.Mountpoint)
+		disk.FsType = v.sanitize(disk.FsType)
+
+		if disk.Mountpoint == "" {
+			errs.add(field+".mountpoint", "is required")
+		} else if seen[disk.Mountpoint] {
+			errs.add(field+".mountpoint", "duplicate mountpoint %q", disk.Mountpoint)
+		}
+		seen[disk.Mountpoint] = true
+
+		checkPercent(&errs, field+".used_percent", disk.UsedPercent)
+		if disk.Used > disk.Total {
+			errs.add(field+".used", "must not exceed total")
+		}
+		checkPercent(&errs, field+".inodes_used_percent", disk.InodesUsedPercent)
+		if disk.InodesUsed > disk.InodesTotal {
+			errs.add(field+".inodes_used", "must not exceed inodes_total")
+		}
+		checkPercentStats(&errs, field+".used_percent_stats", disk.UsedPercentStats)
+		checkPercentStats(&errs, field+".inodes_used_percent_stats", disk.InodesUsedPercentStats)
+	}
+
+	m.Services = capEntries(v, KindMetrics, "services", m.DeviceID, m.Services, v.maxServices)
+	seen = make(map[string]bool, len(m.Services))
+	for i := range m.Services {
+		service := &m.Services[i]
+		field := fmt.Sprintf("services[%d]", i)
+
+		service.Name = v.sanitize(service.Name)
+		service.LoadState = v.sanitize(service.LoadState)
+		service.ActiveState = v.sanitize(service.ActiveState)
+		service.SubState = v.sanitize(service.SubState)
+		service.Result = v.sanitize(service.Result)
+
+		if service.Name == "" {
+			errs.add(field+".name", "is required")
+		} else if seen[service.Name] {
+			errs.add(field+".name", "duplicate unit %q", service.Name)
+		}
+		seen[service.Name] = true
+
+		if service.ActiveState == "" {
+			errs.add(field+".active_state", "is required")
+		}
+	}
+
+	if m.Sensors != nil {
+		v.checkSensors(&errs, m.Sensors)
+	}
+
+	m.Containers = capEntries(v, KindMetrics, "containers", m.DeviceID, m.Containers, v.maxContainers)
+	seen = make(map[string]bool, len(m.Containers))
+	for i := range m.Containers {
+		container := &m.Containers[i]
+		field := fmt.Sprintf("containers[%d]", i)
+
+		container.ID = v.sanitize(container.ID)
+		container.Name = v.sanitize(container.Name)
+		container.Image = v.sanitize(container.Image)
+		container.Runtime = v.sanitize(container.Runtime)
+		container.State = v.sanitize(container.State)
+
+		if container.ID == "" {
+			errs.add(field+".id", "is required")
+		} else if seen[container.ID] {
+			errs.add(field+".id", "duplicate container %q", container.ID)
+		}
+		seen[container.ID] = true
+
+		checkFinite(&errs, field+".cpu_percent", container.CPUPercent)
+		checkFinite(&errs, field+".cpu_seconds", container.CPUSeconds)
+		if container.CPUPercent < 0 {
+			errs.add(field+".cpu_percent", "must not be negative")
+		}
+		if container.RestartCount < 0 {
+			errs.add(field+".restart_count", "must not be negative")
+		}
+	}
+
+	if len(m.Checks) > v.maxChecks {
+		errs.add("checks", "must have at most %d entries, got %d", v.maxChecks, len(m.Checks))
+	} else {
+		seen := make(map[string]bool, len(m.Checks))
+		for i := range m.Checks {
+			check := &m.Checks[i]
+			field := fmt.Sprintf("checks[%d]", i)
+
+			check.Name = v.sanitize(check.Name)
+			check.Type = strings.ToLower(v.sanitize(check.Type))
+			check.Target = v.sanitize(check.Target)
+
+			if check.Name == "" {
+				errs.add(field+".name", "is required")
+			} else if seen[check.Name] {
+				errs.add(field+".name", "duplicate check %q", check.Name)
+			}
+			seen[check.Name] = true
+
+			if check.Type == "" {
+				errs.add(field+".type", "is required")
+			}
+			checkFinite(&errs, field+".latency_ms", check.LatencyMs)
+			if check.LatencyMs < 0 {
+				errs.add(field+".latency_ms", "must not be negative")
+			}
+		}
+	}
+
+	if len(m.Scripts) > v.maxScripts {
+		errs.add("scripts", "must have at most %d entries, got %d", v.maxScripts, len(m.Scripts))
+	} else {
+		v.checkScripts(&errs, m.Scripts)
+	}
+
+	if m.SNMP != nil {
+		v.checkSNMP(&errs, m.SNMP)
+	}
+
+	if m.System != nil {
+		m.System.OS = v.sanitize(m.System.OS)
+		m.System.Platform = v.sanitize(m.System.Platform)
+		m.System.PlatformVersion = v.sanitize(m.System.PlatformVersion)
+		m.System.KernelVersion = v.sanitize(m.System.KernelVersion)
+		m.System.KernelArch = v.sanitize(m.System.KernelArch)
+		m.System.Hostname = v.sanitize(m.System.Hostname)
+		if m.System.Uptime < 0 {
+			errs.add("system.uptime", "must not be negative")
+		}
+		if m.System.NumProcs < 0 {
+			errs.add("system.num_procs", "must not be negative")
+		}
+	}
+
+	if len(errs) > 0 {
+		v.reject(KindMetrics)
+		return errs
+	}
+	return nil
+}
+
+// checkSensors sanitizes sensor keys and checks each reading is finite
+func (v *Validator) checkSensors(errs *Errors, s *models.SensorMetrics) {
+	// Keys become tags, so they must be present and unique within a kind
+	seen := make(map[string]bool)
+	checkKey := func(kind, field string, key *string) {
+		*key = v.sanitize(*key)
+		switch {
+		case *key == "":
+			errs.add(field+".key", "is required")
+		case seen[kind+"/"+*key]:
+			errs.add(field+".key", "duplicate sensor %q", *key)
+		}
+		seen[kind+"/"+*key] = true
+	}
+
+	if len(s.Temperatures) > v.maxSensors {
+		errs.add("sensors.temperatures", "must have at most %d entries, got %d", v.maxSensors, len(s.Temperatures))
+	} else {
+		for i := range s.Temperatures {
+			t := &s.Temperatures[i]
+			field := fmt.Sprintf("sensors.temperatures[%d]", i)
+			checkKey("temperature", field, &t.Key)
+			checkFinite(errs, field+".celsius", t.Celsius)
+			checkFinite(errs, field+".high", t.High)
+			checkFinite(errs, field+".critical", t.Critical)
+		}
+	}
+
+	if len(s.Fans) > v.maxSensors {
+		errs.add("sensors.fans", "must have at most %d entries, got %d", v.maxSensors, len(s.Fans))
+	} else {
+		for i := range s.Fans {
+			fan := &s.Fans[i]
+			field := fmt.Sprintf("sensors.fans[%d]", i)
+			checkKey("fan", field, &fan.Key)
+			checkFinite(errs, field+".rpm", fan.RPM)
+			if fan.RPM < 0 {
+				errs.add(field+".rpm", "must not be negative")
+			}
+		}
+	}
+
+	if len(s.Power) > v.maxSensors {
+		errs.add("sensors.power", "must have at most %d entries, got %d", v.maxSensors, len(s.Power))
+	} else {
+		for i := range s.Power {
+			p := &s.Power[i]
+			field := fmt.Sprintf("sensors.power[%d]", i)
+			checkKey("power", field, &p.Key)
+			checkFinite(errs, field+".watts", p.Watts)
+		}
+	}
+}
+
+// checkScripts sanitizes check script results and their perfdata
+func (v *Validator) checkScripts(errs *Errors, scripts []models.ScriptResult) {
+	seen := make(map[string]bool, len(scripts))
+	for i := range scripts {
+		script := &scripts[i]
+		field := fmt.Sprintf("scripts[%d]", i)
+
+		script.Name = v.sanitize(script.Name)
+		script.Status = strings.ToUpper(v.sanitize(script.Status))
+		script.Output = v.sanitize(script.Output)
+
+		if script.Name == "" {
+			errs.add(field+".name", "is required")
+		} else if seen[script.Name] {
+			errs.add(field+".name", "duplicate script %q", script.Name)
+		}
+		seen[script.Name] = true
+
+		switch script.Status {
+		case "OK", "WARNING", "CRITICAL", "UNKNOWN":
+		default:
+			errs.add(field+".status", "must be OK, WARNING, CRITICAL or UNKNOWN, got %q", script.Status)
+		}
+		checkFinite(errs, field+".duration_ms", script.DurationMs)
+
+		if len(script.Perfdata) > v.maxPerfdata {
+			errs.add(field+".perfdata", "must have at most %d entries, got %d", v.maxPerfdata, len(script.Perfdata))
+			continue
+		}
+		labels := make(map[string]bool, len(script.Perfdata))
+		for j := range script.Perfdata {
+			perf := &script.Perfdata[j]
+			perfField := fmt.Sprintf("%s.perfdata[%d]", field, j)
+
+			perf.Label = v.sanitize(perf.Label)
+			perf.Unit = v.sanitize(perf.Unit)
+			perf.Warn = v.sanitize(perf.Warn)
+			perf.Crit = v.sanitize(perf.Crit)
+
+			if perf.Label == "" {
+				errs.add(perfField+".label", "is required")
+			} else if labels[perf.Label] {
+				errs.add(perfField+".label", "duplicate label %q", perf.Label)
+			}
+			labels[perf.Label] = true
+
+			checkFinite(errs, perfField+".value", perf.Value)
+			if perf.Min != nil {
+				checkFinite(errs, perfField+".min", *perf.Min)
+			}
+			if perf.Max != nil {
+				checkFinite(errs, perfField+".max", *perf.Max)
+			}
+		}
+	}
+}
+
+// checkSNMP sanitizes the data an agent polled from an SNMP device
+func (v *Validator) checkSNMP(errs *Errors, s *models.SNMPMetrics) {
+	s.Address = v.sanitize(s.Address)
+	s.Error = v.sanitize(s.Error)
+	s.SysName = v.sanitize(s.SysName)
+	s.SysDescr = v.sanitize(s.SysDescr)
+	s.SysObjectID = v.sanitize(s.SysObjectID)
+
+	if len(s.Interfaces) > v.maxSNMPInterfaces {
+		errs.add("snmp.interfaces", "must have at most %d entries, got %d", v.maxSNMPInterfaces, len(s.Interfaces))
+	} else {
+		seen := make(map[int]bool, len(s.Interfaces))
+		for i := range s.Interfaces {
+			iface := &s.Interfaces[i]
+			field := fmt.Sprintf("snmp.interfaces[%d]", i)
+
+			iface.Name = v.sanitize(iface.Name)
+			iface.Alias = v.sanitize(iface.Alias)
+
+			if iface.Index < 1 {
+				errs.add(field+".index", "must be positive")
+			} else if seen[iface.Index] {
+				errs.add(field+".index", "duplicate interface %d", iface.Index)
+			}
+			seen[iface.Index] = true
+		}
+	}
+
+	if len(s.Values) > v.maxSNMPValues {
+		errs.add("snmp.values", "must have at most %d entries, got %d", v.maxSNMPValues, len(s.Values))
+	} else {
+		seen := make(map[string]bool, len(s.Values))
+		for i := range s.Values {
+			value := &s.Values[i]
+			field := fmt.Sprintf("snmp.values[%d]", i)
+
+			value.Name = v.sanitize(value.Name)
+			value.OID = v.sanitize(value.OID)
+			value.Text = v.sanitize(value.Text)
+			value.Error = v.sanitize(value.Error)
+
+			if value.Name == "" {
+				errs.add(field+".name", "is required")
+			} else if seen[value.Name] {
+				errs.add(field+".name", "duplicate value %q", value.Name)
+			}
+			seen[value.Name] = true
+
+			if value.Value != nil {
+				checkFinite(errs, field+".value", *value.Value)
+			}
+		}
+	}
+}
+
+// ValidateHeartbeat sanitizes tag values in place and validates a heartbeat
+func (v *Validator) ValidateHeartbeat(h *models.Heartbeat, now time.Time) error {
+	var errs Errors
+
+	h.DeviceID = v.sanitize(h.DeviceID)
+	h.Hostname = v.sanitize(h.Hostname)
+	h.Status = strings.ToLower(v.sanitize(h.Status))
+	h.Version = v.sanitize(h.Version)
+	h.TenantID = v.sanitize(h.TenantID)
+	h.Group = v.sanitize(h.Group)
+
+	checkDeviceID(&errs, h.DeviceID)
+	v.checkTimestamp(&errs, h.Timestamp, now)
+
+	if !validHeartbeatStatuses[h.Status] {
+		errs.add("status", "must be one of online, offline, degraded")
+	}
+	if h.PolicyVersion < 0 {
+		errs.add("policy_version", "must not be negative")
+	}
+	if r := h.Resources; r != nil {
+		r.ThrottleReason = v.sanitize(r.ThrottleReason)
+		checkFinite(&errs, "resources.cpu_percent", r.CPUPercent)
+		if r.CPUPercent < 0 {
+			errs.add("resources.cpu_percent", "must not be negative")
+		}
+		if r.Goroutines < 0 {
+			errs.add("resources.goroutines", "must not be negative")
+		}
+		if r.OpenFDs < 0 {
+			errs.add("resources.open_fds", "must not be negative")
+		}
+	}
+
+	if len(errs) > 0 {
+		v.reject(KindHeartbeat)
+		return errs
+	}
+	return nil
+}
+
+// ValidateEvents sanitizes tag values in place and validates a batch of log
+// events. Messages longer than the configured limit are truncated.
+func (v *Validator) ValidateEvents(b *models.EventBatch, now time.Time) error {
+	var errs Errors
+
+	b.DeviceID = v.sanitize(b.DeviceID)
+	b.Hostname = v.sanitize(b.Hostname)
+	checkDeviceID(&errs, b.DeviceID)
+	v.checkTimestamp(&errs, b.Timestamp, now)
+
+	if len(b.Events) > v.maxEvents {
+		errs.add("events", "must have at most %d entries, got %d", v.maxEvents, len(b.Events))
+	} else {
+		for i := range b.Events {
+			event := &b.Events[i]
+			field := fmt.Sprintf("events[%d]", i)
+
+			event.Source = v.sanitize(event.Source)
+			event.Path = v.sanitize(event.Path)
+			event.Unit = v.sanitize(event.Unit)
+			event.Pattern = v.sanitize(event.Pattern)
+			event.Message = sanitizeText(event.Message, v.maxEventLength)
+
+			if event.Source == "" {
+				errs.add(field+".source", "is required")
+			}
+			if event.Message == "" {
+				errs.add(field+".message", "is required")
+			}
+			if event.Timestamp.IsZero() {
+				event.Timestamp = b.Timestamp
+			} else {
+				v.checkTimestamp(&errs, event.Timestamp, now)
+			}
+		}
+	}
+
+	if len(b.Dropped) > v.maxEvents {
+		errs.add("dropped", "must have at most %d entries, got %d", v.maxEvents, len(b.Dropped))
+	} else {
+		dropped := make(map[string]int, len(b.Dropped))
+		for source, count := range b.Dropped {
+			source = v.sanitize(source)
+			if source == "" {
+				errs.add("dropped", "source is required")
+			}
+			if count < 0 {
+				errs.add("dropped."+source, "must not be negative")
+			}
+			dropped[source] += count
+		}
+		b.Dropped = dropped
+	}
+
+	if len(errs) > 0 {
+		v.reject(KindEvents)
+		return errs
+	}
+	return nil
+}
+
+// ValidateInventory sanitizes text in place and validates a device inventory
+func (v *Validator) ValidateInventory(inv *models.Inventory, now time.Time) error {
+	var errs Errors
+
+	inv.DeviceID = v.sanitize(inv.DeviceID)
+	inv.Hostname = v.sanitize(inv.Hostname)
+	checkDeviceID(&errs, inv.DeviceID)
+	v.checkTimestamp(&errs, inv.Timestamp, now)
+
+	hw := &inv.Hardware
+	hw.CPU.Model = v.sanitize(hw.CPU.Model)
+	hw.CPU.Vendor = v.sanitize(hw.CPU.Vendor)
+	if hw.CPU.Sockets < 0 || hw.CPU.Sockets > v.maxCores {
+		errs.add("hardware.cpu.sockets", "must be between 0 and %d", v.maxCores)
+	}
+	if hw.CPU.Cores < 0 || hw.CPU.Cores > v.maxCores {
+		errs.add("hardware.cpu.cores", "must be between 0 and %d", v.maxCores)
+	}
+	if hw.CPU.Threads < 0 || hw.CPU.Threads > v.maxCores {
+		errs.add("hardware.cpu.threads", "must be between 0 and %d", v.maxCores)
+	}
+	for _, value := range []*string{
+		&hw.BIOS.Vendor, &hw.BIOS.Version, &hw.BIOS.Date,
+		&hw.System.Manufacturer, &hw.System.Product, &hw.System.Serial, &hw.System.UUID,
+	} {
+		*value = v.sanitize(*value)
+	}
+
+	if len(hw.Memory) > v.maxInventoryItems {
+		errs.add("hardware.memory", "must have at most %d entries, got %d", v.maxInventoryItems, len(hw.Memory))
+	} else {
+		for i := range hw.Memory {
+			module := &hw.Memory[i]
+			for _, value := range []*string{&module.Locator, &module.Type, &module.Manufacturer, &module.Serial, &module.PartNumber} {
+				*value = v.sanitize(*value)
+			}
+			if module.Speed < 0 {
+				errs.add(fmt.Sprintf("hardware.memory[%d].speed", i), "must not be negative")
+			}
+		}
+	}
+
+	if len(hw.Disks) > v.maxInventoryItems {
+		errs.add("hardware.disks", "must have at most %d entries, got %d", v.maxInventoryItems, len(hw.Disks))
+	} else {
+		for i := range hw.Disks {
+			disk := &hw.Disks[i]
+			disk.Name = v.sanitize(disk.Name)
+			disk.Model = v.sanitize(disk.Model)
+			disk.Serial = v.sanitize(disk.Serial)
+			if disk.Name == "" {
+				errs.add(fmt.Sprintf("hardware.disks[%d].name", i), "is required")
+			}
+		}
+	}
+
+	if len(hw.NICs) > v.maxInventoryItems {
+		errs.add("hardware.nics", "must have at most %d entries, got %d", v.maxInventoryItems, len(hw.NICs))
+	} else {
+		for i := range hw.NICs {
+			nic := &hw.NICs[i]
+			field := fmt.Sprintf("hardware.nics[%d]", i)
+			nic.Name = v.sanitize(nic.Name)
+			nic.MAC = v.sanitize(nic.MAC)
+			nic.Driver = v.sanitize(nic.Driver)
+			if nic.Name == "" {
+				errs.add(field+".name", "is required")
+			}
+			if nic.MTU < 0 {
+				errs.add(field+".mtu", "must not be negative")
+			}
+			if nic.SpeedMbps < 0 {
+				errs.add(field+".speed_mbps", "must not be negative")
+			}
+		}
+	}
+
+	if len(inv.Packages) > v.maxPackages {
+		errs.add("packages", "must have at most %d entries, got %d", v.maxPackages, len(inv.Packages))
+	} else {
+		for i := range inv.Packages {
+			pkg := &inv.Packages[i]
+			field := fmt.Sprintf("packages[%d]", i)
+			pkg.Name = v.sanitize(pkg.Name)
+			pkg.Version = v.sanitize(pkg.Version)
+			pkg.Arch = v.sanitize(pkg.Arch)
+			pkg.Manager = v.sanitize(pkg.Manager)
+			if pkg.Name == "" {
+				errs.add(field+".name", "is required")
+			}
+			if pkg.Manager == "" {
+				errs.add(field+".manager", "is required")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		v.reject(KindInventory)
+		return errs
+	}
+	return nil
+}
+
+// ValidateRemoteSample sanitizes tag values in place and validates a remote-write sample
+func (v *Validator) ValidateRemoteSample(r *models.RemoteSample, now time.Time) error {
+	var errs Errors
+
+	r.DeviceID = v.sanitize(r.DeviceID)
+	r.Hostname = v.sanitize(r.Hostname)
+	for name, value := range r.Labels {
+		r.Labels[name] = v.sanitize(value)
+	}
+
+	checkDeviceID(&errs, r.DeviceID)
+	v.checkTimestamp(&errs, r.Timestamp, now)
+	if math.IsInf(r.Value, 0) {
+		errs.add("value", "must be finite")
+	}
+
+	if len(errs) > 0 {
+		v.reject(KindRemote)
+		return errs
+	}
+	return nil
+}
+
+// Reject records a sample of the given kind that was refused before validation, e.g. an unparsable body
+func (v *Validator) Reject(kind string) {
+	v.reject(kind)
+}
+
+// RejectedCounts returns the number of rejected samples per kind
+func (v *Validator) RejectedCounts() map[string]int64 {
+	counts := map[string]int64{
+		KindMetrics:   0,
+		KindHeartbeat: 0,
+		KindRemote:    0,
+		KindEvents:    0,
+		KindInventory: 0,
+	}
+	v.rejected.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
+
+// TruncatedCounts returns the number of entries dropped from samples over
+// the ingest limits, per kind and field (e.g. "metrics.disks")
+func (v *Validator) TruncatedCounts() map[string]int64 {
+	counts := make(map[string]int64)
+	v.truncated.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
+
+// Truncate records entries of the given kind and field that were dropped
+// to stay within the ingest limits
+func (v *Validator) Truncate(kind, field string, dropped int) {
+	counter, _ := v.truncated.LoadOrStore(kind+"."+field, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(int64(dropped))
+	telemetry.IngestTruncated.WithLabelValues(kind, field).Add(float64(dropped))
+}
+
+// capEntries keeps the first max entries of a list, counting and logging the
+// rest as dropped, so one oversized list does not cost the whole sample
+func capEntries[T any](v *Validator, kind, field, deviceID string, list []T, max int) []T {
+	if len(list) <= max {
+		return list
+	}
+	dropped := len(list) - max
+	v.Truncate(kind, field, dropped)
+
+	log.WithFields(log.Fields{
+		"device_id": deviceID,
+		"field":     field,
+		"limit":     max,
+		"dropped":   dropped,
+	}).Warn("Sample has more entries than the ingest limit, dropping the rest")
+
+	return list[:max]
+}
+
+func (v *Validator) reject(kind string) {
+	counter, _ := v.rejected.LoadOrStore(kind, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+	telemetry.IngestRejected.WithLabelValues(kind).Inc()
+}
+
+// ValidDeviceID reports whether deviceID is a well-formed device ID, e.g.
+// before it is taken from a request path into a query
+func ValidDeviceID(deviceID string) bool {
+	return deviceIDPattern.MatchString(deviceID)
+}
+
+func checkDeviceID(errs *Errors, deviceID string) {
+	switch {
+	case deviceID == "":
+		errs.add("device_id", "is required")
+	case !deviceIDPattern.MatchString(deviceID):
+		errs.add("device_id", "may only contain letters, digits, '.', '_', ':' and '-'")
+	}
+}
+
+func (v *Validator) checkTimestamp(errs *Errors, ts, now time.Time) {
+	if ts.After(now.Add(v.maxFutureSkew)) {
+		errs.add("timestamp", "is more than %s in the future", v.maxFutureSkew)
+	}
+	if ts.Before(now.Add(-v.maxSampleAge)) {
+		errs.add("timestamp", "is more than %s in the past", v.maxSampleAge)
+	}
+}
+
+// sanitize trims whitespace, strips control characters and truncates a tag value
+func (v *Validator) sanitize(value string) string {
+	return sanitizeText(value, v.maxTagLength)
+}
+
+// sanitizeText trims whitespace, strips control characters and truncates
+// value to max bytes
+func sanitizeText(value string, max int) string {
+	value = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value))
+
+	if len(value) > max {
+		// Truncate on a rune boundary
+		cut := max
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		value = value[:cut]
+	}
+	return value
+}
+
+func checkFinite(errs *Errors, field string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		errs.add(field, "must be a finite number")
+	}
+}
+
+func checkPercent(errs *Errors, field string, value float64) {
+	if math.IsNaN(value) || value < 0 || value > 100 {
+		errs.add(field, "must be between 0 and 100")
+	}
+}
+
+func checkRate(errs *Errors, field string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		errs.add(field, "must be a finite number of at least 0")
+	}
+}
+
+// checkPercentStats checks the summary of sampled percentages, when present
+func checkPercentStats(errs *Errors, field string, stats *models.SampleStats) {
+	checkStats(errs, field, stats, checkPercent)
+}
+
+// checkRateStats checks the summary of sampled rates, when present
+func checkRateStats(errs *Errors, field string, stats *models.SampleStats) {
+	checkStats(errs, field, stats, checkRate)
+}
+
+// checkStats checks a summary of sampled values, each with check
+func checkStats(errs *Errors, field string, stats *models.SampleStats, check func(*Errors, string, float64)) {
+	if stats == nil {
+		return
+	}
+	if stats.Count < 1 {
+		errs.add(field+".count", "must be at least 1")
+	}
+	check(errs, field+".min", stats.Min)
+	check(errs, field+".max", stats.Max)
+	check(errs, field+".mean", stats.Mean)
+	check(errs, field+".p95", stats.P95)
+	check(errs, field+".last", stats.Last)
+	if stats.Min > stats.Max || stats.Mean < stats.Min || stats.Mean > stats.Max ||
+		stats.P95 < stats.Min || stats.P95 > stats.Max || stats.Last < stats.Min || stats.Last > stats.Max {
+		errs.add(field, "must have mean, p95 and last between min and max")
+	}
+}
@@ -0,0 +1,188 @@
+package validation
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yossibmoha/NinjaIT/backend/services/monitoring/pkg/models"
+)
+
+// fields returns the names of the fields in errs
+func fields(errs Errors) []string {
+	var names []string
+	for _, fe := range errs {
+		names = append(names, fe.Field)
+	}
+	return names
+}
+
+func TestCheckStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats *models.SampleStats
+		want  []string
+	}{
+		{
+			name:  "absent",
+			stats: nil,
+		},
+		{
+			name:  "ordered",
+			stats: &models.SampleStats{Min: 10, Max: 90, Mean: 40, P95: 85, Last: 20, Count: 12},
+		},
+		{
+			name:  "single sample",
+			stats: &models.SampleStats{Min: 5, Max: 5, Mean: 5, P95: 5, Last: 5, Count: 1},
+		},
+		{
+			name:  "no samples",
+			stats: &models.SampleStats{Min: 5, Max: 5, Mean: 5, P95: 5, Last: 5},
+			want:  []string{"cpu.count"},
+		},
+		{
+			name:  "min above max",
+			stats: &models.SampleStats{Min: 60, Max: 50, Mean: 55, P95: 55, Last: 55, Count: 2},
+			want:  []string{"cpu"},
+		},
+		{
+			name:  "mean below min",
+			stats: &models.SampleStats{Min: 10, Max: 50, Mean: 5, P95: 40, Last: 20, Count: 2},
+			want:  []string{"cpu"},
+		},
+		{
+			name:  "p95 above max",
+			stats: &models.SampleStats{Min: 10, Max: 50, Mean: 20, P95: 51, Last: 20, Count: 2},
+			want:  []string{"cpu"},
+		},
+		{
+			name:  "last above max",
+			stats: &models.SampleStats{Min: 10, Max: 50, Mean: 20, P95: 40, Last: 60, Count: 2},
+			want:  []string{"cpu"},
+		},
+		{
+			name:  "value out of range",
+			stats: &models.SampleStats{Min: -1, Max: 50, Mean: 20, P95: 40, Last: 30, Count: 2},
+			want:  []string{"cpu.min"},
+		},
+		{
+			name:  "NaN",
+			stats: &models.SampleStats{Min: 10, Max: 50, Mean: math.NaN(), P95: 40, Last: 30, Count: 2},
+			want:  []string{"cpu.mean"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs Errors
+			checkPercentStats(&errs, "cpu", tt.stats)
+			if got := fields(errs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkPercentStats() errors = %v, want fields %v", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapEntries(t *testing.T) {
+	tests := []struct {
+		name        string
+		list        []int
+		max         int
+		want        []int
+		wantDropped int64
+	}{
+		{name: "empty", list: nil, max: 2, want: nil},
+		{name: "under the limit", list: []int{1}, max: 2, want: []int{1}},
+		{name: "at the limit", list: []int{1, 2}, max: 2, want: []int{1, 2}},
+		{name: "over the limit", list: []int{1, 2, 3, 4, 5}, max: 2, want: []int{1, 2}, wantDropped: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{}
+			got := capEntries(v, KindMetrics, "disks", "dev-1", tt.list, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("capEntries() = %v, want %v", got, tt.want)
+			}
+			if dropped := v.TruncatedCounts()["metrics.disks"]; dropped != tt.wantDropped {
+				t.Errorf("capEntries() counted %d dropped, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestValidateRemoteSample(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		sample models.RemoteSample
+		want   []string
+	}{
+		{
+			name:   "valid",
+			sample: models.RemoteSample{DeviceID: "web1", Value: 1.5, Timestamp: now},
+		},
+		{
+			name:   "positive infinity",
+			sample: models.RemoteSample{DeviceID: "web1", Value: math.Inf(1), Timestamp: now},
+			want:   []string{"value"},
+		},
+		{
+			name:   "negative infinity",
+			sample: models.RemoteSample{DeviceID: "web1", Value: math.Inf(-1), Timestamp: now},
+			want:   []string{"value"},
+		},
+		{
+			name:   "invalid device",
+			sample: models.RemoteSample{DeviceID: "web 1/x", Value: 1, Timestamp: now},
+			want:   []string{"device_id"},
+		},
+		{
+			name:   "too far in the future",
+			sample: models.RemoteSample{DeviceID: "web1", Value: 1, Timestamp: now.Add(time.Hour)},
+			want:   []string{"timestamp"},
+		},
+		{
+			name:   "too old",
+			sample: models.RemoteSample{DeviceID: "web1", Value: 1, Timestamp: now.Add(-48 * time.Hour)},
+			want:   []string{"timestamp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{maxFutureSkew: 5 * time.Minute, maxSampleAge: 24 * time.Hour, maxTagLength: 64}
+			err := v.ValidateRemoteSample(&tt.sample, now)
+			var got []string
+			if err != nil {
+				got = fields(err.(Errors))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateRemoteSample() error = %v, want fields %v", err, tt.want)
+			}
+			if rejected := v.RejectedCounts()[KindRemote]; (rejected > 0) != (tt.want != nil) {
+				t.Errorf("ValidateRemoteSample() counted %d rejections", rejected)
+			}
+		})
+	}
+}
+
+func TestValidateRemoteSampleSanitizes(t *testing.T) {
+	v := &Validator{maxFutureSkew: time.Minute, maxSampleAge: time.Hour, maxTagLength: 8}
+	now := time.Now()
+	sample := models.RemoteSample{
+		DeviceID:  " web1\n",
+		Hostname:  "web1.example.com",
+		Labels:    map[string]string{"job": "node\x00exporter"},
+		Value:     1,
+		Timestamp: now,
+	}
+	if err := v.ValidateRemoteSample(&sample, now); err != nil {
+		t.Fatalf("ValidateRemoteSample() error = %v", err)
+	}
+	if sample.DeviceID != "web1" || sample.Hostname != "web1.exa" || sample.Labels["job"] != "nodeexpo" {
+		t.Errorf("ValidateRemoteSample() sanitized to %+v", sample)
+	}
+}
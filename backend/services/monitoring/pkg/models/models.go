@@ -4,14 +4,25 @@ import "time"
 
 // SystemMetrics represents collected system metrics
 type SystemMetrics struct {
-	Timestamp time.Time       `json:"timestamp"`
-	DeviceID  string          `json:"device_id"`
-	Hostname  string          `json:"hostname"`
-	CPU       *CPUMetrics     `json:"cpu,omitempty"`
-	Memory    *MemoryMetrics  `json:"memory,omitempty"`
-	Disks     []DiskMetrics   `json:"disks,omitempty"`
-	Network   *NetworkMetrics `json:"network,omitempty"`
-	System    *SystemInfo     `json:"system,omitempty"`
+	Timestamp  time.Time          `json:"timestamp"`
+	DeviceID   string             `json:"device_id"`
+	Hostname   string             `json:"hostname"`
+	CPU        *CPUMetrics        `json:"cpu,omitempty"`
+	Memory     *MemoryMetrics     `json:"memory,omitempty"`
+	Disks      []DiskMetrics      `json:"disks,omitempty"`
+	Network    *NetworkMetrics    `json:"network,omitempty"`
+	System     *SystemInfo        `json:"system,omitempty"`
+	Services   []ServiceStatus    `json:"services,omitempty"`
+	Sensors    *SensorMetrics     `json:"sensors,omitempty"`
+	Containers []ContainerMetrics `json:"containers,omitempty"`
+	Checks     []CheckResult      `json:"checks,omitempty"`
+	Scripts    []ScriptResult     `json:"scripts,omitempty"`
+	SNMP       *SNMPMetrics       `json:"snmp,omitempty"`
+
+	// ProxyID is the device ID of the agent that collected these metrics on
+	// behalf of a device that cannot run an agent itself, such as a switch
+	// polled over SNMP. Empty when the device reports for itself.
+	ProxyID string `json:"proxy_id,omitempty"`
 }
 
 // CPUMetrics represents CPU metrics
@@ -19,6 +30,20 @@ type CPUMetrics struct {
 	UsagePercent float64   `json:"usage_percent"`
 	Cores        int       `json:"cores"`
 	PerCore      []float64 `json:"per_core,omitempty"`
+
+	// UsageStats summarizes the usage sampled since the previous upload
+	UsageStats *SampleStats `json:"usage_stats,omitempty"`
+}
+
+// SampleStats summarizes the samples an agent took of one value between two
+// uploads, so short spikes are reported without uploading every sample
+type SampleStats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	P95   float64 `json:"p95"`
+	Last  float64 `json:"last"`
+	Count int     `json:"count"`
 }
 
 // MemoryMetrics represents memory metrics
@@ -31,6 +56,11 @@ type MemoryMetrics struct {
 	SwapTotal   uint64  `json:"swap_total"`
 	SwapUsed    uint64  `json:"swap_used"`
 	SwapFree    uint64  `json:"swap_free"`
+
+	// UsedPercentStats and SwapUsedPercentStats summarize the usage sampled
+	// since the previous upload
+	UsedPercentStats     *SampleStats `json:"used_percent_stats,omitempty"`
+	SwapUsedPercentStats *SampleStats `json:"swap_used_percent_stats,omitempty"`
 }
 
 // DiskMetrics represents disk metrics
@@ -42,6 +72,18 @@ type DiskMetrics struct {
 	Used        uint64  `json:"used"`
 	Free        uint64  `json:"free"`
 	UsedPercent float64 `json:"used_percent"`
+
+	// Inode usage, absent on filesystems without a fixed inode count such as
+	// Windows volumes
+	InodesTotal       uint64  `json:"inodes_total,omitempty"`
+	InodesUsed        uint64  `json:"inodes_used,omitempty"`
+	InodesFree        uint64  `json:"inodes_free,omitempty"`
+	InodesUsedPercent float64 `json:"inodes_used_percent,omitempty"`
+
+	// UsedPercentStats and InodesUsedPercentStats summarize the usage
+	// sampled since the previous upload
+	UsedPercentStats       *SampleStats `json:"used_percent_stats,omitempty"`
+	InodesUsedPercentStats *SampleStats `json:"inodes_used_percent_stats,omitempty"`
 }
 
 // NetworkMetrics represents network metrics
@@ -54,6 +96,11 @@ type NetworkMetrics struct {
 	ErrorsOut   uint64 `json:"errors_out"`
 	DropsIn     uint64 `json:"drops_in"`
 	DropsOut    uint64 `json:"drops_out"`
+
+	// BytesSentRateStats and BytesRecvRateStats summarize the throughput in
+	// bytes per second sampled since the previous upload
+	BytesSentRateStats *SampleStats `json:"bytes_sent_rate_stats,omitempty"`
+	BytesRecvRateStats *SampleStats `json:"bytes_recv_rate_stats,omitempty"`
 }
 
 // SystemInfo represents system information
@@ -69,12 +116,358 @@ type SystemInfo struct {
 	NumProcs        int       `json:"num_procs"`
 }
 
+// ContainerMetrics represents the resource usage of a running container
+type ContainerMetrics struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Image           string     `json:"image,omitempty"`
+	Runtime         string     `json:"runtime"`         // docker, podman, containerd, cri-o, kubernetes or unknown
+	State           string     `json:"state,omitempty"` // from the Docker API, when enabled
+	CPUPercent      float64    `json:"cpu_percent"`     // 100 is one full core; 0 on the first collection
+	CPUSeconds      float64    `json:"cpu_seconds"`     // cumulative CPU time
+	MemoryUsage     uint64     `json:"memory_usage"`    // excluding inactive page cache
+	MemoryLimit     uint64     `json:"memory_limit"`    // 0 when unlimited
+	BlockReadBytes  uint64     `json:"block_read_bytes"`
+	BlockWriteBytes uint64     `json:"block_write_bytes"`
+	NetworkRxBytes  uint64     `json:"network_rx_bytes"`
+	NetworkTxBytes  uint64     `json:"network_tx_bytes"`
+	Pids            uint64     `json:"pids"`
+	RestartCount    int        `json:"restart_count"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+}
+
+// CheckResult is the outcome of a synthetic check run by the agent
+type CheckResult struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"` // tcp, http, dns or icmp
+	Target        string     `json:"target"`
+	Success       bool       `json:"success"`
+	LatencyMs     float64    `json:"latency_ms"`
+	Error         string     `json:"error,omitempty"`
+	StatusCode    int        `json:"status_code,omitempty"`     // http
+	CertExpiresAt *time.Time `json:"cert_expires_at,omitempty"` // https: leaf certificate expiry
+	Addresses     []string   `json:"addresses,omitempty"`       // dns: resolved addresses
+}
+
+// ScriptResult is the outcome of a Nagios-compatible check script
+type ScriptResult struct {
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`    // OK, WARNING, CRITICAL or UNKNOWN
+	ExitCode   int         `json:"exit_code"` // -1 when the script did not run to completion
+	Output     string      `json:"output"`    // first line of output, without perfdata
+	DurationMs float64     `json:"duration_ms"`
+	Perfdata   []PerfValue `json:"perfdata,omitempty"`
+}
+
+// PerfValue is one performance data value reported by a check script
+type PerfValue struct {
+	Label string   `json:"label"`
+	Value float64  `json:"value"`
+	Unit  string   `json:"unit,omitempty"` // s, ms, us, %, B, KB, MB, TB, c or empty
+	Warn  string   `json:"warn,omitempty"` // threshold range as the script reported it
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// SNMPMetrics represents what an agent polled from a network device over SNMP
+type SNMPMetrics struct {
+	Address     string          `json:"address"`
+	Reachable   bool            `json:"reachable"`
+	Error       string          `json:"error,omitempty"`
+	SysName     string          `json:"sys_name,omitempty"`
+	SysDescr    string          `json:"sys_descr,omitempty"`
+	SysObjectID string          `json:"sys_object_id,omitempty"`
+	Uptime      uint64          `json:"uptime"` // seconds, from sysUpTime
+	Interfaces  []SNMPInterface `json:"interfaces,omitempty"`
+	Values      []SNMPValue     `json:"values,omitempty"`
+}
+
+// SNMPInterface represents one row of the device's interface table
+type SNMPInterface struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"` // ifName, or ifDescr when the device has no ifXTable
+	Alias       string `json:"alias,omitempty"`
+	AdminUp     bool   `json:"admin_up"`
+	OperUp      bool   `json:"oper_up"`
+	Speed       uint64 `json:"speed"` // bits per second
+	InOctets    uint64 `json:"in_octets"`
+	OutOctets   uint64 `json:"out_octets"`
+	InErrors    uint64 `json:"in_errors"`
+	OutErrors   uint64 `json:"out_errors"`
+	InDiscards  uint64 `json:"in_discards"`
+	OutDiscards uint64 `json:"out_discards"`
+}
+
+// SNMPValue is a configured OID polled from the device
+type SNMPValue struct {
+	Name  string   `json:"name"`
+	OID   string   `json:"oid"`
+	Value *float64 `json:"value,omitempty"` // numeric types
+	Text  string   `json:"text,omitempty"`  // strings, OIDs and addresses
+	Error string   `json:"error,omitempty"` // e.g. the device has no such object
+}
+
+// SensorMetrics represents hardware sensor readings
+type SensorMetrics struct {
+	Temperatures []TemperatureSensor `json:"temperatures,omitempty"`
+	Fans         []FanSensor         `json:"fans,omitempty"`
+	Power        []PowerSensor       `json:"power,omitempty"`
+}
+
+// TemperatureSensor represents a temperature reading. High and Critical are
+// zero when the sensor reports no threshold.
+type TemperatureSensor struct {
+	Key      string  `json:"key"` // chip and label, e.g. coretemp_core_0
+	Celsius  float64 `json:"celsius"`
+	High     float64 `json:"high,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// FanSensor represents a fan speed reading
+type FanSensor struct {
+	Key string  `json:"key"`
+	RPM float64 `json:"rpm"`
+}
+
+// PowerSensor represents a power draw reading
+type PowerSensor struct {
+	Key   string  `json:"key"`
+	Watts float64 `json:"watts"`
+}
+
+// ServiceStatus represents the state of a systemd unit
+type ServiceStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	LoadState   string     `json:"load_state"`       // loaded, not-found, masked, ...
+	ActiveState string     `json:"active_state"`     // active, inactive, failed, activating, ...
+	SubState    string     `json:"sub_state"`        // running, exited, dead, auto-restart, ...
+	Result      string     `json:"result,omitempty"` // success, exit-code, signal, timeout, ...
+	Restarts    uint64     `json:"restarts"`         // automatic restarts since the unit was last started manually
+	ActiveSince *time.Time `json:"active_since,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"` // when the unit last stopped with a non-success result
+}
+
 // Heartbeat represents a heartbeat message
 type Heartbeat struct {
-	DeviceID  string    `json:"device_id"`
-	Hostname  string    `json:"hostname"`
+	DeviceID      string    `json:"device_id"`
+	Hostname      string    `json:"hostname"`
+	Timestamp     time.Time `json:"timestamp"`
+	Status        string    `json:"status"`
+	Version       string    `json:"version"`
+	TenantID      string    `json:"tenant_id,omitempty"`
+	Group         string    `json:"group,omitempty"`
+	PolicyVersion int64     `json:"policy_version"`
+
+	// Resources is the agent's own resource usage
+	Resources *AgentResources `json:"resources,omitempty"`
+}
+
+// AgentResources is the resource usage of the agent process itself, and
+// whether it is running with reduced collection to stay within its limits
+type AgentResources struct {
+	CPUPercent     float64 `json:"cpu_percent"` // percent of one core since the previous check
+	RSSBytes       uint64  `json:"rss_bytes"`
+	Goroutines     int     `json:"goroutines"`
+	OpenFDs        int     `json:"open_fds,omitempty"` // not reported on Windows
+	Throttled      bool    `json:"throttled"`
+	ThrottleReason string  `json:"throttle_reason,omitempty"`
+}
+
+// EventBatch carries the log events an agent forwards at once
+type EventBatch struct {
+	DeviceID  string     `json:"device_id"`
+	Hostname  string     `json:"hostname"`
+	Timestamp time.Time  `json:"timestamp"`
+	Events    []LogEvent `json:"events"`
+
+	// Dropped counts, per source, the matching lines suppressed by the rate
+	// limit since the previous batch
+	Dropped map[string]int `json:"dropped,omitempty"`
+}
+
+// LogEvent is a log line that matched one of a source's include patterns
+type LogEvent struct {
 	Timestamp time.Time `json:"timestamp"`
-	Status    string    `json:"status"`
-	Version   string    `json:"version"`
+	Source    string    `json:"source"`
+	Path      string    `json:"path,omitempty"` // file the line was read from
+	Unit      string    `json:"unit,omitempty"` // systemd unit that logged it, for journal sources
+	Pattern   string    `json:"pattern"`        // include pattern that matched
+	Message   string    `json:"message"`
+}
+
+// Inventory is the hardware and software inventory of a device. Agents send
+// it when it changes rather than with every sample.
+type Inventory struct {
+	DeviceID  string            `json:"device_id"`
+	Hostname  string            `json:"hostname"`
+	Timestamp time.Time         `json:"timestamp"`
+	Hardware  HardwareInventory `json:"hardware"`
+	Packages  []Package         `json:"packages,omitempty"`
+}
+
+// HardwareInventory describes the hardware of a device
+type HardwareInventory struct {
+	CPU    CPUInventory     `json:"cpu"`
+	Memory []MemoryModule   `json:"memory,omitempty"`
+	Disks  []DiskInventory  `json:"disks,omitempty"`
+	NICs   []NICInventory   `json:"nics,omitempty"`
+	BIOS   BIOSInventory    `json:"bios"`
+	System ProductInventory `json:"system"`
+}
+
+// CPUInventory describes the installed processors
+type CPUInventory struct {
+	Model   string `json:"model"`
+	Vendor  string `json:"vendor,omitempty"`
+	Sockets int    `json:"sockets,omitempty"`
+	Cores   int    `json:"cores"`   // physical cores
+	Threads int    `json:"threads"` // logical processors
+}
+
+// MemoryModule is an installed RAM module, from the SMBIOS tables
+type MemoryModule struct {
+	Locator      string `json:"locator"` // slot, e.g. DIMM_A1
+	Size         uint64 `json:"size"`    // bytes
+	Type         string `json:"type,omitempty"`
+	Speed        int    `json:"speed,omitempty"` // MT/s
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Serial       string `json:"serial,omitempty"`
+	PartNumber   string `json:"part_number,omitempty"`
+}
+
+// DiskInventory is a physical disk
+type DiskInventory struct {
+	Name       string `json:"name"` // kernel name, e.g. sda or nvme0n1
+	Model      string `json:"model,omitempty"`
+	Serial     string `json:"serial,omitempty"`
+	Size       uint64 `json:"size"` // bytes
+	Rotational bool   `json:"rotational"`
+}
+
+// NICInventory is a network interface
+type NICInventory struct {
+	Name      string `json:"name"`
+	MAC       string `json:"mac,omitempty"`
+	MTU       int    `json:"mtu,omitempty"`
+	SpeedMbps int    `json:"speed_mbps,omitempty"` // 0 when unknown or down
+	Driver    string `json:"driver,omitempty"`
+}
+
+// BIOSInventory describes the system firmware
+type BIOSInventory struct {
+	Vendor  string `json:"vendor,omitempty"`
+	Version string `json:"version,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+// ProductInventory identifies the machine, from the DMI system information
+type ProductInventory struct {
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Serial       string `json:"serial,omitempty"`
+	UUID         string `json:"uuid,omitempty"`
+}
+
+// Package is an installed software package
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+	Manager string `json:"manager"` // dpkg or rpm
 }
 
+// InventoryChange is one difference between two snapshots of a device
+type InventoryChange struct {
+	Category string `json:"category"` // hostname, os, kernel, system, bios, cpu, memory, disk, nic or package
+	Item     string `json:"item"`     // e.g. package name, disk serial or memory slot
+	Action   string `json:"action"`   // added, removed or changed
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+}
+
+// InventoryRevision records the changes found when a device reported system
+// information or an inventory that differed from its previous snapshot
+type InventoryRevision struct {
+	Version   int64             `json:"version"` // snapshot version the changes led to
+	Timestamp time.Time         `json:"timestamp"`
+	Changes   []InventoryChange `json:"changes"`
+}
+
+// InventorySnapshot is a version of what is known about a device: its system
+// information from metrics and its latest inventory
+type InventorySnapshot struct {
+	Version   int64       `json:"version"`
+	Timestamp time.Time   `json:"timestamp"`
+	System    *SystemInfo `json:"system,omitempty"`
+	Inventory *Inventory  `json:"inventory,omitempty"`
+}
+
+// RemoteSample represents a single sample received via Prometheus remote write
+type RemoteSample struct {
+	DeviceID  string            `json:"device_id"`
+	Hostname  string            `json:"hostname"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// AgentPolicy represents centrally managed agent settings assigned to a
+// tenant, group or device. Nil fields leave the setting to lower-precedence
+// policies or the agent's local configuration.
+type AgentPolicy struct {
+	Scope             string             `json:"scope"`
+	Target            string             `json:"target"`
+	Version           int64              `json:"version"`
+	CheckInterval     *int               `json:"check_interval,omitempty"`
+	HeartbeatInterval *int               `json:"heartbeat_interval,omitempty"`
+	Collectors        map[string]bool    `json:"collectors,omitempty"`
+	Thresholds        map[string]float64 `json:"thresholds,omitempty"`
+	AgentVersion      string             `json:"agent_version,omitempty"` // release agents should run
+	UpdatedAt         time.Time          `json:"updated_at"`
+}
+
+// EffectivePolicy is the merged policy delivered to a single agent
+type EffectivePolicy struct {
+	DeviceID          string             `json:"device_id"`
+	Version           int64              `json:"version"`
+	CheckInterval     *int               `json:"check_interval,omitempty"`
+	HeartbeatInterval *int               `json:"heartbeat_interval,omitempty"`
+	Collectors        map[string]bool    `json:"collectors,omitempty"`
+	Thresholds        map[string]float64 `json:"thresholds,omitempty"`
+	AgentVersion      string             `json:"agent_version,omitempty"`
+	Update            *AgentUpdate       `json:"update,omitempty"`  // binary for the agent's platform, when AgentVersion has one
+	Sources           []string           `json:"sources,omitempty"` // scope:target of each contributing policy, lowest precedence first
+}
+
+// AgentRelease is a published agent version and its signed binaries
+type AgentRelease struct {
+	Version   string          `json:"version"`
+	Artifacts []AgentArtifact `json:"artifacts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AgentArtifact is the agent binary for one platform
+type AgentArtifact struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`    // hex digest of the binary
+	Signature string `json:"signature"` // base64 Ed25519 signature of the binary
+}
+
+// AgentUpdate tells an agent which binary to upgrade to
+type AgentUpdate struct {
+	Version   string `json:"version"`
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// AgentMessage is a message pushed to agents over WebSocket
+type AgentMessage struct {
+	Type   string           `json:"type"`
+	Policy *EffectivePolicy `json:"policy,omitempty"`
+}